@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/log"
+	"github.com/nsxbet/mcpshield/pkg/bridge"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -99,6 +105,42 @@ var tokenCmd = &cobra.Command{
 	},
 }
 
+var connectCmd = &cobra.Command{
+	Use:   "connect",
+	Short: "Connect an MCP client to MCPShield",
+	Long:  `Serve MCP over stdio to the local client and relay it to the MCPShield service, attaching the stored token and refreshing it before it expires.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// stdout carries the protocol, so nothing else may be printed there
+		endpoint := strings.TrimSuffix(viper.GetString("api.endpoint"), "/") + "/mcp"
+		tokens := bridge.NewFileTokenSource(
+			expandHome(viper.GetString("auth.token_path")),
+			time.Duration(viper.GetInt("auth.refresh_threshold"))*time.Second,
+			viper.GetString("auth.refresh_url"),
+		)
+		
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		
+		logger.Info("Connecting to MCPShield", "endpoint", endpoint)
+		if err := bridge.New(endpoint, tokens, logger).Run(ctx, os.Stdin, os.Stdout); err != nil {
+			logger.Error("Connection failed", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// expandHome resolves a leading ~ as written in the config file
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
+
 func init() {
 	cobra.OnInitialize(initConfig)
 	
@@ -110,6 +152,7 @@ func init() {
 	authCmd.AddCommand(loginCmd)
 	authCmd.AddCommand(tokenCmd)
 	rootCmd.AddCommand(authCmd)
+	rootCmd.AddCommand(connectCmd)
 }
 
 func initConfig() {
@@ -41,40 +41,8 @@ var runCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println(titleStyle.Render("🚀 Starting MCPShield Server"))
 		
-		// Determine config file path
-		configPath := "/app/config.yaml"
-		if cfgFile != "" {
-			configPath = cfgFile
-		}
-		
-		// Read configuration
-		config, err := pkg.ReadConfig(configPath)
-		if err != nil {
-			logger.Error("Failed to read config", "error", err, "path", configPath)
-			os.Exit(1)
-		}
-		
-		// Set log level from config
-		verbose, _ := cmd.Flags().GetBool("verbose")
-		if verbose {
-			logger.SetLevel(log.DebugLevel)
-		} else {
-			switch config.GetLogLevel() {
-			case "debug":
-				logger.SetLevel(log.DebugLevel)
-			case "info":
-				logger.SetLevel(log.InfoLevel)
-			case "warn":
-				logger.SetLevel(log.WarnLevel)
-			case "error":
-				logger.SetLevel(log.ErrorLevel)
-			default:
-				logger.SetLevel(log.InfoLevel)
-			}
-		}
-		
+		config := loadConfig(cmd)
 		logger.Info("Server configuration", "address", config.GetServerAddress(), "namespace", config.GetKubernetesNamespace())
-		logger.Debug("Using config file", "file", configPath)
 		
 		if err := StartServer(config); err != nil {
 			logger.Error("Failed to start server", "error", err)
@@ -83,11 +51,65 @@ var runCmd = &cobra.Command{
 	},
 }
 
+var stdioCmd = &cobra.Command{
+	Use:   "stdio",
+	Short: "Serve a single client over stdin/stdout",
+	Long:  `Serve the proxy over the MCP stdio transport, for clients that launch MCP servers as subprocesses. Logs go to stderr.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := loadConfig(cmd)
+		
+		if err := StartStdio(config); err != nil {
+			logger.Error("Failed to serve stdio", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// loadConfig reads the configuration and applies its log level, exiting when
+// it cannot be read
+func loadConfig(cmd *cobra.Command) *pkg.Config {
+	// Determine config file path
+	configPath := "/app/config.yaml"
+	if cfgFile != "" {
+		configPath = cfgFile
+	}
+	
+	// Read configuration
+	config, err := pkg.ReadConfig(configPath)
+	if err != nil {
+		logger.Error("Failed to read config", "error", err, "path", configPath)
+		os.Exit(1)
+	}
+	
+	// Set log level from config
+	verbose, _ := cmd.Flags().GetBool("verbose")
+	if verbose {
+		logger.SetLevel(log.DebugLevel)
+	} else {
+		switch config.GetLogLevel() {
+		case "debug":
+			logger.SetLevel(log.DebugLevel)
+		case "info":
+			logger.SetLevel(log.InfoLevel)
+		case "warn":
+			logger.SetLevel(log.WarnLevel)
+		case "error":
+			logger.SetLevel(log.ErrorLevel)
+		default:
+			logger.SetLevel(log.InfoLevel)
+		}
+	}
+	
+	logger.Debug("Using config file", "file", configPath)
+	return config
+}
+
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file (default is /app/config.yaml)")
 	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "verbose output")
 	
 	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(stdioCmd)
 }
 
 func main() {
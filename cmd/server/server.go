@@ -7,32 +7,173 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/nsxbet/mcpshield/pkg"
+	"github.com/nsxbet/mcpshield/pkg/auth"
 	"github.com/nsxbet/mcpshield/pkg/mcpserver"
 	"github.com/nsxbet/mcpshield/pkg/runtime"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
-// StartServer initializes and starts the HTTP server
-func StartServer(config *pkg.Config) error {
-	// Validate required configuration
-	if !config.HasKubernetesRuntime() {
-		logger.Error("Kubernetes runtime configuration is required")
-		return fmt.Errorf("kubernetes runtime configuration is required")
+// newRuntimeFactory creates the runtime factory for remote servers, backed by
+// the configured local runtime for everything else
+func newRuntimeFactory(config *pkg.Config) (pkg.RuntimeFactory, error) {
+	local, err := newLocalRuntimeFactory(config)
+	if err != nil {
+		return nil, err
+	}
+	return runtime.NewRemoteRuntimeFactory(local), nil
+}
+
+// newLocalRuntimeFactory creates the factory for the single configured runtime
+func newLocalRuntimeFactory(config *pkg.Config) (pkg.RuntimeFactory, error) {
+	runtimes := config.ConfiguredRuntimes()
+	if len(runtimes) == 0 && !config.HasLocalMCPServers() {
+		return nil, nil
+	}
+	if len(runtimes) == 0 {
+		return nil, fmt.Errorf("a runtime configuration is required (runtime.kubernetes, runtime.docker or runtime.process)")
+	}
+	if len(runtimes) > 1 {
+		return nil, fmt.Errorf("only one runtime can be configured, got %s", strings.Join(runtimes, ", "))
+	}
+	
+	switch runtimes[0] {
+	case "process":
+		logger.Warn("Using the process runtime: MCP servers run unsandboxed on this host")
+		return runtime.NewProcessRuntimeFactory(config.GetProcessWorkDir(), logger), nil
+	case "docker":
+		return runtime.NewDockerRuntimeFactory(config.GetDockerHost(), config.GetDockerNetwork(), logger)
+	default:
+		// Create runtime factory with configured namespace and kubeconfig
+		return runtime.NewKubernetesRuntimeFactoryWithKubeconfig(config.GetKubernetesNamespace(), config.GetKubeconfig())
 	}
+}
 
-	// Create runtime factory with configured namespace and kubeconfig
-	factory, err := runtime.NewKubernetesRuntimeFactoryWithKubeconfig(config.GetKubernetesNamespace(), config.GetKubeconfig())
+// newAuth sets up authentication, chaining the configured authenticators
+// with JWT first since it needs no call to the API server, and authorization
+// of tool calls. Cached decisions are dropped whenever the permissions change,
+// until ctx is done.
+func newAuth(ctx context.Context, config *pkg.Config, proxy *mcpserver.Proxy) (*auth.Auth, error) {
+	var client kubernetes.Interface
+	var clientConfig clientcmd.ClientConfig
+	kubernetesClient := func() (kubernetes.Interface, error) {
+		if client == nil {
+			clientset, loaded, err := runtime.CreateKubernetesClientWithKubeconfig(config.GetKubeconfig())
+			if err != nil {
+				return nil, err
+			}
+			client, clientConfig = clientset, loaded
+		}
+		return client, nil
+	}
+	
+	var authenticators []auth.Authenticator
+	if config.Auth.JWT != nil {
+		authenticators = append(authenticators, auth.NewJWTAuthenticator(config.Auth.JWT, nil))
+		logger.Info("Authenticating clients with JWT", "issuer", config.Auth.JWT.Issuer)
+	}
+	if review := config.Auth.TokenReview; review != nil {
+		client, err := kubernetesClient()
+		if err != nil {
+			return nil, fmt.Errorf("token review needs a Kubernetes client: %w", err)
+		}
+		authenticators = append(authenticators, auth.NewTokenReviewAuthenticator(client, review.Audiences, review.GetCacheTTL(), review.GetNegativeCacheTTL()))
+		logger.Info("Authenticating clients with TokenReview", "audiences", review.Audiences)
+	}
+	
+	a := auth.New(client, auth.Chain(authenticators...))
+	a.SetToolResolver(proxy.ResolveTool)
+	authorization := config.Auth.Authorization
+	if authorization == nil {
+		return a, nil
+	}
+	client, err := kubernetesClient()
+	if err != nil {
+		return nil, fmt.Errorf("authorization needs a Kubernetes client: %w", err)
+	}
+	
+	switch authorization.Mode {
+	case pkg.AuthorizationMCPPermission:
+		restConfig, err := clientConfig.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		dynamicClient, err := dynamic.NewForConfig(restConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+		}
+		permissions := auth.NewPermissionAuthorizer(dynamicClient)
+		report := make(chan struct{}, 1)
+		onChange := func() {
+			a.InvalidatePermissions()
+			select {
+			case report <- struct{}{}:
+			default:
+			}
+		}
+		if err := permissions.Start(ctx, onChange); err != nil {
+			return nil, err
+		}
+		a.SetAuthorizer(permissions)
+		go reportPermissionStatus(ctx, permissions, proxy, report)
+		logger.Info("Authorizing tool calls with MCPPermission", "resource", auth.MCPPermissions.GroupResource())
+	default:
+		reviews := auth.NewSubjectAccessReviewAuthorizer(client, authorization.Namespace, authorization.GetAllowedCacheTTL(), authorization.GetDeniedCacheTTL())
+		reviews.UsernamePrefix = authorization.GetJWTUsernamePrefix()
+		reviews.GroupsPrefix = authorization.GetJWTGroupsPrefix()
+		a.SetAuthorizer(reviews)
+		if err := auth.WatchRBAC(ctx, client, authorization.Namespace, a.InvalidatePermissions); err != nil {
+			return nil, fmt.Errorf("failed to watch RBAC: %w", err)
+		}
+		logger.Info("Authorizing tool calls with SubjectAccessReview", "apiGroup", auth.APIGroup, "namespace", authorization.Namespace)
+	}
+	return a, nil
+}
+
+// permissionStatusInterval is how often the tools matched by each
+// MCPPermission are reported, to follow the tools servers offer
+const permissionStatusInterval = 30 * time.Second
+
+// reportPermissionStatus keeps the status of the MCPPermission objects up to
+// date, when they change and periodically, until ctx is done
+func reportPermissionStatus(ctx context.Context, permissions *auth.PermissionAuthorizer, proxy *mcpserver.Proxy, changed <-chan struct{}) {
+	ticker := time.NewTicker(permissionStatusInterval)
+	defer ticker.Stop()
+	
+	for {
+		if err := permissions.ReportStatus(ctx, proxy.ServerTools()); err != nil {
+			logger.Warn("Failed to report MCPPermission status", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-changed:
+		}
+	}
+}
+
+// StartServer initializes and starts the HTTP server
+func StartServer(config *pkg.Config) error {
+	factory, err := newRuntimeFactory(config)
 	if err != nil {
 		logger.Error("Failed to create runtime factory", "error", err)
 		return err
 	}
 
 	// Create proxy with servers
-	proxy := mcpserver.NewProxy(config, factory)
+	proxy, err := mcpserver.NewProxy(config, factory)
+	if err != nil {
+		logger.Error("Failed to create proxy", "error", err)
+		return err
+	}
 
 	// Start all MCP servers
 	ctx, cancel := context.WithCancel(context.Background())
@@ -44,6 +185,20 @@ func StartServer(config *pkg.Config) error {
 		return err
 	}
 
+	// Clients must present a bearer token when authentication is configured
+	protect := func(handler http.Handler) http.Handler { return handler }
+	if config.HasAuthentication() {
+		a, err := newAuth(ctx, config, proxy)
+		if err != nil {
+			logger.Error("Failed to set up authentication", "error", err)
+			return err
+		}
+		protect = auth.Middleware(a)
+		proxy.Use(proxy.AuthorizeRequests(a))
+	} else {
+		logger.Warn("Authentication is not configured: clients are not authenticated")
+	}
+	
 	mux := http.NewServeMux()
 	
 	// Health route
@@ -67,8 +222,14 @@ func StartServer(config *pkg.Config) error {
 		})
 	})
 	
+	// Admin route reporting the negotiated protocol versions
+	mux.Handle("/admin/versions", protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proxy.ProtocolVersions())
+	})))
+	
 	// MCP route - single endpoint for JSON-RPC compatibility
-	mux.Handle("/mcp", proxy)
+	mux.Handle("/mcp", protect(proxy))
 	
 	// Use configured server settings
 	srv := &http.Server{
@@ -99,4 +260,71 @@ func StartServer(config *pkg.Config) error {
 	proxy.Stop(ctx)
 	
 	return srv.Shutdown(ctx)
-} 
\ No newline at end of file
+} 
+
+// stdioTokenEnv names the variable holding the token the stdio client is
+// authenticated with, since the transport has no headers to carry one
+const stdioTokenEnv = "MCPSHIELD_TOKEN"
+
+// StartStdio serves the proxy to a single client over stdin/stdout. Stdout
+// carries the protocol, so everything else the server prints goes to stderr.
+func StartStdio(config *pkg.Config) error {
+	protocolOut := os.Stdout
+	os.Stdout = os.Stderr
+	
+	factory, err := newRuntimeFactory(config)
+	if err != nil {
+		logger.Error("Failed to create runtime factory", "error", err)
+		return err
+	}
+	
+	proxy, err := mcpserver.NewProxy(config, factory)
+	if err != nil {
+		logger.Error("Failed to create proxy", "error", err)
+		return err
+	}
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	
+	if err := proxy.Start(ctx); err != nil {
+		logger.Error("Failed to start MCP servers", "error", err)
+		return err
+	}
+	
+	// The client is checked like an HTTP one, with the token from the
+	// environment standing in for the bearer token
+	if config.HasAuthentication() {
+		token := os.Getenv(stdioTokenEnv)
+		if token == "" {
+			proxy.Stop(ctx)
+			return fmt.Errorf("authentication is configured: set %s to the token to serve stdio with", stdioTokenEnv)
+		}
+		a, err := newAuth(ctx, config, proxy)
+		if err == nil {
+			_, err = a.Authenticate(ctx, token)
+		}
+		if err != nil {
+			logger.Error("Failed to set up authentication", "error", err)
+			proxy.Stop(ctx)
+			return err
+		}
+		proxy.Use(mcpserver.AuthenticateRequests(a, token))
+		proxy.Use(proxy.AuthorizeRequests(a))
+	} else {
+		logger.Warn("Authentication is not configured: the stdio client is not authenticated")
+	}
+	
+	// The client ends the session by closing stdin or with a signal
+	serveCtx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	
+	logger.Info("MCP Bridge Proxy serving stdio", "servers", proxy.GetServerCount())
+	err = proxy.ServeStdio(serveCtx, os.Stdin, protocolOut)
+	
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelShutdown()
+	proxy.Stop(shutdownCtx)
+	
+	return err
+}
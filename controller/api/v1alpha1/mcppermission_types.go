@@ -0,0 +1,85 @@
+/*
+Copyright 2025 Yuri Lima.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MCPPermissionSubject selects who a permission applies to, by exactly one
+// of email domain, group or email.
+// +kubebuilder:validation:MinProperties=1
+// +kubebuilder:validation:MaxProperties=1
+type MCPPermissionSubject struct {
+	// EmailDomain matches every user whose email is in the domain, like nsx.bet
+	EmailDomain string `json:"emailDomain,omitempty"`
+
+	// EmailGroup matches the members of a group, like sre@nsx.bet
+	EmailGroup string `json:"emailGroup,omitempty"`
+
+	// Email matches a single user
+	Email string `json:"email,omitempty"`
+}
+
+// MCPPermissionSpec defines the tools the subjects may call.
+type MCPPermissionSpec struct {
+	// Subjects the permission applies to; matching any of them is enough
+	// +kubebuilder:validation:MinItems=1
+	Subjects []MCPPermissionSubject `json:"subjects"`
+
+	// Servers maps server names to the tools allowed on them, as glob
+	// patterns like search_* or *. Permissions matching a user add up.
+	Servers map[string][]string `json:"servers"`
+}
+
+// MCPPermissionStatus defines the observed state of MCPPermission.
+type MCPPermissionStatus struct {
+	// ObservedGeneration is the generation the matched tools were computed for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// MatchedTools lists, per server, the tools the patterns currently match
+	// +optional
+	MatchedTools map[string][]string `json:"matchedTools,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster,shortName=mcpperm
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// MCPPermission is the Schema for the mcppermissions API.
+type MCPPermission struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MCPPermissionSpec   `json:"spec,omitempty"`
+	Status MCPPermissionStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MCPPermissionList contains a list of MCPPermission.
+type MCPPermissionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MCPPermission `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MCPPermission{}, &MCPPermissionList{})
+}
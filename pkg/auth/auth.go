@@ -1,42 +1,232 @@
 package auth
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/nsxbet/mcpshield/pkg"
 	"k8s.io/client-go/kubernetes"
 )
 
+// Authenticator resolves a bearer token to the principal presenting it
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+}
+
+// chain tries authenticators in order
+type chain []Authenticator
+
+// Chain accepts a token any of the authenticators accepts, trying them in
+// order, e.g. JWT for humans with SSO tokens and TokenReview for agents with
+// service account tokens
+func Chain(authenticators ...Authenticator) Authenticator {
+	if len(authenticators) == 1 {
+		return authenticators[0]
+	}
+	return chain(authenticators)
+}
+
+func (c chain) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	var reasons []string
+	for _, authenticator := range c {
+		principal, err := authenticator.Authenticate(ctx, token)
+		if err == nil {
+			return principal, nil
+		}
+		reasons = append(reasons, err.Error())
+	}
+	return nil, &AuthError{Code: "invalid_token", Message: strings.Join(reasons, "; ")}
+}
+
+// Authorizer decides whether a principal may call a tool of a server
+type Authorizer interface {
+	Authorize(ctx context.Context, principal *Principal, server, tool string) (bool, error)
+}
+
+// ToolResolver maps the name clients call a tool by to the server offering
+// it and the name the server knows it by
+type ToolResolver func(name string) (server string, tool string, ok bool)
+
 // Auth handles authentication and authorization
 type Auth struct {
-	client kubernetes.Interface
+	client        kubernetes.Interface
+	authenticator Authenticator
+	authorizer    Authorizer
+	resolveTool   ToolResolver
+	// toolLists caches the tools each principal may see
+	toolLists     *ttlCache
 }
 
-// New creates a new Auth instance
-func New(client kubernetes.Interface) *Auth {
-	return &Auth{client: client}
+// Verbs for what principals do with a server besides calling its tools,
+// named after the MCP methods; tool names cannot contain a slash, so they do
+// not clash with tools
+const (
+	VerbPrompts   = "prompts/get"
+	VerbResources = "resources/read"
+	VerbLogging   = "logging/setLevel"
+)
+
+// toolListTTL bounds how long a principal sees a tool list after their
+// permissions changed in ways that are not watched
+const toolListTTL = time.Minute
+
+// maxConcurrentAuthorizations bounds the checks made at once for a list
+const maxConcurrentAuthorizations = 8
+
+// New creates a new Auth instance validating tokens with authenticator
+func New(client kubernetes.Interface, authenticator Authenticator) *Auth {
+	return &Auth{client: client, authenticator: authenticator, toolLists: newTTLCache()}
+}
+
+// SetAuthorizer makes tool calls subject to authorizer; without one every
+// authenticated principal may call every tool
+func (a *Auth) SetAuthorizer(authorizer Authorizer) {
+	a.authorizer = authorizer
+}
+
+// SetToolResolver sets how tool names are mapped back to their server
+func (a *Auth) SetToolResolver(resolver ToolResolver) {
+	a.resolveTool = resolver
 }
 
 // Authenticate validates a token and returns principal info
-func (a *Auth) Authenticate(token string) (*Principal, error) {
-	// Basic implementation for testing - in real implementation would call TokenReview API
+func (a *Auth) Authenticate(ctx context.Context, token string) (*Principal, error) {
 	if token == "" {
 		return nil, &AuthError{Code: "invalid_token", Message: "empty token"}
 	}
+	if a.authenticator == nil {
+		return nil, &AuthError{Code: "invalid_token", Message: "no authenticator configured"}
+	}
 	
-	return &Principal{
-		Username: "test-user",
-		ServiceAccount: "default",
-		Namespace: "default",
-	}, nil
+	return a.authenticator.Authenticate(ctx, token)
 }
 
-// FetchAvailableTools returns tools the user can access (for tools/list)
-func (a *Auth) FetchAvailableTools(principal *Principal, request *pkg.MCPRequest) (interface{}, error) {
-	// Implementation needed - tools source TBD
-	return []string{"placeholder"}, nil
+// FetchAvailableTools returns the tools the user can access (for tools/list),
+// out of the tool definitions the proxy would list. The names allowed are
+// cached per principal and set of tools.
+func (a *Auth) FetchAvailableTools(ctx context.Context, principal *Principal, tools []interface{}) ([]interface{}, error) {
+	if a.authorizer == nil {
+		return tools, nil
+	}
+	if principal == nil || a.resolveTool == nil {
+		return []interface{}{}, nil
+	}
+	
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		definition, _ := tool.(map[string]interface{})
+		name, _ := definition["name"].(string)
+		names = append(names, name)
+	}
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	key := cacheKeyFor(principal) + "|" + tokenKey(strings.Join(sorted, "\n"))
+	
+	allowed, ok := a.toolLists.get(key)
+	if !ok {
+		visible, err := a.allowedTools(ctx, principal, names)
+		if err != nil {
+			return nil, err
+		}
+		a.toolLists.set(key, visible, toolListTTL)
+		allowed = visible
+	}
+	
+	visible := allowed.(map[string]bool)
+	available := []interface{}{}
+	for i, tool := range tools {
+		if visible[names[i]] {
+			available = append(available, tool)
+		}
+	}
+	return available, nil
 }
 
-// VerifyToolCall checks if user can execute a tool (for tools/call)
-func (a *Auth) VerifyToolCall(principal *Principal, request *pkg.MCPRequest) error {
-	// Implementation needed - parse tool name and call SubjectAccessReview API using a.client
+// allowedTools asks the authorizer about every tool, a few at a time
+func (a *Auth) allowedTools(ctx context.Context, principal *Principal, names []string) (map[string]bool, error) {
+	visible := make(map[string]bool, len(names))
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, maxConcurrentAuthorizations)
+	
+	for _, name := range names {
+		server, tool, ok := a.resolveTool(name)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(name string) {
+			defer func() { <-limit; wg.Done() }()
+			allowed, err := a.authorizer.Authorize(ctx, principal, server, tool)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			visible[name] = allowed
+		}(name)
+	}
+	wg.Wait()
+	
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return visible, nil
+}
+
+// Allowed reports whether the principal may use verb on server, a tool name
+// or one of the Verb constants
+func (a *Auth) Allowed(ctx context.Context, principal *Principal, server, verb string) (bool, error) {
+	if a.authorizer == nil {
+		return true, nil
+	}
+	if principal == nil {
+		return false, nil
+	}
+	return a.authorizer.Authorize(ctx, principal, server, verb)
+}
+
+// InvalidatePermissions forgets every cached decision, for when permissions
+// changed
+func (a *Auth) InvalidatePermissions() {
+	a.toolLists.clear()
+	if invalidator, ok := a.authorizer.(interface{ Invalidate() }); ok {
+		invalidator.Invalidate()
+	}
+}
+
+// VerifyToolCall checks if user can execute a tool (for tools/call). Calls of
+// tools no server offers are left for the proxy to answer.
+func (a *Auth) VerifyToolCall(ctx context.Context, principal *Principal, request *pkg.MCPRequest) error {
+	if a.authorizer == nil {
+		return nil
+	}
+	if principal == nil {
+		return &AuthError{Code: "forbidden", Message: "request is not authenticated"}
+	}
+	
+	params, _ := request.Params.(map[string]interface{})
+	name, _ := params["name"].(string)
+	if a.resolveTool == nil {
+		return &AuthError{Code: "forbidden", Message: "cannot resolve tool " + name}
+	}
+	server, tool, ok := a.resolveTool(name)
+	if !ok {
+		return nil
+	}
+	
+	allowed, err := a.authorizer.Authorize(ctx, principal, server, tool)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return &AuthError{Code: "forbidden", Message: fmt.Sprintf("%s may not call %s", principal.Username, name)}
+	}
 	return nil
 } 
\ No newline at end of file
@@ -1,21 +1,35 @@
 package auth
 
 import (
+	"context"
 	"testing"
 
 	"github.com/nsxbet/mcpshield/pkg"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
+// staticAuthenticator accepts a single token
+type staticAuthenticator struct {
+	token     string
+	principal *Principal
+}
+
+func (s *staticAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if token != s.token {
+		return nil, &AuthError{Code: "invalid_token", Message: "unknown token"}
+	}
+	return s.principal, nil
+}
+
 func TestAuth_CompleteFlow(t *testing.T) {
 	// Use fake Kubernetes client
 	fakeClient := fake.NewSimpleClientset()
 	
 	// Create auth
-	a := New(fakeClient)
+	a := New(fakeClient, &staticAuthenticator{token: "valid-token", principal: &Principal{Username: "dev@nsx.bet"}})
 
 	// Test 1: Authenticate - should return principal info
-	principal, err := a.Authenticate("valid-token")
+	principal, err := a.Authenticate(context.Background(), "valid-token")
 	if err != nil {
 		t.Errorf("unexpected authentication error: %v", err)
 	}
@@ -27,15 +41,15 @@ func TestAuth_CompleteFlow(t *testing.T) {
 	}
 
 	// Test 2: FetchAvailableTools (tools/list)
-	toolsListRequest := &pkg.MCPRequest{
-		Method: "tools/list",
+	listed := []interface{}{
+		map[string]interface{}{"name": "ms_github-npx_search_repositories"},
 	}
-	tools, err := a.FetchAvailableTools(principal, toolsListRequest)
+	tools, err := a.FetchAvailableTools(context.Background(), principal, listed)
 	if err != nil {
 		t.Errorf("unexpected error fetching tools: %v", err)
 	}
-	if tools == nil {
-		t.Errorf("expected tools list, got nil")
+	if len(tools) != 1 {
+		t.Errorf("expected every tool without an authorizer, got %v", tools)
 	}
 
 	// Test 3: VerifyToolCall (tools/call)
@@ -45,7 +59,7 @@ func TestAuth_CompleteFlow(t *testing.T) {
 			"name": "ms_github-npx_search_repositories",
 		},
 	}
-	err = a.VerifyToolCall(principal, toolCallRequest)
+	err = a.VerifyToolCall(context.Background(), principal, toolCallRequest)
 	if err != nil {
 		t.Errorf("unexpected error verifying tool call: %v", err)
 	}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// maxCacheEntries bounds a cache; past it expired entries are swept and, if
+// that is not enough, everything is dropped
+const maxCacheEntries = 10000
+
+type cacheEntry struct {
+	value  interface{}
+	expiry time.Time
+}
+
+// ttlCache remembers values for a while
+type ttlCache struct {
+	entries map[string]cacheEntry
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+func newTTLCache() *ttlCache {
+	return &ttlCache{entries: make(map[string]cacheEntry), now: time.Now}
+}
+
+func (c *ttlCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || c.now().After(entry.expiry) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *ttlCache) set(key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxCacheEntries {
+		now := c.now()
+		for key, entry := range c.entries {
+			if now.After(entry.expiry) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			c.entries = make(map[string]cacheEntry)
+		}
+	}
+	c.entries[key] = cacheEntry{value: value, expiry: c.now().Add(ttl)}
+}
+
+// clear forgets everything
+func (c *ttlCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cacheEntry)
+}
+
+// tokenKey keys caches by a hash so that tokens are not kept in memory
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// keySetTTL is how long fetched keys are trusted before they are fetched
+	// again
+	keySetTTL = time.Hour
+	// keySetMinRefresh limits fetching on unknown key ids, so that tokens with
+	// made-up ids cannot hammer the provider
+	keySetMinRefresh = time.Minute
+	// keySetRetryInterval is how long a failed fetch is waited out before the
+	// provider is asked again
+	keySetRetryInterval = 10 * time.Second
+	// keySetFetchTimeout bounds a fetch, which every waiting request shares
+	keySetFetchTimeout = 30 * time.Second
+)
+
+// keySet holds the signing keys of an OIDC provider, found through discovery
+// unless the JWKS URL is configured. Keys are fetched again when they get old
+// or a token names a key not seen yet, which is how rotation shows up. One
+// fetch runs at a time and the keys held keep verifying tokens meanwhile, or
+// when it fails.
+type keySet struct {
+	issuer  string
+	jwksURL string
+	client  *http.Client
+	now     func() time.Time
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	// attempted is when the last fetch started, whether or not it worked
+	attempted time.Time
+	// fetching is closed when the fetch in progress is done
+	fetching chan struct{}
+	fetchErr error
+	mu       sync.Mutex
+}
+
+func newKeySet(issuer, jwksURL string, client *http.Client) *keySet {
+	return &keySet{
+		issuer:  issuer,
+		jwksURL: jwksURL,
+		client:  client,
+		now:     time.Now,
+	}
+}
+
+// key returns the key with the given id; tokens without one can use a key set
+// holding a single key
+func (k *keySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k.mu.Lock()
+	now := k.now()
+	age := now.Sub(k.fetched)
+	_, known := k.keys[kid]
+	stale := k.keys == nil || age > keySetTTL || (!known && age > keySetMinRefresh)
+	if stale && (k.fetching != nil || now.Sub(k.attempted) > keySetRetryInterval) {
+		fetching := k.refresh()
+		k.mu.Unlock()
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		k.mu.Lock()
+	}
+	keys, err := k.keys, k.fetchErr
+	k.mu.Unlock()
+
+	// A provider that is briefly unreachable should not lock everyone out
+	// while the keys held still verify tokens
+	if keys == nil {
+		return nil, err
+	}
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// refresh starts fetching the keys unless a fetch is in progress and returns
+// the channel closed once it is done. k.mu must be held.
+func (k *keySet) refresh() <-chan struct{} {
+	if k.fetching != nil {
+		return k.fetching
+	}
+	fetching := make(chan struct{})
+	k.fetching = fetching
+	k.attempted = k.now()
+
+	go func() {
+		// The fetch is shared, so no single request's context bounds it
+		ctx, cancel := context.WithTimeout(context.Background(), keySetFetchTimeout)
+		defer cancel()
+		keys, err := k.fetch(ctx)
+
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		k.fetchErr = err
+		if err == nil {
+			k.keys = keys
+			k.fetched = k.now()
+		}
+		k.fetching = nil
+		close(fetching)
+	}()
+	return fetching
+}
+
+// fetch gets the key set; only the fetch in progress calls it
+func (k *keySet) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	if k.jwksURL == "" {
+		jwksURL, err := k.discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+		k.jwksURL = jwksURL
+	}
+
+	var document struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := k.get(ctx, k.jwksURL, &document); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(document.Keys))
+	for _, raw := range document.Keys {
+		// Keys of types we do not verify with are skipped, not fatal
+		if kid, key, err := parseJWK(raw); err == nil {
+			keys[kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no usable signing keys at %s", k.jwksURL)
+	}
+	return keys, nil
+}
+
+// discover finds the JWKS URL in the provider's OIDC configuration
+func (k *keySet) discover(ctx context.Context) (string, error) {
+	var configuration struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	url := strings.TrimSuffix(k.issuer, "/") + "/.well-known/openid-configuration"
+	if err := k.get(ctx, url, &configuration); err != nil {
+		return "", fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if configuration.Issuer != k.issuer {
+		return "", fmt.Errorf("OIDC discovery returned issuer %q, expected %q", configuration.Issuer, k.issuer)
+	}
+	if configuration.JWKSURI == "" {
+		return "", fmt.Errorf("OIDC discovery returned no jwks_uri")
+	}
+	return configuration.JWKSURI, nil
+}
+
+func (k *keySet) get(ctx context.Context, url string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+func parseJWK(raw json.RawMessage) (string, crypto.PublicKey, error) {
+	var jwk struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return "", nil, err
+	}
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return "", nil, fmt.Errorf("key %s is not for signing", jwk.Kid)
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeInt(jwk.N)
+		if err != nil {
+			return "", nil, err
+		}
+		e, err := decodeInt(jwk.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31 {
+			return "", nil, fmt.Errorf("key %s has an invalid exponent", jwk.Kid)
+		}
+		return jwk.Kid, &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return "", nil, fmt.Errorf("key %s uses unsupported curve %s", jwk.Kid, jwk.Crv)
+		}
+		x, err := decodeInt(jwk.X)
+		if err != nil {
+			return "", nil, err
+		}
+		y, err := decodeInt(jwk.Y)
+		if err != nil {
+			return "", nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return "", nil, fmt.Errorf("key %s is not on curve %s", jwk.Kid, jwk.Crv)
+		}
+		return jwk.Kid, &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return "", nil, fmt.Errorf("key %s has unsupported type %s", jwk.Kid, jwk.Kty)
+	}
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+// clockSkew tolerates clocks of the provider and the shield disagreeing
+const clockSkew = time.Minute
+
+// JWTAuthenticator validates bearer tokens issued by an OIDC provider and maps
+// their claims into a Principal
+type JWTAuthenticator struct {
+	config *pkg.JWTConfig
+	keys   *keySet
+	now    func() time.Time
+}
+
+func NewJWTAuthenticator(config *pkg.JWTConfig, client *http.Client) *JWTAuthenticator {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &JWTAuthenticator{
+		config: config,
+		keys:   newKeySet(config.Issuer, config.JWKSURL, client),
+		now:    time.Now,
+	}
+}
+
+func invalidToken(format string, args ...interface{}) *AuthError {
+	return &AuthError{Code: "invalid_token", Message: fmt.Sprintf(format, args...)}
+}
+
+// Authenticate verifies the token's signature, issuer, audience and validity
+// period
+func (j *JWTAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalidToken("token is not a JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, invalidToken("invalid token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalidToken("invalid token signature")
+	}
+
+	key, err := j.keys.key(ctx, header.Kid)
+	if err != nil {
+		return nil, invalidToken("%v", err)
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, invalidToken("%v", err)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalidToken("invalid token claims")
+	}
+	if err := j.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return j.principal(claims), nil
+}
+
+func (j *JWTAuthenticator) checkClaims(claims map[string]interface{}) error {
+	if issuer, _ := claims["iss"].(string); issuer != j.config.Issuer {
+		return invalidToken("token issued by %q", issuer)
+	}
+	if !hasAudience(claims["aud"], j.config.Audience) {
+		return invalidToken("token not issued for %s", j.config.Audience)
+	}
+
+	now := j.now()
+	expiry, ok := numericDate(claims["exp"])
+	if !ok {
+		return invalidToken("token has no expiry")
+	}
+	if now.After(expiry.Add(clockSkew)) {
+		return invalidToken("token expired")
+	}
+	if notBefore, ok := numericDate(claims["nbf"]); ok && now.Add(clockSkew).Before(notBefore) {
+		return invalidToken("token not valid yet")
+	}
+	return nil
+}
+
+// principal names the user by email, falling back to the subject for tokens
+// without one, such as those of machine clients. Emails the provider has not
+// verified are ignored, since anyone could have claimed them.
+func (j *JWTAuthenticator) principal(claims map[string]interface{}) *Principal {
+	email, _ := claims[j.config.GetEmailClaim()].(string)
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		email = ""
+	}
+	username := email
+	if username == "" {
+		username, _ = claims["sub"].(string)
+	}
+
+	var groups []string
+	switch value := claims[j.config.GetGroupsClaim()].(type) {
+	case string:
+		groups = []string{value}
+	case []interface{}:
+		for _, group := range value {
+			if name, ok := group.(string); ok {
+				groups = append(groups, name)
+			}
+		}
+	}
+
+	return &Principal{Username: username, Email: email, Groups: groups, Issuer: j.config.Issuer}
+}
+
+func hasAudience(claim interface{}, audience string) bool {
+	switch value := claim.(type) {
+	case string:
+		return value == audience
+	case []interface{}:
+		for _, item := range value {
+			if item == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func numericDate(claim interface{}) (time.Time, bool) {
+	seconds, ok := claim.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	value, err := seconds.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(value), 0), true
+}
+
+func decodeSegment(segment string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(target)
+}
+
+// verifySignature checks a JWS signature; the algorithm must suit the key so
+// that a token cannot pick a weaker check than the provider signs with
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(signed))
+	digest := hasher.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s does not match the signing key", alg)
+		}
+		if alg[:2] == "PS" {
+			return rsa.VerifyPSS(rsaKey, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+	case "ES":
+		// ES512 pairs with P-521, the others with the curve of their size
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve.Params().BitSize != map[crypto.Hash]int{crypto.SHA256: 256, crypto.SHA384: 384, crypto.SHA512: 521}[hash] {
+			return fmt.Errorf("algorithm %s does not match the signing key", alg)
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+}
@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+// testProvider is an OIDC provider serving discovery and a key set that
+// tests can rotate
+type testProvider struct {
+	server *httptest.Server
+	keys   []map[string]string
+	// fetches counts the key set requests
+	fetches int
+	// failing answers key set requests with an error
+	failing bool
+	// slow holds key set requests until it is closed
+	slow chan struct{}
+	mu   sync.Mutex
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+	provider := &testProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   provider.server.URL,
+			"jwks_uri": provider.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		provider.mu.Lock()
+		provider.fetches++
+		slow := provider.slow
+		provider.mu.Unlock()
+		if slow != nil {
+			<-slow
+		}
+
+		provider.mu.Lock()
+		defer provider.mu.Unlock()
+		if provider.failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": provider.keys})
+	})
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+	return provider
+}
+
+func (p *testProvider) publish(keys ...map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = keys
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kid": kid, "kty": "RSA", "use": "sig",
+		"n": encode(key.N.Bytes()),
+		"e": encode(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kid": kid, "kty": "EC", "crv": "P-256",
+		"x": encode(key.X.FillBytes(make([]byte, 32))),
+		"y": encode(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := encode(header) + "." + encode(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + encode(signature)
+}
+
+func newTestAuthenticator(provider *testProvider) *JWTAuthenticator {
+	return NewJWTAuthenticator(&pkg.JWTConfig{Issuer: provider.server.URL, Audience: "mcpshield"}, nil)
+}
+
+func TestJWTAuthenticatorValidatesTokens(t *testing.T) {
+	provider := newTestProvider(t)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	provider.publish(rsaJWK("rsa", rsaKey), ecJWK("ec", ecKey))
+	authenticator := newTestAuthenticator(provider)
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":            provider.server.URL,
+			"aud":            []string{"other", "mcpshield"},
+			"sub":            "1234",
+			"email":          "dev@nsx.bet",
+			"email_verified": true,
+			"groups":         []string{"sre@nsx.bet"},
+			"exp":            time.Now().Add(time.Hour).Unix(),
+		}
+	}
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := valid()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"RS256", sign(t, "RS256", "rsa", rsaKey, valid()), ""},
+		{"ES256", sign(t, "ES256", "ec", ecKey, valid()), ""},
+		{"expired", sign(t, "RS256", "rsa", rsaKey, with("exp", time.Now().Add(-time.Hour).Unix())), "expired"},
+		{"no expiry", sign(t, "RS256", "rsa", rsaKey, with("exp", nil)), "no expiry"},
+		{"not yet valid", sign(t, "RS256", "rsa", rsaKey, with("nbf", time.Now().Add(time.Hour).Unix())), "not valid yet"},
+		{"wrong audience", sign(t, "RS256", "rsa", rsaKey, with("aud", "other")), "not issued for"},
+		{"wrong issuer", sign(t, "RS256", "rsa", rsaKey, with("iss", "https://evil.example.com")), "issued by"},
+		{"wrong key", sign(t, "RS256", "rsa", otherKey, valid()), "verification"},
+		{"algorithm mismatch", sign(t, "ES256", "rsa", ecKey, valid()), "does not match"},
+		{"unsigned", strings.Join(strings.Split(sign(t, "RS256", "rsa", rsaKey, valid()), ".")[:2], ".") + ".", "verification"},
+		{"not a JWT", "opaque-token", "not a JWT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := authenticator.Authenticate(context.Background(), tt.token)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if principal.Username != "dev@nsx.bet" || principal.Email != "dev@nsx.bet" || len(principal.Groups) != 1 || principal.Groups[0] != "sre@nsx.bet" {
+				t.Fatalf("Unexpected principal %+v", principal)
+			}
+		})
+	}
+
+	// Unverified emails could be anyone's
+	for _, verified := range []interface{}{nil, false, "true"} {
+		principal, err := authenticator.Authenticate(context.Background(), sign(t, "RS256", "rsa", rsaKey, with("email_verified", verified)))
+		if err != nil || principal.Username != "1234" || principal.Email != "" {
+			t.Fatalf("Expected the email to be ignored with email_verified %v, got %+v, %v", verified, principal, err)
+		}
+	}
+}
+
+func TestJWTAuthenticatorFollowsKeyRotation(t *testing.T) {
+	provider := newTestProvider(t)
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	provider.publish(rsaJWK("old", oldKey))
+
+	authenticator := newTestAuthenticator(provider)
+	now := time.Now()
+	authenticator.keys.now = func() time.Time { return now }
+
+	claims := map[string]interface{}{"iss": provider.server.URL, "aud": "mcpshield", "sub": "agent", "exp": now.Add(time.Hour).Unix()}
+	if _, err := authenticator.Authenticate(context.Background(), sign(t, "RS256", "old", oldKey, claims)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Keys are cached
+	authenticator.Authenticate(context.Background(), sign(t, "RS256", "old", oldKey, claims))
+	if provider.fetches != 1 {
+		t.Fatalf("Expected the keys to be fetched once, got %d", provider.fetches)
+	}
+
+	provider.publish(rsaJWK("old", oldKey), rsaJWK("new", newKey))
+	rotated := sign(t, "RS256", "new", newKey, claims)
+
+	// Unknown key ids only trigger a fetch once in a while
+	if _, err := authenticator.Authenticate(context.Background(), rotated); err == nil {
+		t.Fatal("Expected the new key to be unknown right after a fetch")
+	}
+	now = now.Add(2 * keySetMinRefresh)
+	principal, err := authenticator.Authenticate(context.Background(), rotated)
+	if err != nil || principal.Username != "agent" {
+		t.Fatalf("Expected the rotated key to be picked up, got %v, %v", principal, err)
+	}
+}
+
+func TestJWTAuthenticatorKeepsKeysWhenTheProviderFails(t *testing.T) {
+	provider := newTestProvider(t)
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	provider.publish(rsaJWK("current", key))
+
+	authenticator := newTestAuthenticator(provider)
+	now := time.Now()
+	authenticator.keys.now = func() time.Time { return now }
+	claims := map[string]interface{}{"iss": provider.server.URL, "aud": "mcpshield", "sub": "agent", "exp": now.Add(time.Hour).Unix()}
+	token := sign(t, "RS256", "current", key, claims)
+	if _, err := authenticator.Authenticate(context.Background(), token); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Expired keys still verify while the provider is down, and a failed
+	// fetch is waited out before the next
+	provider.mu.Lock()
+	provider.failing = true
+	provider.mu.Unlock()
+	now = now.Add(2 * keySetTTL)
+	for i := 0; i < 3; i++ {
+		if _, err := authenticator.Authenticate(context.Background(), token); err != nil {
+			t.Fatalf("Expected the last good keys to be used, got %v", err)
+		}
+	}
+	if provider.fetches != 2 {
+		t.Fatalf("Expected one fetch after the failure, got %d", provider.fetches-1)
+	}
+	now = now.Add(2 * keySetRetryInterval)
+	authenticator.Authenticate(context.Background(), token)
+	if provider.fetches != 3 {
+		t.Fatalf("Expected another fetch once the failure was waited out, got %d", provider.fetches-1)
+	}
+}
+
+func TestJWTAuthenticatorSharesKeyFetches(t *testing.T) {
+	provider := newTestProvider(t)
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	provider.publish(rsaJWK("current", key))
+	provider.slow = make(chan struct{})
+
+	authenticator := newTestAuthenticator(provider)
+	claims := map[string]interface{}{"iss": provider.server.URL, "aud": "mcpshield", "sub": "agent", "exp": time.Now().Add(time.Hour).Unix()}
+	token := sign(t, "RS256", "current", key, claims)
+
+	errs := make(chan error, 5)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := authenticator.Authenticate(context.Background(), token)
+			errs <- err
+		}()
+	}
+
+	// Requests that give up do not take the fetch down with them
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := authenticator.Authenticate(ctx, token); err == nil {
+		t.Fatal("Expected the request to give up while the keys are fetched")
+	}
+
+	provider.mu.Lock()
+	close(provider.slow)
+	provider.mu.Unlock()
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if provider.fetches != 1 {
+		t.Fatalf("Expected concurrent requests to share one fetch, got %d", provider.fetches)
+	}
+}
+
+func TestMiddlewareRequiresBearerTokens(t *testing.T) {
+	authenticator := &staticAuthenticator{token: "good", principal: &Principal{Username: "dev@nsx.bet"}}
+	handler := Middleware(authenticator)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := PrincipalFromContext(r.Context())
+		w.Write([]byte(principal.Username))
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantBody      string
+	}{
+		{"valid", "Bearer good", http.StatusOK, "dev@nsx.bet"},
+		{"lowercase scheme", "bearer good", http.StatusOK, "dev@nsx.bet"},
+		{"missing", "", http.StatusUnauthorized, "invalid_request"},
+		{"basic", "Basic Z29vZA==", http.StatusUnauthorized, "invalid_request"},
+		{"invalid", "Bearer bad", http.StatusUnauthorized, "invalid_token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.wantStatus || !strings.Contains(recorder.Body.String(), tt.wantBody) {
+				t.Fatalf("Expected %d with %q, got %d with %q", tt.wantStatus, tt.wantBody, recorder.Code, recorder.Body.String())
+			}
+			if recorder.Code == http.StatusUnauthorized && !strings.HasPrefix(recorder.Header().Get("WWW-Authenticate"), "Bearer") {
+				t.Fatalf("Expected a bearer challenge, got %q", recorder.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
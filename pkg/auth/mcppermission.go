@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// MCPPermissions is the resource MCPPermission objects are served as, see
+// controller/api/v1alpha1
+var MCPPermissions = schema.GroupVersionResource{Group: APIGroup, Version: "v1alpha1", Resource: "mcppermissions"}
+
+// permissionSyncTimeout bounds the wait for the first list of permissions,
+// which never comes when the CRD is not installed
+const permissionSyncTimeout = 30 * time.Second
+
+// PermissionSubject selects who a permission applies to
+type PermissionSubject struct {
+	EmailDomain string `json:"emailDomain,omitempty"`
+	EmailGroup  string `json:"emailGroup,omitempty"`
+	Email       string `json:"email,omitempty"`
+}
+
+// PermissionSpec grants the subjects the tools matching the glob patterns
+// listed per server
+type PermissionSpec struct {
+	Subjects []PermissionSubject `json:"subjects"`
+	Servers  map[string][]string `json:"servers"`
+}
+
+// PermissionStatus reports the tools the patterns matched
+type PermissionStatus struct {
+	ObservedGeneration int64               `json:"observedGeneration,omitempty"`
+	MatchedTools       map[string][]string `json:"matchedTools,omitempty"`
+}
+
+// permission is what the cache keeps of an MCPPermission
+type permission struct {
+	generation int64
+	spec       PermissionSpec
+	status     PermissionStatus
+}
+
+// PermissionAuthorizer decides on tool calls with the MCPPermission objects
+// in the cluster. They are watched and kept in memory, so that no request
+// waits on the API server; the permissions matching a principal add up.
+type PermissionAuthorizer struct {
+	client      dynamic.Interface
+	informer    toolscache.SharedIndexInformer
+	mu          sync.RWMutex
+	permissions map[string]*permission
+}
+
+func NewPermissionAuthorizer(client dynamic.Interface) *PermissionAuthorizer {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, 0)
+	return &PermissionAuthorizer{
+		client:      client,
+		informer:    factory.ForResource(MCPPermissions).Informer(),
+		permissions: make(map[string]*permission),
+	}
+}
+
+// Start watches the permissions until ctx is done, calling onChange whenever
+// one is created, deleted or has its spec changed. It returns once the
+// current permissions are known.
+func (p *PermissionAuthorizer) Start(ctx context.Context, onChange func()) error {
+	_, err := p.informer.AddEventHandler(toolscache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			p.store(obj)
+			if !isInInitialList {
+				onChange()
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// Status updates, ours included, change nothing that is decided on
+			if p.store(newObj) {
+				onChange()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if object, ok := obj.(metav1.Object); ok {
+				p.mu.Lock()
+				delete(p.permissions, object.GetName())
+				p.mu.Unlock()
+			}
+			onChange()
+		},
+	})
+	if err != nil {
+		return err
+	}
+	go p.informer.Run(ctx.Done())
+
+	syncCtx, cancel := context.WithTimeout(ctx, permissionSyncTimeout)
+	defer cancel()
+	if !toolscache.WaitForCacheSync(syncCtx.Done(), p.informer.HasSynced) {
+		return fmt.Errorf("failed to list %s, is the CRD installed?", MCPPermissions.GroupResource())
+	}
+	return nil
+}
+
+// store keeps the permission in obj and reports whether its spec changed
+func (p *PermissionAuthorizer) store(obj interface{}) bool {
+	object, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return false
+	}
+
+	parsed := &permission{generation: object.GetGeneration()}
+	spec, _, _ := unstructured.NestedMap(object.Object, "spec")
+	status, _, _ := unstructured.NestedMap(object.Object, "status")
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(spec, &parsed.spec); err != nil {
+		// An unreadable permission grants nothing
+		parsed.spec = PermissionSpec{}
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(status, &parsed.status); err != nil {
+		parsed.status = PermissionStatus{}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	existing, ok := p.permissions[object.GetName()]
+	p.permissions[object.GetName()] = parsed
+	return !ok || !reflect.DeepEqual(existing.spec, parsed.spec)
+}
+
+func (p *PermissionAuthorizer) Authorize(ctx context.Context, principal *Principal, server, tool string) (bool, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, permission := range p.permissions {
+		if matchesSubject(permission.spec.Subjects, principal) && matchesTool(permission.spec.Servers[server], tool) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ReportStatus records in the status of every permission the tools its
+// patterns match among those each server offers. Permissions already
+// reporting the same are not written.
+func (p *PermissionAuthorizer) ReportStatus(ctx context.Context, tools map[string][]string) error {
+	var errs []error
+	for _, obj := range p.informer.GetStore().List() {
+		object, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		p.mu.RLock()
+		current, ok := p.permissions[object.GetName()]
+		p.mu.RUnlock()
+		if !ok {
+			continue
+		}
+
+		status := PermissionStatus{
+			ObservedGeneration: current.generation,
+			MatchedTools:       matchedTools(current.spec.Servers, tools),
+		}
+		if reflect.DeepEqual(status, current.status) {
+			continue
+		}
+
+		updated := object.DeepCopy()
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		updated.Object["status"] = content
+		if _, err := p.client.Resource(MCPPermissions).UpdateStatus(ctx, updated, metav1.UpdateOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to update the status of %s: %w", object.GetName(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// matchedTools returns, per server, the tools the patterns match; servers
+// where nothing matches are left out
+func matchedTools(servers map[string][]string, tools map[string][]string) map[string][]string {
+	var matched map[string][]string
+	for server, patterns := range servers {
+		var names []string
+		for _, tool := range tools[server] {
+			if matchesTool(patterns, tool) {
+				names = append(names, tool)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		if matched == nil {
+			matched = make(map[string][]string)
+		}
+		sort.Strings(names)
+		matched[server] = names
+	}
+	return matched
+}
+
+// matchesSubject reports whether the principal is any of the subjects
+func matchesSubject(subjects []PermissionSubject, principal *Principal) bool {
+	email := principal.Email
+	if email == "" && strings.Contains(principal.Username, "@") {
+		email = principal.Username
+	}
+	_, domain, _ := strings.Cut(email, "@")
+
+	for _, subject := range subjects {
+		switch {
+		case subject.Email != "":
+			if strings.EqualFold(subject.Email, email) {
+				return true
+			}
+		case subject.EmailDomain != "":
+			if domain != "" && strings.EqualFold(subject.EmailDomain, domain) {
+				return true
+			}
+		case subject.EmailGroup != "":
+			for _, group := range principal.Groups {
+				if strings.EqualFold(subject.EmailGroup, group) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// matchesTool reports whether any of the glob patterns matches the tool.
+// Patterns do not match across a slash, so * grants every tool but not the
+// prompts/get, resources/read and logging/setLevel verbs, which are listed
+// explicitly.
+func matchesTool(patterns []string, tool string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, tool); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newPermission builds an MCPPermission as the API server would serve it
+func newPermission(name string, subjects []interface{}, servers map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "mcpshield.io/v1alpha1",
+		"kind":       "MCPPermission",
+		"metadata":   map[string]interface{}{"name": name, "generation": int64(1)},
+		"spec":       map[string]interface{}{"subjects": subjects, "servers": servers},
+	}}
+}
+
+// startPermissions serves the permissions from a fake cluster and reports
+// changes on the returned channel
+func startPermissions(t *testing.T, objects ...runtime.Object) (*PermissionAuthorizer, *dynamicfake.FakeDynamicClient, chan struct{}) {
+	t.Helper()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{MCPPermissions: "MCPPermissionList"}, objects...)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	changed := make(chan struct{}, 10)
+	authorizer := NewPermissionAuthorizer(client)
+	if err := authorizer.Start(ctx, func() { changed <- struct{}{} }); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	return authorizer, client, changed
+}
+
+func TestPermissionAuthorizerCombinesMatchingPermissions(t *testing.T) {
+	authorizer, _, _ := startPermissions(t,
+		newPermission("nsx-defaults",
+			[]interface{}{map[string]interface{}{"emailDomain": "nsx.bet"}},
+			map[string]interface{}{"github-npx": []interface{}{"search_*", "get_*"}}),
+		newPermission("sre-permissions",
+			[]interface{}{map[string]interface{}{"emailGroup": "sre@nsx.bet"}},
+			map[string]interface{}{"github-npx": []interface{}{"delete_*"}, "k8s-mcp": []interface{}{"*"}}),
+		newPermission("oncall",
+			[]interface{}{map[string]interface{}{"email": "oncall@partner.com"}},
+			map[string]interface{}{"k8s-mcp": []interface{}{"get_pods", "prompts/get"}}),
+	)
+
+	developer := &Principal{Username: "dev@nsx.bet", Email: "dev@nsx.bet"}
+	sre := &Principal{Username: "ops@nsx.bet", Email: "ops@nsx.bet", Groups: []string{"sre@nsx.bet"}}
+	partner := &Principal{Username: "oncall@partner.com"}
+
+	tests := []struct {
+		principal    *Principal
+		server, tool string
+		want         bool
+	}{
+		{developer, "github-npx", "search_repositories", true},
+		{developer, "github-npx", "delete_repository", false},
+		{developer, "k8s-mcp", "get_pods", false},
+		{sre, "github-npx", "search_repositories", true},
+		{sre, "github-npx", "delete_repository", true},
+		{sre, "k8s-mcp", "delete_pod", true},
+		{sre, "k8s-mcp", VerbPrompts, false},
+		{partner, "k8s-mcp", "get_pods", true},
+		{partner, "k8s-mcp", VerbPrompts, true},
+		{partner, "github-npx", "search_repositories", false},
+	}
+	for _, tt := range tests {
+		allowed, err := authorizer.Authorize(context.Background(), tt.principal, tt.server, tt.tool)
+		if err != nil || allowed != tt.want {
+			t.Fatalf("Expected %v for %s on %s/%s, got %v, %v", tt.want, tt.principal.Username, tt.server, tt.tool, allowed, err)
+		}
+	}
+}
+
+func TestPermissionAuthorizerFollowsChanges(t *testing.T) {
+	authorizer, client, changed := startPermissions(t)
+	developer := &Principal{Username: "dev@nsx.bet", Email: "dev@nsx.bet"}
+
+	if allowed, _ := authorizer.Authorize(context.Background(), developer, "github-npx", "search_repositories"); allowed {
+		t.Fatal("Expected nothing to be allowed without permissions")
+	}
+
+	permission := newPermission("nsx-defaults",
+		[]interface{}{map[string]interface{}{"emailDomain": "nsx.bet"}},
+		map[string]interface{}{"github-npx": []interface{}{"search_*"}})
+	if _, err := client.Resource(MCPPermissions).Create(context.Background(), permission, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create the permission: %v", err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the new permission to be reported")
+	}
+	if allowed, _ := authorizer.Authorize(context.Background(), developer, "github-npx", "search_repositories"); !allowed {
+		t.Fatal("Expected the new permission to apply")
+	}
+}
+
+func TestPermissionAuthorizerReportsMatchedTools(t *testing.T) {
+	authorizer, client, _ := startPermissions(t, newPermission("nsx-defaults",
+		[]interface{}{map[string]interface{}{"emailDomain": "nsx.bet"}},
+		map[string]interface{}{"github-npx": []interface{}{"search_*", "get_*"}, "slack": []interface{}{"send_*"}}))
+	tools := map[string][]string{
+		"github-npx": {"delete_repository", "get_issue", "search_code", "search_repositories"},
+		"slack":      {"get_channels"},
+	}
+
+	if err := authorizer.ReportStatus(context.Background(), tools); err != nil {
+		t.Fatalf("Failed to report: %v", err)
+	}
+	permission, err := client.Resource(MCPPermissions).Get(context.Background(), "nsx-defaults", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get the permission: %v", err)
+	}
+	matched, _, _ := unstructured.NestedStringSlice(permission.Object, "status", "matchedTools", "github-npx")
+	if len(matched) != 3 || matched[0] != "get_issue" || matched[2] != "search_repositories" {
+		t.Fatalf("Unexpected matched tools %v", matched)
+	}
+	if _, found, _ := unstructured.NestedStringSlice(permission.Object, "status", "matchedTools", "slack"); found {
+		t.Fatal("Expected servers where nothing matches to be left out")
+	}
+	if generation, _, _ := unstructured.NestedInt64(permission.Object, "status", "observedGeneration"); generation != 1 {
+		t.Fatalf("Expected the observed generation, got %d", generation)
+	}
+
+	// Once the status is seen, reporting the same again writes nothing
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		authorizer.mu.RLock()
+		observed := authorizer.permissions["nsx-defaults"].status.ObservedGeneration
+		authorizer.mu.RUnlock()
+		if observed == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the status update to be watched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	client.ClearActions()
+	if err := authorizer.ReportStatus(context.Background(), tools); err != nil {
+		t.Fatalf("Failed to report: %v", err)
+	}
+	for _, action := range client.Actions() {
+		if _, ok := action.(k8stesting.UpdateAction); ok {
+			t.Fatalf("Expected no status update, got %v", action)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// rbacSyncTimeout bounds the wait for the first list of RBAC objects
+const rbacSyncTimeout = 30 * time.Second
+
+// WatchRBAC calls onChange whenever a ClusterRole or ClusterRoleBinding
+// changes, or a Role or RoleBinding in the namespace when one is set, until
+// ctx is done, so that cached decisions do not outlive the permissions they
+// were made on. It returns once the current objects are known.
+func WatchRBAC(ctx context.Context, client kubernetes.Interface, namespace string, onChange func()) error {
+	handler := toolscache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			// The initial list is what the cached decisions were made on
+			if !isInInitialList {
+				onChange()
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) { onChange() },
+		DeleteFunc: func(obj interface{}) { onChange() },
+	}
+
+	cluster := informers.NewSharedInformerFactory(client, 0)
+	watched := []toolscache.SharedIndexInformer{
+		cluster.Rbac().V1().ClusterRoles().Informer(),
+		cluster.Rbac().V1().ClusterRoleBindings().Informer(),
+	}
+	// Roles elsewhere cannot grant anything, reviews only name this namespace
+	var namespaced informers.SharedInformerFactory
+	if namespace != "" {
+		namespaced = informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithNamespace(namespace))
+		watched = append(watched,
+			namespaced.Rbac().V1().Roles().Informer(),
+			namespaced.Rbac().V1().RoleBindings().Informer(),
+		)
+	}
+
+	for _, informer := range watched {
+		if _, err := informer.AddEventHandler(handler); err != nil {
+			return err
+		}
+	}
+	cluster.Start(ctx.Done())
+	if namespaced != nil {
+		namespaced.Start(ctx.Done())
+	}
+
+	syncCtx, cancel := context.WithTimeout(ctx, rbacSyncTimeout)
+	defer cancel()
+	synced := make([]toolscache.InformerSynced, 0, len(watched))
+	for _, informer := range watched {
+		synced = append(synced, informer.HasSynced)
+	}
+	if !toolscache.WaitForCacheSync(syncCtx.Done(), synced...) {
+		return fmt.Errorf("failed to list RBAC objects, can the service account watch them?")
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// APIGroup is the RBAC API group tool permissions are granted in: the
+// resource is the server name and the verb the tool name
+const APIGroup = "mcpshield.io"
+
+// DefaultJWTSubjectPrefix goes in front of the usernames and groups of JWT
+// principals, as the API server's --oidc-username-prefix does
+const DefaultJWTSubjectPrefix = "oidc:"
+
+// SubjectAccessReviewAuthorizer asks Kubernetes RBAC whether a principal may
+// call a tool, so that MCP permissions are managed with ClusterRoles and
+// their bindings, and with RoleBindings in the namespace when one is set.
+// Decisions are cached so that the API server is not asked per call.
+type SubjectAccessReviewAuthorizer struct {
+	client    kubernetes.Interface
+	namespace string
+	// AllowedTTL and DeniedTTL are how long decisions are remembered
+	AllowedTTL time.Duration
+	DeniedTTL  time.Duration
+	// UsernamePrefix and GroupsPrefix go in front of the names of JWT
+	// principals, which an outside issuer chose, so that they cannot pose as
+	// Kubernetes users or groups. Names that still start with system: are
+	// never reviewed.
+	UsernamePrefix string
+	GroupsPrefix   string
+	cache          *ttlCache
+}
+
+func NewSubjectAccessReviewAuthorizer(client kubernetes.Interface, namespace string, allowedTTL, deniedTTL time.Duration) *SubjectAccessReviewAuthorizer {
+	return &SubjectAccessReviewAuthorizer{
+		client:         client,
+		namespace:      namespace,
+		AllowedTTL:     allowedTTL,
+		DeniedTTL:      deniedTTL,
+		UsernamePrefix: DefaultJWTSubjectPrefix,
+		GroupsPrefix:   DefaultJWTSubjectPrefix,
+		cache:          newTTLCache(),
+	}
+}
+
+func (s *SubjectAccessReviewAuthorizer) Authorize(ctx context.Context, principal *Principal, server, tool string) (bool, error) {
+	key := cacheKeyFor(principal) + "|" + server + "|" + tool
+	if allowed, ok := s.cache.get(key); ok {
+		return allowed.(bool), nil
+	}
+
+	user, groups, ok := s.subject(principal)
+	if !ok {
+		return false, nil
+	}
+	extra := make(map[string]authorizationv1.ExtraValue, len(principal.Extra))
+	for name, values := range principal.Extra {
+		extra[name] = values
+	}
+	review, err := s.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user,
+			UID:    principal.UID,
+			Groups: groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: s.namespace,
+				Group:     APIGroup,
+				Resource:  server,
+				Verb:      tool,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		// Not cached: the call may be allowed once the API server answers
+		return false, fmt.Errorf("subject access review failed: %w", err)
+	}
+
+	allowed := review.Status.Allowed && !review.Status.Denied
+	if allowed {
+		s.cache.set(key, true, s.AllowedTTL)
+	} else {
+		s.cache.set(key, false, s.DeniedTTL)
+	}
+	return allowed, nil
+}
+
+// subject names the principal as RBAC knows it. Kubernetes vouches for the
+// names of the principals it reviewed; JWT principals are prefixed, and left
+// unreviewed if their username would still be a system: one, whose groups
+// are dropped.
+func (s *SubjectAccessReviewAuthorizer) subject(principal *Principal) (string, []string, bool) {
+	if principal.Issuer == "" {
+		return principal.Username, principal.Groups, true
+	}
+
+	user := s.UsernamePrefix + principal.Username
+	if strings.HasPrefix(user, "system:") {
+		return "", nil, false
+	}
+	var groups []string
+	for _, group := range principal.Groups {
+		if group = s.GroupsPrefix + group; !strings.HasPrefix(group, "system:") {
+			groups = append(groups, group)
+		}
+	}
+	return user, groups, true
+}
+
+// Invalidate forgets the cached decisions, for when RBAC changed
+func (s *SubjectAccessReviewAuthorizer) Invalidate() {
+	s.cache.clear()
+}
+
+// cacheKeyFor identifies a principal together with everything RBAC decides
+// on, so that a change of groups is not answered from the cache
+func cacheKeyFor(principal *Principal) string {
+	groups := append([]string(nil), principal.Groups...)
+	sort.Strings(groups)
+	return principal.Issuer + "|" + principal.Username + "|" + principal.UID + "|" + strings.Join(groups, ",")
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsxbet/mcpshield/pkg"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newRBACClient allows the verbs granted per user on mcpshield.io resources,
+// like a ClusterRole binding would, or a RoleBinding for grants starting with
+// the namespace
+func newRBACClient(t *testing.T, grants map[string][]string) (*fake.Clientset, *int) {
+	client := fake.NewSimpleClientset()
+	reviews := 0
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		if attributes == nil || attributes.Group != APIGroup {
+			t.Errorf("Unexpected resource attributes %+v", attributes)
+		}
+		for _, grant := range grants[review.Spec.User] {
+			if grant == attributes.Resource+"/"+attributes.Verb || grant == attributes.Namespace+":"+attributes.Resource+"/"+attributes.Verb {
+				review.Status.Allowed = true
+			}
+		}
+		return true, review, nil
+	})
+	return client, &reviews
+}
+
+func TestSubjectAccessReviewAuthorizerChecksRBAC(t *testing.T) {
+	client, reviews := newRBACClient(t, map[string][]string{
+		"system:serviceaccount:default:mcp-user": {"github-npx/search_repositories"},
+	})
+	authorizer := NewSubjectAccessReviewAuthorizer(client, "", time.Minute, time.Minute)
+	principal := &Principal{Username: "system:serviceaccount:default:mcp-user", Groups: []string{"system:serviceaccounts"}}
+
+	tests := []struct {
+		server, tool string
+		want         bool
+	}{
+		{"github-npx", "search_repositories", true},
+		{"github-npx", "delete_repository", false},
+		{"slack", "search_repositories", false},
+	}
+	for _, tt := range tests {
+		allowed, err := authorizer.Authorize(context.Background(), principal, tt.server, tt.tool)
+		if err != nil || allowed != tt.want {
+			t.Fatalf("Expected %v for %s/%s, got %v, %v", tt.want, tt.server, tt.tool, allowed, err)
+		}
+	}
+
+	// Decisions are cached
+	for _, tt := range tests {
+		authorizer.Authorize(context.Background(), principal, tt.server, tt.tool)
+	}
+	if *reviews != len(tests) {
+		t.Fatalf("Expected %d reviews, got %d", len(tests), *reviews)
+	}
+
+	// but not across principals
+	other := &Principal{Username: "dev@nsx.bet"}
+	if allowed, _ := authorizer.Authorize(context.Background(), other, "github-npx", "search_repositories"); allowed {
+		t.Fatal("Expected another principal not to get the cached decision")
+	}
+}
+
+func TestSubjectAccessReviewAuthorizerReviewsInTheNamespace(t *testing.T) {
+	client, _ := newRBACClient(t, map[string][]string{
+		"dev@nsx.bet": {"mcp:github-npx/search_repositories"},
+	})
+	principal := &Principal{Username: "dev@nsx.bet"}
+
+	namespaced := NewSubjectAccessReviewAuthorizer(client, "mcp", time.Minute, time.Minute)
+	if allowed, err := namespaced.Authorize(context.Background(), principal, "github-npx", "search_repositories"); err != nil || !allowed {
+		t.Fatalf("Expected the RoleBinding in the namespace to grant the tool, got %v, %v", allowed, err)
+	}
+	cluster := NewSubjectAccessReviewAuthorizer(client, "", time.Minute, time.Minute)
+	if allowed, _ := cluster.Authorize(context.Background(), principal, "github-npx", "search_repositories"); allowed {
+		t.Fatal("Expected RoleBindings not to grant anything without a namespace")
+	}
+}
+
+func TestSubjectAccessReviewAuthorizerPrefixesJWTPrincipals(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	var reviewed []authorizationv1.SubjectAccessReviewSpec
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		reviewed = append(reviewed, review.Spec)
+		review.Status.Allowed = true
+		return true, review, nil
+	})
+	authorizer := NewSubjectAccessReviewAuthorizer(client, "", time.Minute, time.Minute)
+
+	jwt := &Principal{Username: "dev@nsx.bet", Groups: []string{"platform", "system:masters"}, Issuer: "https://sso.nsx.bet"}
+	if allowed, err := authorizer.Authorize(context.Background(), jwt, "github-npx", "search_repositories"); err != nil || !allowed {
+		t.Fatalf("Expected the review to decide, got %v, %v", allowed, err)
+	}
+	serviceAccount := &Principal{Username: "system:serviceaccount:default:mcp-user", Groups: []string{"system:serviceaccounts"}}
+	authorizer.Authorize(context.Background(), serviceAccount, "github-npx", "search_repositories")
+
+	if len(reviewed) != 2 {
+		t.Fatalf("Expected 2 reviews, got %d", len(reviewed))
+	}
+	if spec := reviewed[0]; spec.User != "oidc:dev@nsx.bet" || strings.Join(spec.Groups, ",") != "oidc:platform,oidc:system:masters" {
+		t.Fatalf("Expected the JWT principal to be prefixed, got %s in %v", spec.User, spec.Groups)
+	}
+	if spec := reviewed[1]; spec.User != serviceAccount.Username || strings.Join(spec.Groups, ",") != "system:serviceaccounts" {
+		t.Fatalf("Expected the reviewed principal as Kubernetes named it, got %s in %v", spec.User, spec.Groups)
+	}
+
+	// Without prefixes, JWT principals still cannot claim system: names
+	authorizer = NewSubjectAccessReviewAuthorizer(client, "", time.Minute, time.Minute)
+	authorizer.UsernamePrefix, authorizer.GroupsPrefix = "", ""
+	reviewed = nil
+	authorizer.Authorize(context.Background(), jwt, "github-npx", "search_repositories")
+	if len(reviewed) != 1 || strings.Join(reviewed[0].Groups, ",") != "platform" {
+		t.Fatalf("Expected system: groups to be dropped, got %v", reviewed)
+	}
+	admin := &Principal{Username: "system:admin", Issuer: "https://sso.nsx.bet"}
+	if allowed, _ := authorizer.Authorize(context.Background(), admin, "github-npx", "search_repositories"); allowed || len(reviewed) != 1 {
+		t.Fatal("Expected a JWT principal with a system: username to be denied without a review")
+	}
+}
+
+func TestWatchRBACFollowsTheNamespace(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan struct{}, 10)
+	if err := WatchRBAC(ctx, client, "mcp", func() { changed <- struct{}{} }); err != nil {
+		t.Fatalf("Failed to watch: %v", err)
+	}
+	role := func(namespace string) *rbacv1.Role {
+		return &rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "mcp-github", Namespace: namespace}}
+	}
+	client.RbacV1().Roles("other").Create(ctx, role("other"), metav1.CreateOptions{})
+	client.RbacV1().Roles("mcp").Create(ctx, role("mcp"), metav1.CreateOptions{})
+	client.RbacV1().ClusterRoles().Create(ctx, &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "mcp-github"}}, metav1.CreateOptions{})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-changed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the Role in the namespace and the ClusterRole to be reported")
+		}
+	}
+	select {
+	case <-changed:
+		t.Fatal("Expected Roles in other namespaces to be ignored")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestVerifyToolCallResolvesToolNames(t *testing.T) {
+	client, _ := newRBACClient(t, map[string][]string{"dev@nsx.bet": {"github-npx/search_repositories"}})
+	a := New(client, nil)
+	a.SetAuthorizer(NewSubjectAccessReviewAuthorizer(client, "", time.Minute, time.Minute))
+	a.SetToolResolver(func(name string) (string, string, bool) {
+		server, tool, ok := strings.Cut(strings.TrimPrefix(name, "ms_"), "__")
+		return server, tool, ok
+	})
+	principal := &Principal{Username: "dev@nsx.bet"}
+
+	call := func(name string) *pkg.MCPRequest {
+		return &pkg.MCPRequest{Method: "tools/call", Params: map[string]interface{}{"name": name}}
+	}
+
+	if err := a.VerifyToolCall(context.Background(), principal, call("ms_github-npx__search_repositories")); err != nil {
+		t.Fatalf("Expected the call to be allowed, got %v", err)
+	}
+
+	err := a.VerifyToolCall(context.Background(), principal, call("ms_github-npx__delete_repository"))
+	if authErr, ok := err.(*AuthError); !ok || authErr.Code != "forbidden" {
+		t.Fatalf("Expected the call to be forbidden, got %v", err)
+	}
+
+	if err := a.VerifyToolCall(context.Background(), nil, call("ms_github-npx__search_repositories")); err == nil {
+		t.Fatal("Expected calls without a principal to be forbidden")
+	}
+
+	// Unknown tools are left for the proxy to report
+	if err := a.VerifyToolCall(context.Background(), principal, call("unknown")); err != nil {
+		t.Fatalf("Expected unknown tools to pass, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// serviceAccountPrefix starts the username Kubernetes gives service accounts,
+// system:serviceaccount:<namespace>:<name>
+const serviceAccountPrefix = "system:serviceaccount:"
+
+// TokenReviewAuthenticator validates bearer tokens with the Kubernetes
+// TokenReview API, for agents running in the cluster with service account
+// tokens. Results are cached so that the API server is not asked per request.
+type TokenReviewAuthenticator struct {
+	client kubernetes.Interface
+	// Audiences the token must be issued for; the API server's own when empty
+	Audiences []string
+	// PositiveTTL and NegativeTTL are how long accepted and rejected tokens
+	// are remembered
+	PositiveTTL time.Duration
+	NegativeTTL time.Duration
+	cache       *ttlCache
+}
+
+func NewTokenReviewAuthenticator(client kubernetes.Interface, audiences []string, positiveTTL, negativeTTL time.Duration) *TokenReviewAuthenticator {
+	return &TokenReviewAuthenticator{
+		client:      client,
+		Audiences:   audiences,
+		PositiveTTL: positiveTTL,
+		NegativeTTL: negativeTTL,
+		cache:       newTTLCache(),
+	}
+}
+
+func (t *TokenReviewAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	key := tokenKey(token)
+	if cached, ok := t.cache.get(key); ok {
+		if principal, ok := cached.(*Principal); ok {
+			return principal, nil
+		}
+		return nil, cached.(*AuthError)
+	}
+
+	review, err := t.client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: t.Audiences},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		// Not cached: the token may be fine once the API server answers
+		return nil, fmt.Errorf("token review failed: %w", err)
+	}
+
+	if !review.Status.Authenticated {
+		message := review.Status.Error
+		if message == "" {
+			message = "token rejected by token review"
+		}
+		authErr := &AuthError{Code: "invalid_token", Message: message}
+		t.cache.set(key, authErr, t.NegativeTTL)
+		return nil, authErr
+	}
+
+	principal := reviewedPrincipal(review.Status.User)
+	t.cache.set(key, principal, t.PositiveTTL)
+	return principal, nil
+}
+
+func reviewedPrincipal(user authenticationv1.UserInfo) *Principal {
+	principal := &Principal{
+		Username: user.Username,
+		UID:      user.UID,
+		Groups:   user.Groups,
+	}
+	if len(user.Extra) > 0 {
+		principal.Extra = make(map[string][]string, len(user.Extra))
+		for key, values := range user.Extra {
+			principal.Extra[key] = values
+		}
+	}
+
+	if rest, ok := strings.CutPrefix(user.Username, serviceAccountPrefix); ok {
+		if namespace, name, ok := strings.Cut(rest, ":"); ok {
+			principal.Namespace = namespace
+			principal.ServiceAccount = name
+		}
+	}
+	return principal
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newReviewingClient answers token reviews for the tokens it knows
+func newReviewingClient(users map[string]authenticationv1.UserInfo) (*fake.Clientset, *int) {
+	client := fake.NewSimpleClientset()
+	reviews := 0
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "unreachable" {
+			return true, nil, fmt.Errorf("connection refused")
+		}
+		if user, ok := users[review.Spec.Token]; ok {
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: user}
+		} else {
+			review.Status = authenticationv1.TokenReviewStatus{Error: "invalid bearer token"}
+		}
+		return true, review, nil
+	})
+	return client, &reviews
+}
+
+func TestTokenReviewAuthenticatorMapsServiceAccounts(t *testing.T) {
+	client, reviews := newReviewingClient(map[string]authenticationv1.UserInfo{
+		"agent-token": {
+			Username: "system:serviceaccount:agents:reviewer",
+			UID:      "uid-1",
+			Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:agents"},
+			Extra:    map[string]authenticationv1.ExtraValue{"authentication.kubernetes.io/pod-name": {"reviewer-0"}},
+		},
+		"user-token": {Username: "dev@nsx.bet", Groups: []string{"sre"}},
+	})
+	authenticator := NewTokenReviewAuthenticator(client, nil, time.Minute, time.Minute)
+
+	principal, err := authenticator.Authenticate(context.Background(), "agent-token")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if principal.ServiceAccount != "reviewer" || principal.Namespace != "agents" || principal.UID != "uid-1" {
+		t.Fatalf("Unexpected service account %+v", principal)
+	}
+	if len(principal.Groups) != 2 || principal.Extra["authentication.kubernetes.io/pod-name"][0] != "reviewer-0" {
+		t.Fatalf("Expected groups and extra to be mapped, got %+v", principal)
+	}
+
+	principal, err = authenticator.Authenticate(context.Background(), "user-token")
+	if err != nil || principal.Username != "dev@nsx.bet" || principal.ServiceAccount != "" || principal.Namespace != "" {
+		t.Fatalf("Expected a plain user, got %+v, %v", principal, err)
+	}
+	if *reviews != 2 {
+		t.Fatalf("Expected two reviews, got %d", *reviews)
+	}
+}
+
+func TestTokenReviewAuthenticatorCachesResults(t *testing.T) {
+	client, reviews := newReviewingClient(map[string]authenticationv1.UserInfo{"good": {Username: "agent"}})
+	authenticator := NewTokenReviewAuthenticator(client, nil, time.Minute, 10*time.Second)
+	now := time.Now()
+	authenticator.cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := authenticator.Authenticate(context.Background(), "good"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := authenticator.Authenticate(context.Background(), "bad"); err == nil {
+			t.Fatal("Expected the bad token to be rejected")
+		}
+	}
+	if *reviews != 2 {
+		t.Fatalf("Expected results to be cached, got %d reviews", *reviews)
+	}
+
+	// Rejections are forgotten sooner than acceptances
+	now = now.Add(30 * time.Second)
+	authenticator.Authenticate(context.Background(), "good")
+	authenticator.Authenticate(context.Background(), "bad")
+	if *reviews != 3 {
+		t.Fatalf("Expected only the rejection to expire, got %d reviews", *reviews)
+	}
+
+	// Failures to reach the API server are not cached
+	authenticator.Authenticate(context.Background(), "unreachable")
+	authenticator.Authenticate(context.Background(), "unreachable")
+	if *reviews != 5 {
+		t.Fatalf("Expected failed reviews to be retried, got %d reviews", *reviews)
+	}
+}
+
+func TestChainTriesAuthenticatorsInOrder(t *testing.T) {
+	client, _ := newReviewingClient(map[string]authenticationv1.UserInfo{"agent-token": {Username: "system:serviceaccount:agents:reviewer"}})
+	authenticator := Chain(
+		&staticAuthenticator{token: "sso-token", principal: &Principal{Username: "dev@nsx.bet"}},
+		NewTokenReviewAuthenticator(client, nil, time.Minute, time.Minute),
+	)
+
+	tests := []struct {
+		token string
+		want  string
+	}{
+		{"sso-token", "dev@nsx.bet"},
+		{"agent-token", "system:serviceaccount:agents:reviewer"},
+		{"other", ""},
+	}
+	for _, tt := range tests {
+		principal, err := authenticator.Authenticate(context.Background(), tt.token)
+		if tt.want == "" {
+			if err == nil {
+				t.Fatalf("Expected %s to be rejected", tt.token)
+			}
+			continue
+		}
+		if err != nil || principal.Username != tt.want {
+			t.Fatalf("Expected %s for %s, got %v, %v", tt.want, tt.token, principal, err)
+		}
+	}
+}
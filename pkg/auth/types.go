@@ -5,6 +5,15 @@ type Principal struct {
 	Username       string
 	ServiceAccount string
 	Namespace      string
+	UID            string
+	// Email comes from the token claims of human users
+	Email string
+	// Issuer is the OIDC provider that vouched for the names of a JWT
+	// principal, and empty for principals Kubernetes reviewed
+	Issuer string
+	Groups []string
+	// Extra holds further attributes Kubernetes reports for the user
+	Extra map[string][]string
 }
 
 // AuthError represents authentication/authorization errors
@@ -0,0 +1,502 @@
+package bridge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/nsxbet/mcpshield/pkg"
+	"github.com/nsxbet/mcpshield/pkg/sse"
+)
+
+const (
+	sessionIDHeader       = "Mcp-Session-Id"
+	protocolVersionHeader = "MCP-Protocol-Version"
+	lastEventIDHeader     = "Last-Event-ID"
+
+	// maxMessageSize bounds one line read from the client
+	maxMessageSize = 16 * 1024 * 1024
+	// maxAttempts bounds how often one message is sent before the client is
+	// told it failed
+	maxAttempts = 3
+)
+
+var (
+	errUnauthorized   = errors.New("shield rejected the token")
+	errSessionExpired = errors.New("shield session expired")
+)
+
+// retryableError is a failure worth sending the message again for, such as the
+// shield being unreachable
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// Bridge connects an MCP client speaking stdio to the shield's Streamable HTTP
+// endpoint. It attaches the bearer token to every request and, when the
+// shield forgets the session, resumes it by replaying the client's handshake.
+type Bridge struct {
+	endpoint string
+	tokens   TokenSource
+	client   *http.Client
+	logger   *log.Logger
+	out      *lineWriter
+	// retryDelay grows linearly between attempts
+	retryDelay      time.Duration
+	sessionID       string
+	protocolVersion string
+	// initialize and initialized are the client's handshake, replayed to
+	// resume an expired session
+	initialize   []byte
+	initialized  []byte
+	lastEventID  string
+	cancelListen context.CancelFunc
+	resumeMu     sync.Mutex
+	mu           sync.Mutex
+}
+
+func New(endpoint string, tokens TokenSource, logger *log.Logger) *Bridge {
+	return &Bridge{
+		endpoint:   endpoint,
+		tokens:     tokens,
+		client:     &http.Client{},
+		logger:     logger,
+		retryDelay: time.Second,
+	}
+}
+
+// lineWriter writes JSON-RPC messages to the client, one per line
+type lineWriter struct {
+	out io.Writer
+	mu  sync.Mutex
+}
+
+func (w *lineWriter) write(message []byte) {
+	var line bytes.Buffer
+	if err := json.Compact(&line, message); err != nil {
+		return
+	}
+	line.WriteByte('\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.out.Write(line.Bytes())
+}
+
+// Run relays messages between the client on in and out and the shield until
+// in ends or ctx is done, then terminates the shield session
+func (b *Bridge) Run(ctx context.Context, in io.Reader, out io.Writer) error {
+	b.out = &lineWriter{out: out}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+		b.terminate()
+	}()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		line := bytes.Clone(bytes.TrimSpace(scanner.Bytes()))
+		if len(line) == 0 {
+			continue
+		}
+
+		var message struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		if err := json.Unmarshal(line, &message); err != nil {
+			// The shield answers malformed messages itself
+			b.forward(ctx, line, nil)
+			continue
+		}
+
+		b.mu.Lock()
+		switch message.Method {
+		case "initialize":
+			b.initialize = line
+		case "notifications/initialized":
+			b.initialized = line
+		}
+		b.mu.Unlock()
+
+		// Requests run concurrently so that a long call does not hold up
+		// cancellations; the handshake and everything else go in order
+		if message.ID != nil && message.Method != "" && message.Method != "initialize" {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b.forward(ctx, line, message.ID)
+			}()
+			continue
+		}
+		b.forward(ctx, line, nil)
+	}
+	return scanner.Err()
+}
+
+// forward sends a client message to the shield, retrying when that is worth
+// it. Requests that cannot be delivered are answered with an error so that
+// the client does not wait forever.
+func (b *Bridge) forward(ctx context.Context, message []byte, id json.RawMessage) {
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		sessionID := b.session()
+		err = b.post(ctx, message, b.out.write)
+
+		var retryable *retryableError
+		switch {
+		case err == nil || ctx.Err() != nil:
+			return
+		case errors.Is(err, errUnauthorized):
+			b.tokens.Invalidate()
+		case errors.Is(err, errSessionExpired):
+			if resumeErr := b.resume(ctx, sessionID); resumeErr != nil {
+				err = resumeErr
+				attempt = maxAttempts
+			}
+		case errors.As(err, &retryable):
+			b.logger.Warn("Shield unreachable, retrying", "attempt", attempt, "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(attempt) * b.retryDelay):
+			}
+		default:
+			attempt = maxAttempts
+		}
+	}
+
+	b.logger.Error("Failed to forward message", "error", err)
+	if id != nil {
+		var requestID interface{}
+		json.Unmarshal(id, &requestID)
+		response, _ := json.Marshal(&pkg.MCPResponse{
+			JSONRPC: "2.0",
+			ID:      requestID,
+			Error:   map[string]interface{}{"code": -32603, "message": err.Error()},
+		})
+		b.out.write(response)
+	}
+}
+
+// resume starts a new shield session by replaying the client's handshake.
+// Concurrent requests that all found the session expired resume it once.
+func (b *Bridge) resume(ctx context.Context, expiredSessionID string) error {
+	b.resumeMu.Lock()
+	defer b.resumeMu.Unlock()
+
+	b.mu.Lock()
+	current, initialize, initialized := b.sessionID, b.initialize, b.initialized
+	b.mu.Unlock()
+
+	if current != "" && current != expiredSessionID {
+		return nil
+	}
+	if initialize == nil {
+		return fmt.Errorf("no session to resume: the client has not initialized")
+	}
+
+	b.logger.Info("Resuming expired shield session")
+	discard := func([]byte) {}
+	if err := b.post(ctx, initialize, discard); err != nil {
+		return fmt.Errorf("failed to resume session: %w", err)
+	}
+	if initialized != nil {
+		if err := b.post(ctx, initialized, discard); err != nil {
+			return fmt.Errorf("failed to resume session: %w", err)
+		}
+	}
+	return nil
+}
+
+func (b *Bridge) session() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sessionID
+}
+
+func (b *Bridge) setHeaders(ctx context.Context, req *http.Request) error {
+	token, err := b.tokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.sessionID != "" {
+		req.Header.Set(sessionIDHeader, b.sessionID)
+	}
+	if b.protocolVersion != "" {
+		req.Header.Set(protocolVersionHeader, b.protocolVersion)
+	}
+	return nil
+}
+
+// post sends one message and hands every message the shield answers with to
+// deliver, whether the answer is a JSON body or an SSE stream. A request whose
+// response never arrives is an error, so that the client gets one instead.
+func (b *Bridge) post(ctx context.Context, message []byte, deliver func([]byte)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.endpoint, bytes.NewReader(message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if err := b.setHeaders(ctx, req); err != nil {
+		return err
+	}
+	sessionID := req.Header.Get(sessionIDHeader)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return &retryableError{fmt.Errorf("request to %s failed: %w", b.endpoint, err)}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return errUnauthorized
+	case resp.StatusCode == http.StatusNotFound && sessionID != "":
+		b.mu.Lock()
+		if b.sessionID == sessionID {
+			b.sessionID = ""
+		}
+		b.mu.Unlock()
+		return errSessionExpired
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return &retryableError{fmt.Errorf("shield returned %d", resp.StatusCode)}
+	case resp.StatusCode >= http.StatusBadRequest:
+		return rejected(resp, deliver)
+	}
+
+	if newSessionID := resp.Header.Get(sessionIDHeader); newSessionID != "" && newSessionID != sessionID {
+		b.startSession(newSessionID)
+	}
+
+	if resp.StatusCode == http.StatusAccepted {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/event-stream" {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return &retryableError{err}
+		}
+		b.recordProtocolVersion(body)
+		deliver(body)
+		return nil
+	}
+
+	id := requestID(message)
+	reader := sse.NewReader(resp.Body)
+	for {
+		event, err := reader.Next()
+		if err != nil {
+			if id == nil {
+				return nil
+			}
+			return fmt.Errorf("shield stream ended before the response: %w", err)
+		}
+		deliver([]byte(event.Data))
+		if id != nil && sameID(responseID([]byte(event.Data)), id) {
+			return nil
+		}
+	}
+}
+
+// rejected handles a client error from the shield. A JSON-RPC answer, such as
+// the one to a malformed message, goes to the client as it is; anything else
+// becomes an error carrying the status.
+func rejected(resp *http.Response, deliver func([]byte)) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/json" && json.Valid(body) {
+		deliver(body)
+		return nil
+	}
+	return fmt.Errorf("shield returned %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+}
+
+// requestID returns the id of a request, or nil for any other message
+func requestID(message []byte) json.RawMessage {
+	var envelope struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	if json.Unmarshal(message, &envelope) != nil || envelope.Method == "" || string(envelope.ID) == "null" {
+		return nil
+	}
+	return envelope.ID
+}
+
+// responseID returns the id of a response, or nil for any other message
+func responseID(message []byte) json.RawMessage {
+	var envelope struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	if json.Unmarshal(message, &envelope) != nil || envelope.Method != "" {
+		return nil
+	}
+	return envelope.ID
+}
+
+// sameID compares ids by value, since the shield re-encodes them
+func sameID(a, b json.RawMessage) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	var x, y interface{}
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return x == y
+}
+
+// recordProtocolVersion remembers the version negotiated on initialize; it
+// accompanies every later request
+func (b *Bridge) recordProtocolVersion(body []byte) {
+	var initialized struct {
+		Result struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		} `json:"result"`
+	}
+	if json.Unmarshal(body, &initialized) == nil && initialized.Result.ProtocolVersion != "" {
+		b.mu.Lock()
+		b.protocolVersion = initialized.Result.ProtocolVersion
+		b.mu.Unlock()
+	}
+}
+
+// startSession adopts a session the shield handed out and opens its standalone
+// stream for server-initiated messages
+func (b *Bridge) startSession(sessionID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.cancelListen != nil {
+		b.cancelListen()
+	}
+	b.sessionID = sessionID
+	b.lastEventID = ""
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancelListen = cancel
+	go b.listen(ctx)
+}
+
+// listen keeps the standalone stream open, reconnecting with the last event id
+// seen so that the shield can replay what was missed
+func (b *Bridge) listen(ctx context.Context) {
+	for attempt := 1; ctx.Err() == nil; attempt++ {
+		connected, retry := b.openStream(ctx)
+		if !retry {
+			return
+		}
+		if connected {
+			attempt = 1
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(min(attempt, 10)) * b.retryDelay):
+		}
+	}
+}
+
+// openStream reads the standalone stream until it ends and reports whether it
+// got connected and whether reconnecting is worthwhile
+func (b *Bridge) openStream(ctx context.Context) (bool, bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.endpoint, nil)
+	if err != nil {
+		return false, false
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if err := b.setHeaders(ctx, req); err != nil {
+		b.logger.Warn("Cannot open the shield stream", "error", err)
+		return false, true
+	}
+	b.mu.Lock()
+	if b.lastEventID != "" {
+		req.Header.Set(lastEventIDHeader, b.lastEventID)
+	}
+	b.mu.Unlock()
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return false, ctx.Err() == nil
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		b.tokens.Invalidate()
+		return false, true
+	default:
+		// 405 means no standalone stream, 404 means the session is gone and
+		// the next request resumes it
+		return false, false
+	}
+
+	reader := sse.NewReader(resp.Body)
+	for {
+		event, err := reader.Next()
+		if err != nil {
+			return true, ctx.Err() == nil
+		}
+		if event.ID != "" {
+			b.mu.Lock()
+			b.lastEventID = event.ID
+			b.mu.Unlock()
+		}
+		b.out.write([]byte(event.Data))
+	}
+}
+
+// terminate ends the shield session when the client goes away
+func (b *Bridge) terminate() {
+	b.mu.Lock()
+	if b.cancelListen != nil {
+		b.cancelListen()
+	}
+	b.mu.Unlock()
+
+	if b.session() == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, b.endpoint, nil)
+	if err != nil || b.setHeaders(ctx, req) != nil {
+		return
+	}
+	if resp, err := b.client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,343 @@
+package bridge
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// fakeShield is a Streamable HTTP endpoint that accepts a single valid token
+// and can forget its sessions
+type fakeShield struct {
+	token    string
+	sessions map[string]bool
+	next     int
+	// tokens records the token of every POST
+	tokens []string
+	// initializes counts the initialize requests
+	initializes int
+	// stream answers tools/call with an SSE stream
+	stream bool
+	mu     sync.Mutex
+}
+
+func newFakeShield(t *testing.T, token string) (*fakeShield, *httptest.Server) {
+	t.Helper()
+	shield := &fakeShield{token: token, sessions: map[string]bool{}}
+	server := httptest.NewServer(shield)
+	t.Cleanup(server.Close)
+	return shield, server
+}
+
+func (s *fakeShield) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+s.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		ID     interface{} `json:"id"`
+		Method string      `json:"method"`
+	}
+	body, _ := io.ReadAll(r.Body)
+	json.Unmarshal(body, &request)
+	s.tokens = append(s.tokens, r.Header.Get("Authorization"))
+
+	if request.Method == "initialize" {
+		s.initializes++
+		s.next++
+		sessionID := fmt.Sprintf("session-%d", s.next)
+		s.sessions[sessionID] = true
+		w.Header().Set("Mcp-Session-Id", sessionID)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%v,"result":{"protocolVersion":"2025-06-18","capabilities":{}}}`, request.ID)
+		return
+	}
+
+	if !s.sessions[r.Header.Get("Mcp-Session-Id")] {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if request.ID == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	response := fmt.Sprintf(`{"jsonrpc":"2.0","id":%q,"result":{"session":%q}}`, request.ID, r.Header.Get("Mcp-Session-Id"))
+	if s.stream && request.Method == "tools/call" {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n")
+		fmt.Fprintf(w, "data: %s\n\n", response)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, response)
+}
+
+func (s *fakeShield) expireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = map[string]bool{}
+}
+
+// staticTokens hands out tokens in order, moving on when invalidated
+type staticTokens struct {
+	tokens []string
+	mu     sync.Mutex
+}
+
+func (s *staticTokens) Token(context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens[0], nil
+}
+
+func (s *staticTokens) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.tokens) > 1 {
+		s.tokens = s.tokens[1:]
+	}
+}
+
+// bridgeClient drives Run through pipes
+type bridgeClient struct {
+	in       *io.PipeWriter
+	messages chan map[string]interface{}
+}
+
+func startBridge(t *testing.T, endpoint string, tokens TokenSource) *bridgeClient {
+	t.Helper()
+
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	client := &bridgeClient{in: inWriter, messages: make(chan map[string]interface{}, 16)}
+
+	bridge := New(endpoint, tokens, log.New(io.Discard))
+	bridge.retryDelay = 10 * time.Millisecond
+	go func() {
+		bridge.Run(context.Background(), inReader, outWriter)
+		outWriter.Close()
+	}()
+	go func() {
+		scanner := bufio.NewScanner(outReader)
+		for scanner.Scan() {
+			var message map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+				t.Errorf("Invalid line %q: %v", scanner.Text(), err)
+				continue
+			}
+			client.messages <- message
+		}
+		close(client.messages)
+	}()
+	t.Cleanup(func() { inWriter.Close() })
+	return client
+}
+
+func (c *bridgeClient) send(t *testing.T, message string) {
+	t.Helper()
+	if _, err := io.WriteString(c.in, message+"\n"); err != nil {
+		t.Fatalf("Failed to write %s: %v", message, err)
+	}
+}
+
+func (c *bridgeClient) next(t *testing.T) map[string]interface{} {
+	t.Helper()
+	select {
+	case message, ok := <-c.messages:
+		if !ok {
+			t.Fatal("Output ended early")
+		}
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a message")
+		return nil
+	}
+}
+
+func (c *bridgeClient) initialize(t *testing.T) {
+	t.Helper()
+	c.send(t, `{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{}}}`)
+	if result, _ := c.next(t)["result"].(map[string]interface{}); result["protocolVersion"] != "2025-06-18" {
+		t.Fatalf("Unexpected initialize result %v", result)
+	}
+	c.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+}
+
+func TestBridgeRelaysMessages(t *testing.T) {
+	shield, server := newFakeShield(t, "good")
+	client := startBridge(t, server.URL, &staticTokens{tokens: []string{"good"}})
+	client.initialize(t)
+
+	client.send(t, `{"jsonrpc":"2.0","id":"list","method":"tools/list"}`)
+	response := client.next(t)
+	if result, _ := response["result"].(map[string]interface{}); response["id"] != "list" || result["session"] != "session-1" {
+		t.Fatalf("Unexpected response %v", response)
+	}
+
+	shield.mu.Lock()
+	defer shield.mu.Unlock()
+	for _, token := range shield.tokens {
+		if token != "Bearer good" {
+			t.Fatalf("Expected every request to carry the token, got %q", token)
+		}
+	}
+}
+
+func TestBridgeRelaysStreamedResponses(t *testing.T) {
+	shield, server := newFakeShield(t, "good")
+	shield.stream = true
+	client := startBridge(t, server.URL, &staticTokens{tokens: []string{"good"}})
+	client.initialize(t)
+
+	client.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"echo"}}`)
+	if progress := client.next(t); progress["method"] != "notifications/progress" {
+		t.Fatalf("Expected the progress notification first, got %v", progress)
+	}
+	if response := client.next(t); response["id"] != "call" {
+		t.Fatalf("Expected the call response, got %v", response)
+	}
+}
+
+func TestBridgeRetriesWithFreshToken(t *testing.T) {
+	_, server := newFakeShield(t, "fresh")
+	client := startBridge(t, server.URL, &staticTokens{tokens: []string{"stale", "fresh"}})
+	client.initialize(t)
+
+	client.send(t, `{"jsonrpc":"2.0","id":"list","method":"tools/list"}`)
+	if response := client.next(t); response["result"] == nil {
+		t.Fatalf("Expected the retry to succeed, got %v", response)
+	}
+}
+
+func TestBridgeResumesExpiredSessions(t *testing.T) {
+	shield, server := newFakeShield(t, "good")
+	client := startBridge(t, server.URL, &staticTokens{tokens: []string{"good"}})
+	client.initialize(t)
+
+	shield.expireSessions()
+	client.send(t, `{"jsonrpc":"2.0","id":"list","method":"tools/list"}`)
+
+	// The replayed initialize is not answered to the client
+	response := client.next(t)
+	if result, _ := response["result"].(map[string]interface{}); response["id"] != "list" || result["session"] != "session-2" {
+		t.Fatalf("Expected the request to run on a new session, got %v", response)
+	}
+	shield.mu.Lock()
+	defer shield.mu.Unlock()
+	if shield.initializes != 2 {
+		t.Fatalf("Expected the handshake to be replayed once, got %d initializes", shield.initializes)
+	}
+}
+
+func TestBridgeAnswersUndeliverableRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	client := startBridge(t, server.URL, &staticTokens{tokens: []string{"good"}})
+
+	client.send(t, `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`)
+	response := client.next(t)
+	if response["id"] != float64(3) || response["error"] == nil {
+		t.Fatalf("Expected an error response, got %v", response)
+	}
+}
+
+func TestBridgeAnswersRejectedRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+	client := startBridge(t, server.URL, &staticTokens{tokens: []string{"good"}})
+
+	client.send(t, `{"jsonrpc":"2.0","id":4,"method":"tools/list"}`)
+	response := client.next(t)
+	failure, _ := response["error"].(map[string]interface{})
+	if response["id"] != float64(4) || failure == nil {
+		t.Fatalf("Expected an error response, got %v", response)
+	}
+	if message, _ := failure["message"].(string); !strings.Contains(message, "403") {
+		t.Fatalf("Expected the error to carry the status, got %q", message)
+	}
+}
+
+func TestBridgeAnswersRequestsWhoseStreamEnds(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n")
+	}))
+	defer server.Close()
+	client := startBridge(t, server.URL, &staticTokens{tokens: []string{"good"}})
+
+	client.send(t, `{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"echo"}}`)
+	if progress := client.next(t); progress["method"] != "notifications/progress" {
+		t.Fatalf("Expected the progress notification first, got %v", progress)
+	}
+	if response := client.next(t); response["id"] != float64(5) || response["error"] == nil {
+		t.Fatalf("Expected an error response, got %v", response)
+	}
+}
+
+func testJWT(expiry time.Time) string {
+	payload, _ := json.Marshal(map[string]interface{}{"sub": "dev", "exp": expiry.Unix()})
+	return "e30." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+}
+
+func TestFileTokenSourceRefreshesExpiringTokens(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	old, renewed := testJWT(now.Add(time.Minute)), testJWT(now.Add(time.Hour))
+
+	refresher := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+old {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": renewed})
+	}))
+	defer refresher.Close()
+
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte(old+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	source := NewFileTokenSource(path, 5*time.Minute, refresher.URL)
+	source.now = func() time.Time { return now }
+	token, err := source.Token(context.Background())
+	if err != nil || token != renewed {
+		t.Fatalf("Expected the refreshed token, got %q, %v", token, err)
+	}
+	if stored, _ := os.ReadFile(path); string(stored) != renewed+"\n" {
+		t.Fatalf("Expected the refreshed token to be stored, got %q", stored)
+	}
+
+	// Without a refresh URL an expired token is still handed out and the
+	// shield decides
+	source = NewFileTokenSource(path, 5*time.Minute, "")
+	source.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if token, err := source.Token(context.Background()); err != nil || token != renewed {
+		t.Fatalf("Expected the stored token, got %q, %v", token, err)
+	}
+}
@@ -0,0 +1,151 @@
+package bridge
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource supplies the bearer token for requests to the shield
+type TokenSource interface {
+	// Token returns a token that is not about to expire, refreshing it when
+	// needed
+	Token(ctx context.Context) (string, error)
+	// Invalidate drops the current token after the shield rejected it
+	Invalidate()
+}
+
+// FileTokenSource reads the token stored by `mcpshield auth login`. Tokens
+// within the refresh threshold of their expiry are re-read, since login may
+// have renewed them, and then refreshed with the refresh URL when one is set.
+type FileTokenSource struct {
+	Path             string
+	RefreshThreshold time.Duration
+	// RefreshURL is POSTed the current token as a bearer token and answers
+	// {"token": "..."}; refreshing is skipped when it is empty
+	RefreshURL string
+	Client     *http.Client
+	// now is replaced in tests
+	now    func() time.Time
+	token  string
+	expiry time.Time
+	mu     sync.Mutex
+}
+
+func NewFileTokenSource(path string, refreshThreshold time.Duration, refreshURL string) *FileTokenSource {
+	return &FileTokenSource{
+		Path:             path,
+		RefreshThreshold: refreshThreshold,
+		RefreshURL:       refreshURL,
+		Client:           http.DefaultClient,
+		now:              time.Now,
+	}
+}
+
+func (s *FileTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && !s.expiring() {
+		return s.token, nil
+	}
+
+	if err := s.load(); err != nil {
+		return "", err
+	}
+	if !s.expiring() || s.RefreshURL == "" {
+		return s.token, nil
+	}
+
+	// A failed refresh leaves the current token usable until it expires
+	if err := s.refresh(ctx); err != nil && !s.expiry.After(s.now()) {
+		return "", fmt.Errorf("token expired and refresh failed: %w", err)
+	}
+	return s.token, nil
+}
+
+func (s *FileTokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ""
+}
+
+// expiring reports whether the token is within the refresh threshold of its
+// expiry; tokens without an expiry never are
+func (s *FileTokenSource) expiring() bool {
+	return !s.expiry.IsZero() && s.now().Add(s.RefreshThreshold).After(s.expiry)
+}
+
+func (s *FileTokenSource) load() error {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read token, run `mcpshield auth login`: %w", err)
+	}
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return fmt.Errorf("token file %s is empty, run `mcpshield auth login`", s.Path)
+	}
+	s.token = token
+	s.expiry = tokenExpiry(token)
+	return nil
+}
+
+func (s *FileTokenSource) refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.RefreshURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("refresh returned %d", resp.StatusCode)
+	}
+
+	var refreshed struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&refreshed); err != nil || refreshed.Token == "" {
+		return fmt.Errorf("invalid refresh response")
+	}
+
+	// Stored for the next run and for other tools reading the token
+	if err := os.WriteFile(s.Path, []byte(refreshed.Token+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to store refreshed token: %w", err)
+	}
+	s.token = refreshed.Token
+	s.expiry = tokenExpiry(refreshed.Token)
+	return nil
+}
+
+// tokenExpiry reads the exp claim of a JWT without verifying it; the shield
+// does the verifying. Tokens that are not JWTs have no known expiry.
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
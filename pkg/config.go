@@ -3,6 +3,8 @@ package pkg
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -13,6 +15,7 @@ type Config struct {
 	Log        LogConfig         `yaml:"log"`
 	Server     ServerConfig      `yaml:"server"`
 	Runtime    RuntimeConfig     `yaml:"runtime"`
+	Naming     NamingConfig      `yaml:"naming"`
 	MCPServers []MCPServerConfig `yaml:"mcp-servers"`
 }
 
@@ -24,6 +27,148 @@ type APIConfig struct {
 
 type AuthConfig struct {
 	Timeout int `yaml:"timeout"`
+	// JWT requires clients to present a bearer token issued by an OIDC
+	// provider; requests are not authenticated when it is unset
+	JWT *JWTConfig `yaml:"jwt,omitempty"`
+	// TokenReview accepts Kubernetes service account tokens, checked with the
+	// TokenReview API; with JWT set as well, either kind of token works
+	TokenReview *TokenReviewConfig `yaml:"token_review,omitempty"`
+	// Authorization decides which tools authenticated principals may call;
+	// they may call every tool when it is unset
+	Authorization *AuthorizationConfig `yaml:"authorization,omitempty"`
+}
+
+// Authorization modes
+const (
+	// AuthorizationSubjectAccessReview checks Kubernetes RBAC: apiGroup
+	// mcpshield.io, the server name as resource and the tool name as verb
+	AuthorizationSubjectAccessReview = "subject_access_review"
+	// AuthorizationMCPPermission checks the MCPPermission objects in the
+	// cluster, watched and evaluated in memory
+	AuthorizationMCPPermission = "mcp_permission"
+)
+
+type AuthorizationConfig struct {
+	Mode string `yaml:"mode"`
+	// Namespace lets Roles and RoleBindings in it grant tools with
+	// subject_access_review; only ClusterRoleBindings do when it is unset
+	Namespace string `yaml:"namespace,omitempty"`
+	// AllowedCacheTTL and DeniedCacheTTL are how long, in seconds, decisions
+	// are remembered with subject_access_review
+	AllowedCacheTTL int `yaml:"allowed_cache_ttl,omitempty"`
+	DeniedCacheTTL  int `yaml:"denied_cache_ttl,omitempty"`
+	// JWTUsernamePrefix and JWTGroupsPrefix go in front of the names of JWT
+	// principals with subject_access_review, oidc: unless set; - turns them
+	// off
+	JWTUsernamePrefix string `yaml:"jwt_username_prefix,omitempty"`
+	JWTGroupsPrefix   string `yaml:"jwt_groups_prefix,omitempty"`
+}
+
+// GetAllowedCacheTTL defaults to a minute
+func (a *AuthorizationConfig) GetAllowedCacheTTL() time.Duration {
+	if a.AllowedCacheTTL <= 0 {
+		return time.Minute
+	}
+	return time.Duration(a.AllowedCacheTTL) * time.Second
+}
+
+// GetDeniedCacheTTL defaults to 10 seconds, so that newly granted
+// permissions apply quickly
+func (a *AuthorizationConfig) GetDeniedCacheTTL() time.Duration {
+	if a.DeniedCacheTTL <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(a.DeniedCacheTTL) * time.Second
+}
+
+// GetJWTUsernamePrefix defaults to oidc:
+func (a *AuthorizationConfig) GetJWTUsernamePrefix() string {
+	return jwtSubjectPrefix(a.JWTUsernamePrefix)
+}
+
+// GetJWTGroupsPrefix defaults to oidc:
+func (a *AuthorizationConfig) GetJWTGroupsPrefix() string {
+	return jwtSubjectPrefix(a.JWTGroupsPrefix)
+}
+
+func jwtSubjectPrefix(prefix string) string {
+	switch prefix {
+	case "":
+		return "oidc:"
+	case "-":
+		return ""
+	}
+	return prefix
+}
+
+// TokenReviewConfig validates service account tokens with the cluster the
+// shield runs in
+type TokenReviewConfig struct {
+	// Audiences the token must be issued for; the API server's own when empty
+	Audiences []string `yaml:"audiences,omitempty"`
+	// CacheTTL and NegativeCacheTTL are how long, in seconds, accepted and
+	// rejected tokens are remembered
+	CacheTTL         int `yaml:"cache_ttl,omitempty"`
+	NegativeCacheTTL int `yaml:"negative_cache_ttl,omitempty"`
+}
+
+// GetCacheTTL defaults to 2 minutes
+func (t *TokenReviewConfig) GetCacheTTL() time.Duration {
+	if t.CacheTTL <= 0 {
+		return 2 * time.Minute
+	}
+	return time.Duration(t.CacheTTL) * time.Second
+}
+
+// GetNegativeCacheTTL defaults to 30 seconds
+func (t *TokenReviewConfig) GetNegativeCacheTTL() time.Duration {
+	if t.NegativeCacheTTL <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(t.NegativeCacheTTL) * time.Second
+}
+
+// HasAuthentication reports whether clients must present a bearer token
+func (c *Config) HasAuthentication() bool {
+	return c.Auth.JWT != nil || c.Auth.TokenReview != nil
+}
+
+// JWTConfig validates bearer tokens against the signing keys an OIDC provider
+// publishes
+type JWTConfig struct {
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// JWKSURL skips OIDC discovery of the signing keys
+	JWKSURL string `yaml:"jwks_url,omitempty"`
+	// EmailClaim and GroupsClaim name the claims mapped into the principal,
+	// email and groups unless set. The email is only used when the token
+	// also has email_verified set to true.
+	EmailClaim  string `yaml:"email_claim,omitempty"`
+	GroupsClaim string `yaml:"groups_claim,omitempty"`
+}
+
+func (j *JWTConfig) GetEmailClaim() string {
+	if j.EmailClaim == "" {
+		return "email"
+	}
+	return j.EmailClaim
+}
+
+func (j *JWTConfig) GetGroupsClaim() string {
+	if j.GroupsClaim == "" {
+		return "groups"
+	}
+	return j.GroupsClaim
+}
+
+func (j *JWTConfig) validate() error {
+	if j.Issuer == "" {
+		return fmt.Errorf("auth.jwt.issuer is required")
+	}
+	if j.Audience == "" {
+		return fmt.Errorf("auth.jwt.audience is required")
+	}
+	return nil
 }
 
 type LogConfig struct {
@@ -35,14 +180,96 @@ type LogConfig struct {
 type ServerConfig struct {
 	Host string `yaml:"host"`
 	Port int    `yaml:"port"`
+	// SessionTimeout is how long, in seconds, an idle client session is kept
+	SessionTimeout int `yaml:"session_timeout,omitempty"`
 }
 
+// NamingConfig shapes the names clients see for the tools and prompts of
+// every server
+type NamingConfig struct {
+	// Template builds a name from {prefix} (the server prefix), {server},
+	// {separator} and {name}, the name the server gave the tool
+	Template  string `yaml:"template,omitempty"`
+	Separator string `yaml:"separator,omitempty"`
+	// MaxLength shortens longer names, ending them with a hash of the full name
+	MaxLength int `yaml:"max_length,omitempty"`
+}
+
+// Naming defaults, which give ms_<server>_<tool> capped at 64 characters
+const (
+	DefaultNamingTemplate  = "ms{separator}{prefix}{separator}{name}"
+	DefaultNamingSeparator = "_"
+	DefaultNamingMaxLength = 64
+	// minNamingMaxLength leaves room for the hash
+	minNamingMaxLength = 16
+)
+
+// Transports supported for remote MCP servers
+const (
+	TransportStreamableHTTP = "streamable-http"
+	TransportSSE            = "sse"
+)
+
+// Policies for sampling requests a server sends to the client
+const (
+	SamplingAllow   = "allow"
+	SamplingBlock   = "block"
+	SamplingApprove = "approve"
+)
+
 type MCPServerConfig struct {
 	Name    string            `yaml:"name"`
 	Image   string            `yaml:"image"`
 	Command string            `yaml:"command"`
 	Args    []string          `yaml:"args"`
 	Env     map[string]string `yaml:"env,omitempty"`
+	// URL, Transport and Headers describe a remote server instead of one we run
+	URL       string            `yaml:"url,omitempty"`
+	Transport string            `yaml:"transport,omitempty"`
+	Headers   map[string]string `yaml:"headers,omitempty"`
+	// Sampling is allow (default), block, or approve to ask the user first
+	Sampling string `yaml:"sampling,omitempty"`
+	// Prefix replaces the server name in tool and prompt names
+	Prefix string `yaml:"prefix,omitempty"`
+	// Aliases exposes tools under fixed names, keyed by the name the server
+	// gives them, bypassing the naming template
+	Aliases map[string]string `yaml:"aliases,omitempty"`
+}
+
+// GetSamplingPolicy returns the sampling policy, allow unless configured
+func (s MCPServerConfig) GetSamplingPolicy() string {
+	if s.Sampling == "" {
+		return SamplingAllow
+	}
+	return s.Sampling
+}
+
+// IsRemote reports whether the server is already hosted and reached over HTTP
+func (s MCPServerConfig) IsRemote() bool {
+	return s.URL != ""
+}
+
+func (s MCPServerConfig) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("mcp server name is required")
+	}
+	switch s.GetSamplingPolicy() {
+	case SamplingAllow, SamplingBlock, SamplingApprove:
+	default:
+		return fmt.Errorf("mcp server %s: unsupported sampling policy %q", s.Name, s.Sampling)
+	}
+	if !s.IsRemote() {
+		return nil
+	}
+	if s.Image != "" || s.Command != "" || len(s.Args) > 0 {
+		return fmt.Errorf("mcp server %s: url cannot be combined with image, command or args", s.Name)
+	}
+	switch s.Transport {
+	case "", TransportStreamableHTTP, TransportSSE:
+		return nil
+	default:
+		return fmt.Errorf("mcp server %s: unsupported transport %q", s.Name, s.Transport)
+	}
 }
 
 type KubernetesConfig struct {
@@ -50,8 +277,21 @@ type KubernetesConfig struct {
 	Kubeconfig string `yaml:"kubeconfig,omitempty"`
 }
 
+// ProcessConfig runs MCP servers as local child processes, for development and CI
+type ProcessConfig struct {
+	WorkDir string `yaml:"workdir,omitempty"`
+}
+
+// DockerConfig runs MCP servers as containers on a local Docker API compatible engine
+type DockerConfig struct {
+	Host    string `yaml:"host,omitempty"`
+	Network string `yaml:"network,omitempty"`
+}
+
 type RuntimeConfig struct {
 	Kubernetes *KubernetesConfig `yaml:"kubernetes"`
+	Process    *ProcessConfig    `yaml:"process"`
+	Docker     *DockerConfig     `yaml:"docker"`
 }
 
 // Config accessor methods
@@ -66,6 +306,50 @@ func (c *Config) HasKubernetesRuntime() bool {
 	return c.Runtime.Kubernetes != nil
 }
 
+func (c *Config) HasProcessRuntime() bool {
+	return c.Runtime.Process != nil
+}
+
+func (c *Config) GetProcessWorkDir() string {
+	if c.Runtime.Process == nil {
+		return ""
+	}
+	return c.Runtime.Process.WorkDir
+}
+
+func (c *Config) HasDockerRuntime() bool {
+	return c.Runtime.Docker != nil
+}
+
+func (c *Config) GetDockerHost() string {
+	if c.Runtime.Docker == nil {
+		return ""
+	}
+	return c.Runtime.Docker.Host
+}
+
+func (c *Config) GetDockerNetwork() string {
+	if c.Runtime.Docker == nil {
+		return ""
+	}
+	return c.Runtime.Docker.Network
+}
+
+// ConfiguredRuntimes lists the names of the runtime sections that are set
+func (c *Config) ConfiguredRuntimes() []string {
+	var runtimes []string
+	if c.HasKubernetesRuntime() {
+		runtimes = append(runtimes, "kubernetes")
+	}
+	if c.HasDockerRuntime() {
+		runtimes = append(runtimes, "docker")
+	}
+	if c.HasProcessRuntime() {
+		runtimes = append(runtimes, "process")
+	}
+	return runtimes
+}
+
 func (c *Config) GetKubeconfig() string {
 	if c.Runtime.Kubernetes == nil || c.Runtime.Kubernetes.Kubeconfig == "" {
 		return ""
@@ -77,6 +361,14 @@ func (c *Config) GetServerAddress() string {
 	return fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port)
 }
 
+// GetSessionIdleTimeout defaults to 30 minutes
+func (c *Config) GetSessionIdleTimeout() time.Duration {
+	if c.Server.SessionTimeout <= 0 {
+		return 30 * time.Minute
+	}
+	return time.Duration(c.Server.SessionTimeout) * time.Second
+}
+
 func (c *Config) GetLogLevel() string {
 	return c.Log.Level
 }
@@ -85,6 +377,87 @@ func (c *Config) GetMCPServers() []MCPServerConfig {
 	return c.MCPServers
 }
 
+// HasLocalMCPServers reports whether any server needs a local runtime
+func (c *Config) HasLocalMCPServers() bool {
+	for _, server := range c.MCPServers {
+		if !server.IsRemote() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) GetNamingTemplate() string {
+	if c.Naming.Template == "" {
+		return DefaultNamingTemplate
+	}
+	return c.Naming.Template
+}
+
+func (c *Config) GetNamingSeparator() string {
+	if c.Naming.Separator == "" {
+		return DefaultNamingSeparator
+	}
+	return c.Naming.Separator
+}
+
+func (c *Config) GetNamingMaxLength() int {
+	if c.Naming.MaxLength <= 0 {
+		return DefaultNamingMaxLength
+	}
+	return c.Naming.MaxLength
+}
+
+func (c *Config) validateNaming() error {
+	if !strings.Contains(c.GetNamingTemplate(), "{name}") {
+		return fmt.Errorf("naming template %q must contain {name}", c.GetNamingTemplate())
+	}
+	if c.GetNamingMaxLength() < minNamingMaxLength {
+		return fmt.Errorf("naming max_length must be at least %d", minNamingMaxLength)
+	}
+
+	// Generated names are only known once servers list their tools, aliases
+	// can be checked now
+	aliases := map[string]string{}
+	for _, server := range c.MCPServers {
+		for tool, alias := range server.Aliases {
+			owner := server.Name + ":" + tool
+			switch {
+			case alias == "":
+				return fmt.Errorf("mcp server %s: empty alias for tool %s", server.Name, tool)
+			case len(alias) > c.GetNamingMaxLength():
+				return fmt.Errorf("mcp server %s: alias %s is longer than %d characters", server.Name, alias, c.GetNamingMaxLength())
+			case aliases[alias] != "":
+				return fmt.Errorf("alias %s is used for both %s and %s", alias, aliases[alias], owner)
+			}
+			aliases[alias] = owner
+		}
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	if c.Auth.JWT != nil {
+		if err := c.Auth.JWT.validate(); err != nil {
+			return err
+		}
+	}
+	if authorization := c.Auth.Authorization; authorization != nil {
+		if authorization.Mode != AuthorizationSubjectAccessReview && authorization.Mode != AuthorizationMCPPermission {
+			return fmt.Errorf("unsupported auth.authorization.mode %q", authorization.Mode)
+		}
+		if !c.HasAuthentication() {
+			return fmt.Errorf("auth.authorization needs auth.jwt or auth.token_review to identify clients")
+		}
+	}
+	for _, server := range c.MCPServers {
+		if err := server.validate(); err != nil {
+			return err
+		}
+	}
+	return c.validateNaming()
+}
+
 func ReadConfig(filename string) (*Config, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
@@ -97,5 +470,9 @@ func ReadConfig(filename string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse YAML: %w", err)
 	}
 
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
 	return &config, nil
 } 
\ No newline at end of file
@@ -0,0 +1,202 @@
+package mcpserver
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nsxbet/mcpshield/pkg"
+	"github.com/nsxbet/mcpshield/pkg/auth"
+)
+
+// ResolveTool maps the name clients call a tool by to the server offering it
+// and the name the server knows it by
+func (p *Proxy) ResolveTool(name string) (string, string, bool) {
+	return p.servers.ToolOwner(name)
+}
+
+// ServerTools returns the tools each server offers, by the names permissions
+// refer to them by
+func (p *Proxy) ServerTools() map[string][]string {
+	return p.servers.ToolsByServer()
+}
+
+// AuthenticateRequests returns middleware authenticating every request with
+// token, for the stdio transport, which carries no credentials of its own.
+// The token is checked per request so that it stops working once it expires
+// or is revoked.
+func AuthenticateRequests(authenticator auth.Authenticator, token string) Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(ctx context.Context, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+			principal, err := authenticator.Authenticate(ctx, token)
+			if err != nil {
+				var authErr *auth.AuthError
+				if errors.As(err, &authErr) {
+					return nil, newRPCError(CodeForbidden, "Unauthorized: %s", authErr.Message)
+				}
+				return nil, newRPCError(CodeForbidden, "Unauthorized: %v", err)
+			}
+			// The stdio session belongs to whoever the token names
+			if session, ok := SessionFromContext(ctx); ok {
+				session.bindPrincipal(principal)
+			}
+			return next(auth.WithPrincipal(ctx, principal), request)
+		}
+	}
+}
+
+// AuthorizeRequests returns middleware checking requests with authorizer
+// against the principal that authenticated them. Denied tool calls, prompts,
+// resource reads and completions of their arguments are answered with
+// CodeForbidden and never reach the server, and tools, prompts and resources lists only show what the
+// principal may use. Log messages are only relayed to principals allowed the
+// logging verb on the server.
+func (p *Proxy) AuthorizeRequests(authorizer *auth.Auth) Middleware {
+	p.authorizer = authorizer
+	return func(next RequestHandler) RequestHandler {
+		return func(ctx context.Context, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+			principal, _ := auth.PrincipalFromContext(ctx)
+
+			switch request.Method {
+			case "tools/call":
+				if err := authorizer.VerifyToolCall(ctx, principal, request); err != nil {
+					return nil, authorizationError(err)
+				}
+			case "prompts/get":
+				name, _ := requestParams(request)["name"].(string)
+				if server, _, ok := p.servers.PromptOwner(name); ok {
+					if err := verifyServerAccess(ctx, authorizer, principal, server, auth.VerbPrompts); err != nil {
+						return nil, err
+					}
+				}
+			case "resources/read", "resources/subscribe":
+				uri, _ := requestParams(request)["uri"].(string)
+				if server, _, ok := parseNamespacedURI(uri); ok {
+					if err := verifyServerAccess(ctx, authorizer, principal, server, auth.VerbResources); err != nil {
+						return nil, err
+					}
+				}
+			case "completion/complete":
+				ref, _ := requestParams(request)["ref"].(map[string]interface{})
+				if server, verb, ok := p.completionOwner(ref); ok {
+					if err := verifyServerAccess(ctx, authorizer, principal, server, verb); err != nil {
+						return nil, err
+					}
+				}
+			}
+
+			response, err := next(ctx, request)
+			if err != nil || response == nil {
+				return response, err
+			}
+			if err := p.filterList(ctx, authorizer, principal, request.Method, response); err != nil {
+				return nil, authorizationError(err)
+			}
+			return response, nil
+		}
+	}
+}
+
+// completionOwner returns the server owning the prompt or resource template a
+// completion refers to, and the verb that guards it
+func (p *Proxy) completionOwner(ref map[string]interface{}) (string, string, bool) {
+	switch ref["type"] {
+	case "ref/prompt":
+		name, _ := ref["name"].(string)
+		server, _, ok := p.servers.PromptOwner(name)
+		return server, auth.VerbPrompts, ok
+	case "ref/resource":
+		uri, _ := ref["uri"].(string)
+		server, _, ok := parseNamespacedURI(uri)
+		return server, auth.VerbResources, ok
+	}
+	return "", "", false
+}
+
+// filterList drops from a list response what the principal may not use
+func (p *Proxy) filterList(ctx context.Context, authorizer *auth.Auth, principal *auth.Principal, method string, response *pkg.MCPResponse) error {
+	result, ok := response.Result.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	switch method {
+	case "tools/list":
+		tools, _ := result["tools"].([]interface{})
+		available, err := authorizer.FetchAvailableTools(ctx, principal, tools)
+		if err != nil {
+			return err
+		}
+		result["tools"] = available
+	case "prompts/list":
+		return filterByServer(ctx, authorizer, principal, result, "prompts", auth.VerbPrompts, func(item map[string]interface{}) (string, bool) {
+			name, _ := item["name"].(string)
+			server, _, ok := p.servers.PromptOwner(name)
+			return server, ok
+		})
+	case "resources/list":
+		return filterByServer(ctx, authorizer, principal, result, "resources", auth.VerbResources, uriOwner("uri"))
+	case "resources/templates/list":
+		return filterByServer(ctx, authorizer, principal, result, "resourceTemplates", auth.VerbResources, uriOwner("uriTemplate"))
+	}
+	return nil
+}
+
+// filterByServer keeps the items under key whose server the principal may
+// use verb on; items of no known server are dropped
+func filterByServer(ctx context.Context, authorizer *auth.Auth, principal *auth.Principal, result map[string]interface{}, key, verb string, owner func(item map[string]interface{}) (string, bool)) error {
+	items, _ := result[key].([]interface{})
+	decisions := map[string]bool{}
+	visible := []interface{}{}
+	for _, item := range items {
+		definition, _ := item.(map[string]interface{})
+		server, ok := owner(definition)
+		if !ok {
+			continue
+		}
+		allowed, decided := decisions[server]
+		if !decided {
+			var err error
+			allowed, err = authorizer.Allowed(ctx, principal, server, verb)
+			if err != nil {
+				return err
+			}
+			decisions[server] = allowed
+		}
+		if allowed {
+			visible = append(visible, item)
+		}
+	}
+	result[key] = visible
+	return nil
+}
+
+// uriOwner returns the server a namespaced URI under key belongs to
+func uriOwner(key string) func(item map[string]interface{}) (string, bool) {
+	return func(item map[string]interface{}) (string, bool) {
+		uri, _ := item[key].(string)
+		server, _, ok := parseNamespacedURI(uri)
+		return server, ok
+	}
+}
+
+// verifyServerAccess fails with CodeForbidden unless the principal may use
+// verb on server
+func verifyServerAccess(ctx context.Context, authorizer *auth.Auth, principal *auth.Principal, server, verb string) error {
+	allowed, err := authorizer.Allowed(ctx, principal, server, verb)
+	if err != nil {
+		return authorizationError(err)
+	}
+	if !allowed {
+		return newRPCError(CodeForbidden, "Forbidden: not allowed to %s on %s", verb, server)
+	}
+	return nil
+}
+
+// authorizationError maps a failed check to the JSON-RPC error clients see
+func authorizationError(err error) error {
+	var authErr *auth.AuthError
+	if errors.As(err, &authErr) {
+		return newRPCError(CodeForbidden, "Forbidden: %s", authErr.Message)
+	}
+	return newRPCError(CodeInternalError, "Authorization failed: %v", err)
+}
@@ -0,0 +1,333 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nsxbet/mcpshield/pkg"
+	"github.com/nsxbet/mcpshield/pkg/auth"
+	"github.com/nsxbet/mcpshield/pkg/sse"
+)
+
+// toolGrants allows each user the server/tool pairs listed
+type toolGrants map[string][]string
+
+func (g toolGrants) Authorize(ctx context.Context, principal *auth.Principal, server, tool string) (bool, error) {
+	for _, grant := range g[principal.Username] {
+		if grant == server+"/"+tool {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// newAuthorizingProxy serves the proxy as username, with requests checked
+// against grants
+func newAuthorizingProxy(t *testing.T, username string, grants toolGrants) (*httptest.Server, map[string]*testUpstream, *auth.Auth) {
+	t.Helper()
+	server, upstreams := newTestProxyWithUpstreams(t, "fake")
+	proxy := server.Config.Handler.(*Proxy)
+
+	authorizer := auth.New(nil, nil)
+	authorizer.SetAuthorizer(grants)
+	authorizer.SetToolResolver(proxy.ResolveTool)
+	proxy.Use(proxy.AuthorizeRequests(authorizer))
+	return servePrincipal(t, proxy, username), upstreams, authorizer
+}
+
+// principalHandler serves the proxy to a client authenticated as principal
+type principalHandler struct {
+	proxy     *Proxy
+	principal *auth.Principal
+}
+
+func (h *principalHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.proxy.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), h.principal)))
+}
+
+// servePrincipal serves the proxy to a client authenticated as username;
+// several of them may share one proxy
+func servePrincipal(t *testing.T, proxy *Proxy, username string) *httptest.Server {
+	t.Helper()
+	authenticated := httptest.NewServer(&principalHandler{proxy: proxy, principal: &auth.Principal{Username: username}})
+	t.Cleanup(authenticated.Close)
+	return authenticated
+}
+
+// proxyBehind returns the proxy a test server serves
+func proxyBehind(server *httptest.Server) *Proxy {
+	if handler, ok := server.Config.Handler.(*principalHandler); ok {
+		return handler.proxy
+	}
+	return server.Config.Handler.(*Proxy)
+}
+
+// listed returns the names, or URIs, of the items under key in a list result
+func listed(t *testing.T, response *pkg.MCPResponse, key, field string) []string {
+	t.Helper()
+	result, _ := response.Result.(map[string]interface{})
+	items, _ := result[key].([]interface{})
+	names := []string{}
+	for _, item := range items {
+		definition, _ := item.(map[string]interface{})
+		name, _ := definition[field].(string)
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestProxyAuthorizesToolCalls(t *testing.T) {
+	grants := toolGrants{"dev@nsx.bet": {"fake/echo"}}
+
+	server, upstreams, _ := newAuthorizingProxy(t, "dev@nsx.bet", grants)
+	sessionID := initializeSession(t, server.URL)
+	call(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"ms_fake_echo","arguments":{}}}`)
+	if upstreams["fake"].count("tools/call") != 1 {
+		t.Fatal("Expected the allowed call to reach the server")
+	}
+
+	server, upstreams, _ = newAuthorizingProxy(t, "intern@nsx.bet", grants)
+	sessionID = initializeSession(t, server.URL)
+	resp := postMessage(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"ms_fake_echo","arguments":{}}}`, "application/json")
+	var response pkg.MCPResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if code := errorCode(t, &response); code != CodeForbidden {
+		t.Fatalf("Expected the call to be forbidden, got %d", code)
+	}
+	if upstreams["fake"].count("tools/call") != 0 {
+		t.Fatal("Expected the denied call not to reach the server")
+	}
+}
+
+func TestProxyFiltersListsPerPrincipal(t *testing.T) {
+	grants := toolGrants{"dev@nsx.bet": {"fake/echo", "fake/" + auth.VerbPrompts, "fake/" + auth.VerbResources}}
+
+	server, _, _ := newAuthorizingProxy(t, "dev@nsx.bet", grants)
+	sessionID := initializeSession(t, server.URL)
+	if tools := listed(t, call(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`), "tools", "name"); len(tools) != 1 || tools[0] != "ms_fake_echo" {
+		t.Fatalf("Expected the granted tool, got %v", tools)
+	}
+	if prompts := listed(t, call(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":2,"method":"prompts/list"}`), "prompts", "name"); len(prompts) != 1 {
+		t.Fatalf("Expected the granted prompt, got %v", prompts)
+	}
+	if resources := listed(t, call(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":3,"method":"resources/list"}`), "resources", "uri"); len(resources) == 0 {
+		t.Fatalf("Expected the granted resources, got %v", resources)
+	}
+
+	server, upstreams, _ := newAuthorizingProxy(t, "intern@nsx.bet", grants)
+	sessionID = initializeSession(t, server.URL)
+	for _, list := range []struct{ method, key, field string }{
+		{"tools/list", "tools", "name"},
+		{"prompts/list", "prompts", "name"},
+		{"resources/list", "resources", "uri"},
+	} {
+		response := call(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":4,"method":"`+list.method+`"}`)
+		if names := listed(t, response, list.key, list.field); len(names) != 0 {
+			t.Fatalf("Expected %s to hide everything, got %v", list.method, names)
+		}
+	}
+
+	resp := postMessage(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":5,"method":"prompts/get","params":{"name":"ms_fake_review"}}`, "application/json")
+	var response pkg.MCPResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if code := errorCode(t, &response); code != CodeForbidden {
+		t.Fatalf("Expected the prompt to be forbidden, got %d", code)
+	}
+	if upstreams["fake"].count("prompts/get") != 0 {
+		t.Fatal("Expected the denied prompt not to reach the server")
+	}
+}
+
+func TestProxyAuthorizesCompletions(t *testing.T) {
+	grants := toolGrants{"dev@nsx.bet": {"fake/" + auth.VerbPrompts, "fake/" + auth.VerbResources}}
+	refs := []string{
+		`{"type":"ref/prompt","name":"ms_fake_review"}`,
+		`{"type":"ref/resource","uri":"ms://fake/file:///{path}"}`,
+	}
+	complete := func(url, sessionID, ref string) *pkg.MCPResponse {
+		resp := postMessage(t, url, sessionID, `{"jsonrpc":"2.0","id":1,"method":"completion/complete","params":{"ref":`+ref+`,"argument":{"name":"path","value":"RE"}}}`, "application/json")
+		var response pkg.MCPResponse
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		return &response
+	}
+
+	server, upstreams, _ := newAuthorizingProxy(t, "dev@nsx.bet", grants)
+	sessionID := initializeSession(t, server.URL)
+	for _, ref := range refs {
+		if response := complete(server.URL, sessionID, ref); response.Error != nil {
+			t.Fatalf("Expected the completion of %s to be allowed, got %+v", ref, response.Error)
+		}
+	}
+	if count := upstreams["fake"].count("completion/complete"); count != len(refs) {
+		t.Fatalf("Expected the allowed completions to reach the server, got %d", count)
+	}
+
+	server, upstreams, _ = newAuthorizingProxy(t, "intern@nsx.bet", grants)
+	sessionID = initializeSession(t, server.URL)
+	for _, ref := range refs {
+		if code := errorCode(t, complete(server.URL, sessionID, ref)); code != CodeForbidden {
+			t.Fatalf("Expected the completion of %s to be forbidden, got %d", ref, code)
+		}
+	}
+	if count := upstreams["fake"].count("completion/complete"); count != 0 {
+		t.Fatalf("Expected the denied completions not to reach the server, got %d", count)
+	}
+}
+
+func TestProxyToolListsFollowPermissionChanges(t *testing.T) {
+	grants := toolGrants{}
+	server, _, authorizer := newAuthorizingProxy(t, "dev@nsx.bet", grants)
+	sessionID := initializeSession(t, server.URL)
+	list := func() []string {
+		return listed(t, call(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`), "tools", "name")
+	}
+
+	if tools := list(); len(tools) != 0 {
+		t.Fatalf("Expected no tools, got %v", tools)
+	}
+
+	// The filtered list is cached until permissions are invalidated
+	grants["dev@nsx.bet"] = []string{"fake/echo"}
+	if tools := list(); len(tools) != 0 {
+		t.Fatalf("Expected the cached list, got %v", tools)
+	}
+	authorizer.InvalidatePermissions()
+	if tools := list(); len(tools) != 1 {
+		t.Fatalf("Expected the newly granted tool, got %v", tools)
+	}
+}
+
+func TestProxyRefusesSessionsOfOtherPrincipals(t *testing.T) {
+	server, _ := newTestProxyWithUpstreams(t, "fake")
+	proxy := server.Config.Handler.(*Proxy)
+	owner := servePrincipal(t, proxy, "dev@nsx.bet")
+	intruder := servePrincipal(t, proxy, "intern@nsx.bet")
+	sessionID := initializeSession(t, owner.URL)
+
+	resp := postMessage(t, intruder.URL, sessionID, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, "application/json")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected 404 for a POST on another principal's session, got %d", resp.StatusCode)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		req, _ := http.NewRequest(method, intruder.URL, nil)
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set(sessionIDHeader, sessionID)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s failed: %v", method, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("Expected 404 for a %s on another principal's session, got %d", method, resp.StatusCode)
+		}
+	}
+
+	// The session is untouched for its owner
+	if response := call(t, owner.URL, sessionID, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`); response.Result == nil {
+		t.Fatalf("Expected the owner to keep the session, got %+v", response)
+	}
+}
+
+func TestProxyRelaysLogMessagesToAuthorizedSessions(t *testing.T) {
+	server, upstreams := newTestProxyWithUpstreams(t, "alpha", "beta")
+	proxy := server.Config.Handler.(*Proxy)
+	authorizer := auth.New(nil, nil)
+	authorizer.SetAuthorizer(toolGrants{
+		"dev@nsx.bet":    {"alpha/" + auth.VerbLogging, "beta/" + auth.VerbLogging},
+		"intern@nsx.bet": {"beta/" + auth.VerbLogging},
+	})
+	proxy.Use(proxy.AuthorizeRequests(authorizer))
+
+	dev := servePrincipal(t, proxy, "dev@nsx.bet")
+	intern := servePrincipal(t, proxy, "intern@nsx.bet")
+	devSession := initializeSession(t, dev.URL)
+	internSession := initializeSession(t, intern.URL)
+	silentSession := initializeSession(t, dev.URL)
+	devEvents := openEventStream(t, dev, devSession)
+	internEvents := openEventStream(t, intern, internSession)
+	silentEvents := openEventStream(t, dev, silentSession)
+	call(t, dev.URL, devSession, `{"jsonrpc":"2.0","id":1,"method":"logging/setLevel","params":{"level":"debug"}}`)
+	call(t, intern.URL, internSession, `{"jsonrpc":"2.0","id":1,"method":"logging/setLevel","params":{"level":"debug"}}`)
+
+	upstreams["alpha"].send(`{"jsonrpc":"2.0","method":"notifications/message","params":{"level":"info","data":"query by dev@nsx.bet"}}`)
+	upstreams["beta"].send(`{"jsonrpc":"2.0","method":"notifications/message","params":{"level":"info","data":"started"}}`)
+
+	logger := func(events <-chan *sse.Event) interface{} {
+		t.Helper()
+		select {
+		case event := <-events:
+			var notification pkg.MCPRequest
+			json.Unmarshal([]byte(event.Data), &notification)
+			return requestParams(&notification)["logger"]
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a log message")
+			return nil
+		}
+	}
+	if first, second := logger(devEvents), logger(devEvents); first != "alpha" || second != "beta" {
+		t.Fatalf("Expected the messages of both servers, got %v and %v", first, second)
+	}
+	// The intern gets beta's message and nothing from alpha, which came first
+	if got := logger(internEvents); got != "beta" {
+		t.Fatalf("Expected only beta's message, got one from %v", got)
+	}
+	select {
+	case event := <-silentEvents:
+		t.Fatalf("Expected nothing for a session that did not set a level, got %s", event.Data)
+	case event := <-internEvents:
+		t.Fatalf("Expected nothing more for the intern, got %s", event.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// tokens authenticates the principals by their token
+type tokens map[string]*auth.Principal
+
+func (t tokens) Authenticate(ctx context.Context, token string) (*auth.Principal, error) {
+	if principal, ok := t[token]; ok {
+		return principal, nil
+	}
+	return nil, &auth.AuthError{Code: "invalid_token", Message: "unknown token"}
+}
+
+func TestProxyAuthorizesStdioRequestsWithTheToken(t *testing.T) {
+	grants := toolGrants{"dev@nsx.bet": {"fake/echo"}}
+	authenticator := tokens{"dev-token": {Username: "dev@nsx.bet"}, "intern-token": {Username: "intern@nsx.bet"}}
+
+	for token, want := range map[string]int{"dev-token": 0, "intern-token": CodeForbidden, "stolen-token": CodeForbidden} {
+		server, upstreams := newTestProxyWithUpstreams(t, "fake")
+		proxy := server.Config.Handler.(*Proxy)
+		authorizer := auth.New(nil, authenticator)
+		authorizer.SetAuthorizer(grants)
+		authorizer.SetToolResolver(proxy.ResolveTool)
+		proxy.Use(AuthenticateRequests(authenticator, token))
+		proxy.Use(proxy.AuthorizeRequests(authorizer))
+
+		client := startStdioClient(t, proxy)
+		client.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"ms_fake_echo","arguments":{}}}`)
+		response := client.next(t)
+		if want == 0 {
+			if response["result"] == nil {
+				t.Fatalf("Expected the call with %s to succeed, got %v", token, response)
+			}
+			continue
+		}
+		if code := errorCode(t, response); code != want {
+			t.Fatalf("Expected the call with %s to be refused, got %v", token, response)
+		}
+		if upstreams["fake"].count("tools/call") != 0 {
+			t.Fatalf("Expected the call with %s not to reach the server", token)
+		}
+	}
+}
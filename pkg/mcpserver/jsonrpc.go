@@ -0,0 +1,155 @@
+package mcpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+// JSON-RPC and MCP error codes
+const (
+	CodeParseError       = -32700
+	CodeInvalidRequest   = -32600
+	CodeMethodNotFound   = -32601
+	CodeInvalidParams    = -32602
+	CodeInternalError    = -32603
+	CodeResourceNotFound = -32002
+	// CodeForbidden answers requests the principal is not allowed to make
+	CodeForbidden = -32003
+)
+
+// RPCError is answered to the client with its JSON-RPC code; any other error
+// a handler returns is answered as an internal error
+type RPCError struct {
+	Code    int
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+func newRPCError(code int, format string, args ...interface{}) *RPCError {
+	return &RPCError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// errorResponseFor answers a failed request with the error's JSON-RPC code
+func errorResponseFor(id interface{}, err error) *pkg.MCPResponse {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return errorResponse(id, rpcErr.Code, rpcErr.Message)
+	}
+	return errorResponse(id, CodeInternalError, err.Error())
+}
+
+// clientMessage is one message posted by a client: a request, a notification,
+// a response to a server-initiated request, or the error answering a message
+// that is not valid JSON-RPC
+type clientMessage struct {
+	request  *pkg.MCPRequest
+	response *pkg.MCPResponse
+	invalid  *pkg.MCPResponse
+}
+
+// decodeMessages decodes a posted body holding a single message or a batch.
+// Malformed JSON and empty batches fail as a whole; every other problem is
+// reported per message so that the rest of a batch is still handled.
+func decodeMessages(body []byte) ([]*clientMessage, bool, *pkg.MCPResponse) {
+	if !json.Valid(body) {
+		return nil, false, errorResponse(nil, CodeParseError, "Parse error")
+	}
+
+	body = bytes.TrimSpace(body)
+	if body[0] != '[' {
+		return []*clientMessage{decodeMessage(body)}, false, nil
+	}
+
+	var elements []json.RawMessage
+	if err := json.Unmarshal(body, &elements); err != nil {
+		return nil, true, errorResponse(nil, CodeParseError, "Parse error")
+	}
+	if len(elements) == 0 {
+		return nil, true, errorResponse(nil, CodeInvalidRequest, "Invalid Request: empty batch")
+	}
+
+	messages := make([]*clientMessage, len(elements))
+	for i, element := range elements {
+		messages[i] = decodeMessage(element)
+	}
+	return messages, true, nil
+}
+
+func decodeMessage(data []byte) *clientMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil || fields == nil {
+		return invalidMessage(nil, "message must be an object")
+	}
+
+	// Ids are echoed as sent, so numbers keep their exact representation
+	var id interface{}
+	if raw, ok := fields["id"]; ok {
+		if id, ok = decodeID(raw); !ok {
+			return invalidMessage(nil, "id must be a string or a number")
+		}
+	}
+
+	if string(fields["jsonrpc"]) != `"2.0"` {
+		return invalidMessage(id, `jsonrpc must be "2.0"`)
+	}
+
+	rawMethod, ok := fields["method"]
+	if !ok {
+		return decodeResponse(id, fields)
+	}
+
+	var method string
+	if err := json.Unmarshal(rawMethod, &method); err != nil || method == "" {
+		return invalidMessage(id, "method must be a non-empty string")
+	}
+
+	request := &pkg.MCPRequest{JSONRPC: "2.0", ID: id, Method: method}
+	if rawParams, ok := fields["params"]; ok && string(rawParams) != "null" {
+		if rawParams[0] != '{' && rawParams[0] != '[' {
+			return invalidMessage(id, "params must be an object or an array")
+		}
+		json.Unmarshal(rawParams, &request.Params)
+	}
+	return &clientMessage{request: request}
+}
+
+// decodeResponse decodes a client's answer to a server-initiated request
+func decodeResponse(id interface{}, fields map[string]json.RawMessage) *clientMessage {
+	_, hasResult := fields["result"]
+	_, hasError := fields["error"]
+	if id == nil || hasResult == hasError {
+		return invalidMessage(id, "message must be a request, a notification or a response")
+	}
+
+	response := &pkg.MCPResponse{JSONRPC: "2.0", ID: id}
+	json.Unmarshal(fields["result"], &response.Result)
+	json.Unmarshal(fields["error"], &response.Error)
+	return &clientMessage{response: response}
+}
+
+func decodeID(raw json.RawMessage) (interface{}, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var id interface{}
+	if err := decoder.Decode(&id); err != nil {
+		return nil, false
+	}
+	switch id.(type) {
+	case string, json.Number:
+		return id, true
+	default:
+		return nil, false
+	}
+}
+
+func invalidMessage(id interface{}, reason string) *clientMessage {
+	return &clientMessage{invalid: errorResponse(id, CodeInvalidRequest, "Invalid Request: "+reason)}
+}
@@ -0,0 +1,151 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func errorCode(t *testing.T, response interface{}) int {
+	t.Helper()
+	data, _ := json.Marshal(response)
+	var decoded struct {
+		Error struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(data, &decoded)
+	return decoded.Error.Code
+}
+
+func TestDecodeMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		failure int
+		// kinds lists each decoded message: request, notification, response
+		// or the code of the error answering it
+		kinds []interface{}
+	}{
+		{name: "request", body: `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, kinds: []interface{}{"request"}},
+		{name: "notification", body: `{"jsonrpc":"2.0","method":"notifications/initialized"}`, kinds: []interface{}{"notification"}},
+		{name: "response", body: `{"jsonrpc":"2.0","id":"mcpshield-1","result":{}}`, kinds: []interface{}{"response"}},
+		{name: "malformed", body: `{"jsonrpc":"2.0",`, failure: CodeParseError},
+		{name: "empty body", body: ``, failure: CodeParseError},
+		{name: "empty batch", body: `[]`, failure: CodeInvalidRequest},
+		{name: "not an object", body: `42`, kinds: []interface{}{CodeInvalidRequest}},
+		{name: "wrong version", body: `{"jsonrpc":"1.0","id":1,"method":"ping"}`, kinds: []interface{}{CodeInvalidRequest}},
+		{name: "null id", body: `{"jsonrpc":"2.0","id":null,"method":"ping"}`, kinds: []interface{}{CodeInvalidRequest}},
+		{name: "object id", body: `{"jsonrpc":"2.0","id":{},"method":"ping"}`, kinds: []interface{}{CodeInvalidRequest}},
+		{name: "method not a string", body: `{"jsonrpc":"2.0","id":1,"method":7}`, kinds: []interface{}{CodeInvalidRequest}},
+		{name: "scalar params", body: `{"jsonrpc":"2.0","id":1,"method":"ping","params":"x"}`, kinds: []interface{}{CodeInvalidRequest}},
+		{name: "result and error", body: `{"jsonrpc":"2.0","id":1,"result":{},"error":{}}`, kinds: []interface{}{CodeInvalidRequest}},
+		{
+			name:  "batch",
+			body:  `[{"jsonrpc":"2.0","id":1,"method":"ping"},{"jsonrpc":"2.0","method":"notifications/initialized"},1]`,
+			kinds: []interface{}{"request", "notification", CodeInvalidRequest},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			messages, _, failure := decodeMessages([]byte(test.body))
+			if test.failure != 0 {
+				if failure == nil || errorCode(t, failure) != test.failure {
+					t.Fatalf("Expected the body to fail with %d, got %+v", test.failure, failure)
+				}
+				return
+			}
+			if failure != nil {
+				t.Fatalf("Unexpected failure %+v", failure)
+			}
+
+			if len(messages) != len(test.kinds) {
+				t.Fatalf("Expected %d messages, got %d", len(test.kinds), len(messages))
+			}
+			for i, message := range messages {
+				var kind interface{}
+				switch {
+				case message.invalid != nil:
+					kind = errorCode(t, message.invalid)
+				case message.response != nil:
+					kind = "response"
+				case message.request.ID == nil:
+					kind = "notification"
+				default:
+					kind = "request"
+				}
+				if kind != test.kinds[i] {
+					t.Errorf("Message %d: expected %v, got %v", i, test.kinds[i], kind)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeMessagesKeepsIDsExact(t *testing.T) {
+	messages, _, _ := decodeMessages([]byte(`{"jsonrpc":"2.0","id":12345678901234567890,"method":"ping"}`))
+	data, _ := json.Marshal(errorResponse(messages[0].request.ID, CodeInternalError, ""))
+	var echoed struct {
+		ID json.RawMessage `json:"id"`
+	}
+	json.Unmarshal(data, &echoed)
+	if string(echoed.ID) != "12345678901234567890" {
+		t.Fatalf("Expected the id to be echoed as sent, got %s", echoed.ID)
+	}
+}
+
+func FuzzDecodeMessages(f *testing.F) {
+	for _, seed := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"x"}}`,
+		`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":1}}`,
+		`{"jsonrpc":"2.0","id":"a","error":{"code":-1,"message":"no"}}`,
+		`[{"jsonrpc":"2.0","id":1,"method":"ping"},{}]`,
+		`[]`, `null`, `"x"`, `{"id":[]}`,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		messages, batch, failure := decodeMessages(body)
+		if failure != nil {
+			if messages != nil {
+				t.Fatal("Expected no messages when the body fails as a whole")
+			}
+			return
+		}
+		if !json.Valid(body) {
+			t.Fatal("Expected invalid JSON to fail as a whole")
+		}
+		if len(messages) == 0 || (!batch && len(messages) != 1) {
+			t.Fatalf("Unexpected message count %d for batch=%v", len(messages), batch)
+		}
+
+		for _, message := range messages {
+			kinds := 0
+			for _, set := range []bool{message.request != nil, message.response != nil, message.invalid != nil} {
+				if set {
+					kinds++
+				}
+			}
+			if kinds != 1 {
+				t.Fatalf("Expected exactly one kind of message, got %+v", message)
+			}
+
+			if message.request != nil {
+				if message.request.Method == "" {
+					t.Fatal("Expected requests to have a method")
+				}
+				// Whatever was accepted must be answerable
+				if _, err := json.Marshal(errorResponse(message.request.ID, CodeInternalError, "")); err != nil {
+					t.Fatalf("Unmarshalable id %v: %v", message.request.ID, err)
+				}
+			}
+			if message.response != nil && message.response.ID == nil {
+				t.Fatal("Expected responses to carry an id")
+			}
+			if message.invalid != nil && errorCode(t, message.invalid) != CodeInvalidRequest {
+				t.Fatalf("Expected invalid messages to be answered with %d", CodeInvalidRequest)
+			}
+		}
+	})
+}
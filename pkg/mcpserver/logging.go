@@ -0,0 +1,135 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nsxbet/mcpshield/pkg"
+	"github.com/nsxbet/mcpshield/pkg/auth"
+)
+
+// logLevels are the severities of MCP logging, least severe first
+var logLevels = []string{"debug", "info", "notice", "warning", "error", "critical", "alert", "emergency"}
+
+// logAuthorizationTimeout bounds deciding whether a session may see a log
+// message
+const logAuthorizationTimeout = 5 * time.Second
+
+// logSeverity ranks a level, or returns -1 for unknown levels
+func logSeverity(level string) int {
+	for i, known := range logLevels {
+		if level == known {
+			return i
+		}
+	}
+	return -1
+}
+
+// ProcessLoggingSetLevel records the session's level. Servers are shared, so
+// they log at the most verbose level any session asked for and messages are
+// filtered per session when relayed.
+func (p *Proxy) ProcessLoggingSetLevel(ctx context.Context, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+	session, ok := SessionFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("logging requires a session")
+	}
+
+	level, _ := requestParams(request)["level"].(string)
+	if logSeverity(level) < 0 {
+		return nil, newRPCError(CodeInvalidParams, "invalid log level: %q", level)
+	}
+
+	session.setLogLevel(level)
+	p.applyLogLevel(ctx)
+	return emptyResult(request), nil
+}
+
+// applyLogLevel sets the servers that log to the most verbose level the live
+// sessions asked for
+func (p *Proxy) applyLogLevel(ctx context.Context) {
+	level := ""
+	for _, session := range p.sessions.List() {
+		sessionLevel := session.LogLevel()
+		if sessionLevel != "" && (level == "" || logSeverity(sessionLevel) < logSeverity(level)) {
+			level = sessionLevel
+		}
+	}
+
+	p.logLevelMu.Lock()
+	defer p.logLevelMu.Unlock()
+
+	// Without any session asking, servers keep whatever level they are at
+	if level == "" || level == p.logLevel {
+		return
+	}
+
+	for _, name := range p.servers.WithCapability("logging") {
+		response, err := p.servers.byName[name].CallContext(ctx, &pkg.MCPRequest{
+			JSONRPC: "2.0",
+			ID:      1,
+			Method:  "logging/setLevel",
+			Params:  map[string]interface{}{"level": level},
+		})
+		if err == nil && response.Error != nil {
+			err = fmt.Errorf("%v", response.Error)
+		}
+		if err != nil {
+			log.Printf("Failed to set log level %s on server %s: %v", level, name, err)
+		}
+	}
+	p.logLevel = level
+}
+
+// relayLogMessage forwards a server's log message, with the server named as
+// the logger, to the sessions that asked for log messages at a level that
+// admits it and whose principal may see the server's logs
+func (p *Proxy) relayLogMessage(serverName string, notification *pkg.MCPRequest) {
+	params := map[string]interface{}{}
+	for key, value := range requestParams(notification) {
+		params[key] = value
+	}
+
+	severity := logSeverity(fmt.Sprint(params["level"]))
+	if severity < 0 {
+		return
+	}
+	if logger, ok := params["logger"].(string); ok && logger != "" {
+		params["logger"] = serverName + "/" + logger
+	} else {
+		params["logger"] = serverName
+	}
+
+	message := &pkg.MCPRequest{
+		JSONRPC: "2.0",
+		Method:  notification.Method,
+		Params:  params,
+	}
+	for _, session := range p.sessions.List() {
+		level := session.LogLevel()
+		if level == "" || severity < logSeverity(level) {
+			continue
+		}
+		if p.mayReadLogs(session, serverName) {
+			session.Send(message)
+		}
+	}
+}
+
+// mayReadLogs reports whether the session's principal may see the log
+// messages of the server, which can tell about other principals' requests
+func (p *Proxy) mayReadLogs(session *Session, serverName string) bool {
+	if p.authorizer == nil {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), logAuthorizationTimeout)
+	defer cancel()
+	allowed, err := p.authorizer.Allowed(ctx, session.owner(), serverName, auth.VerbLogging)
+	if err != nil {
+		log.Printf("Failed to authorize log messages of server %s: %v", serverName, err)
+		return false
+	}
+	return allowed
+}
@@ -0,0 +1,155 @@
+package mcpserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+// hashLength is how many hex digits of the full name end a shortened one
+const hashLength = 8
+
+// Naming turns the names servers give their tools and prompts into the names
+// clients see
+type Naming struct {
+	Template  string
+	Separator string
+	MaxLength int
+}
+
+// DefaultNaming gives ms_<server>_<tool> capped at 64 characters
+func DefaultNaming() *Naming {
+	return &Naming{
+		Template:  pkg.DefaultNamingTemplate,
+		Separator: pkg.DefaultNamingSeparator,
+		MaxLength: pkg.DefaultNamingMaxLength,
+	}
+}
+
+func NewNaming(config *pkg.Config) *Naming {
+	return &Naming{
+		Template:  config.GetNamingTemplate(),
+		Separator: config.GetNamingSeparator(),
+		MaxLength: config.GetNamingMaxLength(),
+	}
+}
+
+// Name fills in the template for a tool or prompt of a server
+func (n *Naming) Name(prefix, server, name string) string {
+	return n.shorten(strings.NewReplacer(
+		"{prefix}", prefix,
+		"{server}", server,
+		"{separator}", n.Separator,
+		"{name}", name,
+	).Replace(n.Template))
+}
+
+// shorten cuts names over the maximum length and appends a hash of the full
+// name, so that the result is stable across restarts and names sharing a
+// long beginning stay distinct
+func (n *Naming) shorten(name string) string {
+	if n.MaxLength <= 0 || len(name) <= n.MaxLength {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	suffix := n.Separator + hex.EncodeToString(sum[:])[:hashLength]
+	keep := max(n.MaxLength-len(suffix), 0)
+	for keep > 0 && !utf8.RuneStart(name[keep]) {
+		keep--
+	}
+	return name[:keep] + suffix
+}
+
+// nameIndex maps the tool and prompt names clients see to the servers
+// offering them. Names claimed by several servers are left out, so that calls
+// cannot reach the wrong one.
+type nameIndex struct {
+	mu      sync.RWMutex
+	tools   map[string]*MCPServer
+	prompts map[string]*MCPServer
+}
+
+// NameCollisionError reports tools or prompts that would be exposed under the
+// same name. Restarting the servers does not resolve it; a prefix or aliases
+// in the configuration do.
+type NameCollisionError struct {
+	Conflicts []string
+}
+
+func (e *NameCollisionError) Error() string {
+	return fmt.Sprintf("name collisions, set a prefix or aliases: %s", strings.Join(e.Conflicts, "; "))
+}
+
+// reindex rebuilds the name index from the servers' registries; it must run
+// whenever a registry changes. Collisions fail with a NameCollisionError, the
+// other names are indexed regardless.
+func (s MCPServers) reindex() error {
+	var conflicts []string
+
+	tools := map[string]*MCPServer{}
+	prompts := map[string]*MCPServer{}
+	toolClaims := map[string]string{}
+	promptClaims := map[string]string{}
+	for _, server := range s.byName {
+		for _, tool := range server.toolRegistry.Tools() {
+			if owner, ok := toolClaims[tool.Name()]; ok {
+				conflicts = append(conflicts, fmt.Sprintf("tool %s is claimed by %s and %s", tool.Name(), owner, tool.Key()))
+				delete(tools, tool.Name())
+				continue
+			}
+			toolClaims[tool.Name()] = tool.Key()
+			tools[tool.Name()] = server
+		}
+		for _, prompt := range server.promptRegistry.Prompts() {
+			if owner, ok := promptClaims[prompt.Name()]; ok {
+				conflicts = append(conflicts, fmt.Sprintf("prompt %s is claimed by %s and %s", prompt.Name(), owner, prompt.Key()))
+				delete(prompts, prompt.Name())
+				continue
+			}
+			promptClaims[prompt.Name()] = prompt.Key()
+			prompts[prompt.Name()] = server
+		}
+	}
+
+	s.names.mu.Lock()
+	s.names.tools = tools
+	s.names.prompts = prompts
+	s.names.mu.Unlock()
+
+	if len(conflicts) == 0 {
+		return nil
+	}
+	sort.Strings(conflicts)
+	return &NameCollisionError{Conflicts: conflicts}
+}
+
+// findTool returns the server offering the tool clients call by name
+func (s MCPServers) findTool(name string) (*MCPServer, *Tool, bool) {
+	s.names.mu.RLock()
+	server, ok := s.names.tools[name]
+	s.names.mu.RUnlock()
+	if !ok {
+		return nil, nil, false
+	}
+	tool, found := server.toolRegistry.FindByName(name)
+	return server, tool, found
+}
+
+// findPrompt returns the server offering the prompt clients get by name
+func (s MCPServers) findPrompt(name string) (*MCPServer, *Prompt, bool) {
+	s.names.mu.RLock()
+	server, ok := s.names.prompts[name]
+	s.names.mu.RUnlock()
+	if !ok {
+		return nil, nil, false
+	}
+	prompt, found := server.promptRegistry.FindByName(name)
+	return server, prompt, found
+}
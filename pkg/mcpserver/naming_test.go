@@ -0,0 +1,128 @@
+package mcpserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+func TestNamingBuildsNames(t *testing.T) {
+	long := strings.Repeat("x", 80)
+	sum := sha256.Sum256([]byte("ms_github_" + long))
+
+	tests := []struct {
+		name   string
+		naming *Naming
+		prefix string
+		tool   string
+		want   string
+	}{
+		{"default", DefaultNaming(), "github", "search", "ms_github_search"},
+		{"custom template", &Naming{Template: "{prefix}{separator}{name}", Separator: ".", MaxLength: 64}, "gh", "search", "gh.search"},
+		{"server placeholder", &Naming{Template: "{server}-{name}", Separator: "_", MaxLength: 64}, "gh", "search", "github-search"},
+		{"shortened", DefaultNaming(), "github", long, "ms_github_" + strings.Repeat("x", 45) + "_" + hex.EncodeToString(sum[:])[:8]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.naming.Name(tt.prefix, "github", tt.tool)
+			if got != tt.want {
+				t.Fatalf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNamingKeepsShortenedNamesDistinct(t *testing.T) {
+	naming := DefaultNaming()
+	shared := strings.Repeat("y", 70)
+
+	first := naming.Name("github", "github", shared+"_first")
+	second := naming.Name("github", "github", shared+"_second")
+	if first == second {
+		t.Fatalf("Expected names sharing a long beginning to differ, both are %s", first)
+	}
+}
+
+func testServer(name, prefix string, tools ...string) *MCPServer {
+	server := &MCPServer{
+		Name:           name,
+		Prefix:         prefix,
+		naming:         DefaultNaming(),
+		toolRegistry:   NewToolRegistry(),
+		promptRegistry: NewPromptRegistry(),
+	}
+	var definitions []Tool
+	for _, tool := range tools {
+		definitions = append(definitions, Tool{originalName: tool, serverName: name, name: server.toolName(tool)})
+	}
+	server.toolRegistry.Replace(definitions)
+	return server
+}
+
+func TestServersReportNameCollisions(t *testing.T) {
+	// Underscores in server names make ms_a_b_c ambiguous
+	servers := newMCPServers()
+	servers.byName["a_b"] = testServer("a_b", "", "c")
+	servers.byName["a"] = testServer("a", "", "b_c")
+	err := servers.reindex()
+	var collision *NameCollisionError
+	if !errors.As(err, &collision) || !strings.Contains(err.Error(), "ms_a_b_c") {
+		t.Fatalf("Expected a collision on ms_a_b_c, got %v", err)
+	}
+	if _, _, found := servers.ToolOwner("ms_a_b_c"); found {
+		t.Fatal("Expected the ambiguous name not to resolve to either server")
+	}
+
+	servers.byName["a_b"] = testServer("a_b", "ab", "c")
+	if err := servers.reindex(); err != nil {
+		t.Fatalf("Expected the prefix to resolve the collision, got %v", err)
+	}
+
+	server, original, found := servers.ToolOwner("ms_a_b_c")
+	if !found || server != "a" || original != "b_c" {
+		t.Fatalf("Expected the index to find b_c on a, got %s %s", server, original)
+	}
+	if server, original, _ := servers.ToolOwner("ms_ab_c"); server != "a_b" || original != "c" {
+		t.Fatalf("Expected the index to find c on a_b, got %s %s", server, original)
+	}
+}
+
+func TestProxyNamesToolsWithPrefixesAndAliases(t *testing.T) {
+	server, _ := newTestProxyWithConfig(t, func(config *pkg.MCPServerConfig) {
+		switch config.Name {
+		case "fake":
+			config.Aliases = map[string]string{"echo": "say"}
+		case "other":
+			config.Prefix = "o"
+		}
+	}, "fake", "other")
+	sessionID := initializeSession(t, server.URL)
+
+	response := call(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	var names []string
+	for _, tool := range response.Result.(map[string]interface{})["tools"].([]interface{}) {
+		names = append(names, tool.(map[string]interface{})["name"].(string))
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "ms_o_echo,say" {
+		t.Fatalf("Unexpected tool names %v", names)
+	}
+
+	response = call(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"say","arguments":{}}}`)
+	data, _ := json.Marshal(response.Result)
+	if !strings.Contains(string(data), `"text":"echo"`) {
+		t.Fatalf("Expected the alias to call echo, got %s", data)
+	}
+
+	response = call(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":3,"method":"prompts/get","params":{"name":"ms_o_review","arguments":{}}}`)
+	if response.Result == nil {
+		t.Fatal("Expected the prefixed prompt to be found")
+	}
+}
@@ -0,0 +1,165 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+// messageSender delivers server-initiated messages to a client, e.g. the SSE
+// stream answering a request
+type messageSender interface {
+	Send(message interface{}) error
+}
+
+type requestStreamContextKey struct{}
+
+// withRequestStream records the stream the response to the current request
+// will be sent on, so that related messages can go there too
+func withRequestStream(ctx context.Context, stream messageSender) context.Context {
+	return context.WithValue(ctx, requestStreamContextKey{}, stream)
+}
+
+func requestStreamFromContext(ctx context.Context) (messageSender, bool) {
+	stream, ok := ctx.Value(requestStreamContextKey{}).(messageSender)
+	return stream, ok
+}
+
+// progressTarget is where progress for one upstream token goes back to, and
+// the server the token was sent to
+type progressTarget struct {
+	server      string
+	session     *Session
+	stream      messageSender
+	clientToken interface{}
+}
+
+// ProgressRouter rewrites client progress tokens into tokens unique across
+// every upstream, and routes upstream progress notifications back to the
+// client that asked for them
+type ProgressRouter struct {
+	targets map[string]progressTarget
+	nextID  int64
+	mu      sync.Mutex
+}
+
+func NewProgressRouter() *ProgressRouter {
+	return &ProgressRouter{
+		targets: make(map[string]progressTarget),
+	}
+}
+
+// Register returns the token to send upstream to server in place of
+// clientToken and a function to call once the request is done. stream may be
+// nil, in which case progress goes to the session's standalone streams.
+func (r *ProgressRouter) Register(server string, session *Session, stream messageSender, clientToken interface{}) (string, func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	token := fmt.Sprintf("mcpshield-%d", r.nextID)
+	r.targets[token] = progressTarget{
+		server:      server,
+		session:     session,
+		stream:      stream,
+		clientToken: clientToken,
+	}
+
+	return token, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.targets, token)
+	}
+}
+
+// Route delivers a progress notification from server with the client's own
+// token and reports whether the token was known. Tokens are only honoured
+// from the server they were sent to, since another server could guess them.
+func (r *ProgressRouter) Route(server string, notification *pkg.MCPRequest) bool {
+	params := map[string]interface{}{}
+	for key, value := range requestParams(notification) {
+		params[key] = value
+	}
+	token, _ := params["progressToken"].(string)
+
+	r.mu.Lock()
+	target, ok := r.targets[token]
+	r.mu.Unlock()
+	if !ok || target.server != server {
+		return false
+	}
+
+	params["progressToken"] = target.clientToken
+	message := &pkg.MCPRequest{
+		JSONRPC: "2.0",
+		Method:  notification.Method,
+		Params:  params,
+	}
+	if target.stream != nil && target.stream.Send(message) == nil {
+		return true
+	}
+	target.session.Send(message)
+	return true
+}
+
+// requestServer returns the server a request is routed to, for the requests
+// that go to a single one
+func (p *Proxy) requestServer(request *pkg.MCPRequest) (string, bool) {
+	params := requestParams(request)
+	switch request.Method {
+	case "tools/call":
+		name, _ := params["name"].(string)
+		server, _, ok := p.servers.ToolOwner(name)
+		return server, ok
+	case "prompts/get":
+		name, _ := params["name"].(string)
+		server, _, ok := p.servers.PromptOwner(name)
+		return server, ok
+	case "resources/read", "resources/subscribe", "resources/unsubscribe":
+		uri, _ := params["uri"].(string)
+		server, _, ok := parseNamespacedURI(uri)
+		return server, ok
+	case "completion/complete":
+		ref, _ := params["ref"].(map[string]interface{})
+		server, _, ok := p.completionOwner(ref)
+		return server, ok
+	}
+	return "", false
+}
+
+// progressToken returns the request's _meta.progressToken, if any
+func progressToken(request *pkg.MCPRequest) (interface{}, bool) {
+	meta, ok := requestParams(request)["_meta"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	token, ok := meta["progressToken"]
+	return token, ok && token != nil
+}
+
+// withProgressToken returns a copy of the request carrying token as its
+// _meta.progressToken
+func withProgressToken(request *pkg.MCPRequest, token string) *pkg.MCPRequest {
+	params := map[string]interface{}{}
+	for key, value := range requestParams(request) {
+		params[key] = value
+	}
+
+	meta := map[string]interface{}{}
+	if original, ok := params["_meta"].(map[string]interface{}); ok {
+		for key, value := range original {
+			meta[key] = value
+		}
+	}
+	meta["progressToken"] = token
+	params["_meta"] = meta
+
+	return &pkg.MCPRequest{
+		JSONRPC: request.JSONRPC,
+		ID:      request.ID,
+		Method:  request.Method,
+		Params:  params,
+	}
+}
@@ -0,0 +1,106 @@
+package mcpserver
+
+import (
+	"fmt"
+	"sync"
+)
+
+type Prompt struct {
+	originalName string
+	serverName   string
+	// name is what clients ask for the prompt by, given by the server's naming
+	name       string
+	definition map[string]interface{}
+}
+
+func (p *Prompt) Key() string {
+	return fmt.Sprintf("%s:%s", p.serverName, p.originalName)
+}
+
+func (p *Prompt) Name() string {
+	return p.name
+}
+
+func (p *Prompt) GetServerName() string {
+	return p.serverName
+}
+
+func (p *Prompt) GetOriginalName() string {
+	return p.originalName
+}
+
+type PromptRegistry struct {
+	prompts map[string]Prompt
+	// names indexes the prompts by the name clients ask for them by
+	names map[string]string
+	mu    sync.RWMutex
+}
+
+func NewPromptRegistry() *PromptRegistry {
+	return &PromptRegistry{
+		prompts: make(map[string]Prompt),
+		names:   make(map[string]string),
+	}
+}
+
+func (pr *PromptRegistry) UpdatePrompt(prompt Prompt) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	key := prompt.Key()
+	if existing, ok := pr.prompts[key]; ok {
+		delete(pr.names, existing.Name())
+	}
+	pr.prompts[key] = prompt
+	pr.names[prompt.Name()] = key
+}
+
+func (pr *PromptRegistry) ToList() []interface{} {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	var prompts []interface{}
+	for _, prompt := range pr.prompts {
+		// Create a copy of the definition with the prefixed name
+		promptDef := make(map[string]interface{})
+		for k, v := range prompt.definition {
+			promptDef[k] = v
+		}
+		promptDef["name"] = prompt.Name()
+		prompts = append(prompts, promptDef)
+	}
+	return prompts
+}
+
+func (pr *PromptRegistry) Print() {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	for _, prompt := range pr.prompts {
+		fmt.Printf("  💬 %s -> %s (from %s)\n", prompt.GetOriginalName(), prompt.Name(), prompt.GetServerName())
+	}
+}
+
+// Prompts returns a copy of every prompt
+func (pr *PromptRegistry) Prompts() []Prompt {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	prompts := make([]Prompt, 0, len(pr.prompts))
+	for _, prompt := range pr.prompts {
+		prompts = append(prompts, prompt)
+	}
+	return prompts
+}
+
+func (pr *PromptRegistry) FindByName(name string) (*Prompt, bool) {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	key, ok := pr.names[name]
+	if !ok {
+		return nil, false
+	}
+	prompt := pr.prompts[key]
+	return &prompt, true
+}
@@ -2,86 +2,212 @@ package mcpserver
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nsxbet/mcpshield/pkg"
+	"github.com/nsxbet/mcpshield/pkg/auth"
 )
 
 type Proxy struct {
-	servers MCPServers
-	config  *pkg.Config
+	servers       MCPServers
+	config        *pkg.Config
+	sessions      *SessionRegistry
+	subscriptions *SubscriptionTable
+	progress      *ProgressRouter
+	middleware    []Middleware
+	logLevel      string
+	logLevelMu    sync.Mutex
+	// authorizer decides who sees what servers send on their own initiative,
+	// once AuthorizeRequests is in use
+	authorizer *auth.Auth
 }
 
-func NewProxy(config *pkg.Config, factory pkg.RuntimeFactory) *Proxy {
-	return &Proxy{
-		servers: NewServers(config, factory),
-		config:  config,
+// RequestHandler answers one JSON-RPC request
+type RequestHandler func(ctx context.Context, request *pkg.MCPRequest) (*pkg.MCPResponse, error)
+
+// Middleware wraps request handling, e.g. for auth, rate limits or audit. The
+// client session is available through SessionFromContext.
+type Middleware func(next RequestHandler) RequestHandler
+
+func NewProxy(config *pkg.Config, factory pkg.RuntimeFactory) (*Proxy, error) {
+	servers, err := NewServers(config, factory)
+	if err != nil {
+		return nil, err
 	}
+	p := &Proxy{
+		servers:       servers,
+		config:        config,
+		sessions:      NewSessionRegistry(config.GetSessionIdleTimeout()),
+		subscriptions: NewSubscriptionTable(),
+		progress:      NewProgressRouter(),
+	}
+	p.servers.OnMessage(p.handleServerMessage)
+	p.sessions.OnClose(p.dropSubscriptions)
+	p.sessions.OnClose(func(*Session) { p.applyLogLevel(context.Background()) })
+	return p, nil
+}
+
+// Use adds middleware around request handling; the first added runs
+// outermost. It must be called before the proxy serves requests.
+func (p *Proxy) Use(middleware Middleware) {
+	p.middleware = append(p.middleware, middleware)
+}
+
+// Sessions returns the live client sessions
+func (p *Proxy) Sessions() *SessionRegistry {
+	return p.sessions
 }
 
-// ServeHTTP makes Proxy implement http.Handler
-func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+func (p *Proxy) handle(ctx context.Context, request *pkg.MCPRequest) (response *pkg.MCPResponse, err error) {
+	// A bug in one handler must not take down the proxy and its other clients
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("Recovered from panic handling %s: %v", request.Method, recovered)
+			response, err = nil, fmt.Errorf("internal error handling %s", request.Method)
+		}
+	}()
 	
-	if r.Method != http.MethodPost {
-		p.writeError(w, 1, -32603, "Method not allowed")
-		return
+	if session, ok := SessionFromContext(ctx); ok && request.ID != nil {
+		// In-flight requests can be cancelled by the client with notifications/cancelled
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer session.trackRequest(request.ID, cancel)()
+		
+		// Progress tokens are rewritten so that they are unique upstream, and
+		// bound to the server the request goes to; requests served by several
+		// servers or none get no progress
+		if clientToken, ok := progressToken(request); ok {
+			stream, _ := requestStreamFromContext(ctx)
+			server, _ := p.requestServer(request)
+			token, release := p.progress.Register(server, session, stream, clientToken)
+			defer release()
+			request = withProgressToken(request, token)
+		}
 	}
-
-	var request pkg.MCPRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		p.writeError(w, 1, -32603, err.Error())
-		return
+	
+	handler := RequestHandler(p.dispatch)
+	for i := len(p.middleware) - 1; i >= 0; i-- {
+		handler = p.middleware[i](handler)
 	}
-
-	var response *pkg.MCPResponse
-	var err error
 	
+	response, err = handler(ctx, request)
+	if session, ok := SessionFromContext(ctx); ok && err == nil && response != nil {
+		translateResponse(session.protocolVersion(), request.Method, response)
+	}
+	return response, err
+}
+
+func (p *Proxy) dispatch(ctx context.Context, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
 	switch request.Method {
 	case "initialize":
-		response, err = p.ProcessInitialize(&request)
-	case "notifications/initialized":
-		response = &pkg.MCPResponse{
-			JSONRPC: "2.0",
-			ID:      request.ID,
-		}
+		return p.ProcessInitialize(request)
+	case "ping":
+		return emptyResult(request), nil
 	case "tools/list":
-		response, err = p.ProcessList(&request)
+		return p.ProcessList(request)
 	case "tools/call":
-		response, err = p.ProcessCall(&request)
+		return p.ProcessCall(ctx, request)
+	case "prompts/list":
+		return p.ProcessPromptsList(request)
+	case "prompts/get":
+		return p.ProcessPromptsGet(ctx, request)
+	case "resources/list":
+		return p.ProcessResourcesList(request)
+	case "resources/templates/list":
+		return p.ProcessResourceTemplatesList(request)
+	case "resources/read":
+		return p.ProcessResourcesRead(ctx, request)
+	case "resources/subscribe":
+		return p.ProcessResourcesSubscribe(ctx, request)
+	case "resources/unsubscribe":
+		return p.ProcessResourcesUnsubscribe(ctx, request)
+	case "logging/setLevel":
+		return p.ProcessLoggingSetLevel(ctx, request)
+	case "completion/complete":
+		return p.ProcessCompletion(ctx, request)
 	default:
-		log.Printf("🔍 DEBUG: Received method: '%s' with params: %+v", request.Method, request.Params)
-		log.Printf("🚨🚨🚨 CRITICAL ERROR: Method '%s' is not implemented - only tool calls and tools list are supported! 🚨🚨🚨", request.Method)
-		p.writeError(w, request.ID, -32603, "🚨🚨🚨 CRITICAL ERROR: Method '"+request.Method+"' is not implemented - only tool calls and tools list are supported! 🚨🚨🚨")
+		log.Printf("🔍 Method not found: '%s'", request.Method)
+		return nil, newRPCError(CodeMethodNotFound, "Method not found: %s", request.Method)
+	}
+}
+
+// requestParams returns the request params as an object, or an empty one
+func requestParams(request *pkg.MCPRequest) map[string]interface{} {
+	params, ok := request.Params.(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{}
+	}
+	return params
+}
+
+// handleNotification acts on a notification sent by the client
+func (p *Proxy) handleNotification(session *Session, notification *pkg.MCPRequest) {
+	switch notification.Method {
+	case "notifications/initialized":
+		session.markInitialized()
+	case "notifications/cancelled":
+		// Cancelling the context makes the runtime forward the cancellation
+		// upstream with the id the server knows the request by
+		if requestID, ok := requestParams(notification)["requestId"]; ok {
+			session.cancelRequest(requestID)
+		}
+	}
+}
+
+// handleServerMessage routes a message an upstream server sent on its own initiative
+func (p *Proxy) handleServerMessage(ctx context.Context, server *MCPServer, message *pkg.MCPRequest) {
+	if message.ID != nil {
+		go p.handleServerRequest(ctx, server, message)
 		return
 	}
+	
+	switch message.Method {
+	case "notifications/resources/updated":
+		p.forwardResourceUpdated(server.Name, message)
+	case "notifications/progress":
+		p.progress.Route(server.Name, message)
+	case "notifications/message":
+		p.relayLogMessage(server.Name, message)
+	case "notifications/tools/list_changed":
+		// Discovery waits on the server, so it cannot run on its read loop
+		go p.refreshTools(server)
+	}
+}
 
+// refreshTools rediscovers a server's tools after it announced a change and
+// tells every client session when the aggregated list changed
+func (p *Proxy) refreshTools(server *MCPServer) {
+	changed, err := server.RefreshTools()
 	if err != nil {
-		p.writeError(w, request.ID, -32603, err.Error())
+		log.Printf("Failed to refresh tools for server %s: %v", server.Name, err)
 		return
 	}
-
-	json.NewEncoder(w).Encode(response)
+	if err := p.servers.reindex(); err != nil {
+		log.Printf("Tools of server %s collide with others and are not served until renamed: %v", server.Name, err)
+	}
+	if changed {
+		p.notifyClients(&pkg.MCPRequest{
+			JSONRPC: "2.0",
+			Method:  "notifications/tools/list_changed",
+		})
+	}
 }
 
-func (p *Proxy) writeError(w http.ResponseWriter, id interface{}, code int, message string) {
-	response := &pkg.MCPResponse{
-		JSONRPC: "2.0",
-		ID:      id,
-		Error:   map[string]interface{}{"code": code, "message": message},
-	}
-	json.NewEncoder(w).Encode(response)
+// notifyClients sends a server-initiated notification to every client session
+func (p *Proxy) notifyClients(notification *pkg.MCPRequest) {
+	p.sessions.Broadcast(notification)
 }
 
 func (p *Proxy) Start(ctx context.Context) error {
 	fmt.Printf("🚀 Starting MCP servers...\n")
 	
+	go p.sessions.Run(ctx)
+	
 	maxRetries := 3
 	baseDelay := 5 * time.Second
 	
@@ -104,6 +230,12 @@ func (p *Proxy) Start(ctx context.Context) error {
 		
 		fmt.Printf("🔄 MCP server startup failed: %v\n", err)
 		
+		// Retrying brings the same names back
+		var collision *NameCollisionError
+		if errors.As(err, &collision) {
+			return err
+		}
+		
 		if attempt < maxRetries-1 {
 			p.servers.StopAll(ctx)
 		}
@@ -119,7 +251,7 @@ func (p *Proxy) Stop(ctx context.Context) {
 }
 
 func (p *Proxy) GetServerCount() int {
-	return len(p.servers)
+	return len(p.servers.byName)
 }
 
 func (p *Proxy) ProcessList(request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
@@ -133,14 +265,64 @@ func (p *Proxy) ProcessList(request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
 	return response, nil
 }
 
-func (p *Proxy) ProcessCall(request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
-	params := request.Params.(map[string]interface{})
-	toolName, ok := params["name"].(string)
+func (p *Proxy) ProcessCall(ctx context.Context, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+	toolName, ok := requestParams(request)["name"].(string)
+	if !ok {
+		return nil, newRPCError(CodeInvalidParams, "missing tool name in request")
+	}
+	
+	return p.servers.CallTool(ctx, toolName, request)
+}
+
+func (p *Proxy) ProcessPromptsList(request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+	response := &pkg.MCPResponse{
+		JSONRPC: "2.0",
+		ID:      request.ID,
+	}
+	response.Result = map[string]interface{}{
+		"prompts": p.servers.AllPrompts(),
+	}
+	return response, nil
+}
+
+func (p *Proxy) ProcessPromptsGet(ctx context.Context, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+	promptName, ok := requestParams(request)["name"].(string)
 	if !ok {
-		return nil, fmt.Errorf("missing tool name in request")
+		return nil, newRPCError(CodeInvalidParams, "missing prompt name in request")
 	}
 	
-	return p.servers.CallTool(toolName, request)
+	return p.servers.GetPrompt(ctx, promptName, request)
+}
+
+// ProcessCompletion routes completion/complete to the server owning the
+// referenced prompt or resource template
+func (p *Proxy) ProcessCompletion(ctx context.Context, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+	ref, _ := requestParams(request)["ref"].(map[string]interface{})
+	original := map[string]interface{}{}
+	for key, value := range ref {
+		original[key] = value
+	}
+	
+	switch ref["type"] {
+	case "ref/prompt":
+		name, _ := ref["name"].(string)
+		serverName, promptName, ok := p.servers.PromptOwner(name)
+		if !ok {
+			return nil, newRPCError(CodeInvalidParams, "prompt not found: %s", name)
+		}
+		original["name"] = promptName
+		return p.servers.Complete(ctx, serverName, original, request)
+	case "ref/resource":
+		uri, _ := ref["uri"].(string)
+		serverName, templateURI, ok := parseNamespacedURI(uri)
+		if !ok {
+			return nil, newRPCError(CodeInvalidParams, "unknown resource template: %s", uri)
+		}
+		original["uri"] = templateURI
+		return p.servers.Complete(ctx, serverName, original, request)
+	default:
+		return nil, newRPCError(CodeInvalidParams, "unsupported completion reference: %v", ref["type"])
+	}
 }
 
 func (p *Proxy) ProcessInitialize(request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
@@ -178,12 +360,29 @@ func (p *Proxy) ProcessInitialize(request *pkg.MCPRequest) (*pkg.MCPResponse, er
 		}
 	}
 	
+	// Resources and prompts are served by the proxy itself, without list
+	// change notifications
+	for _, capability := range []string{"resources", "prompts"} {
+		delete(aggregatedCapabilities, capability)
+		if len(p.servers.WithCapability(capability)) > 0 {
+			aggregatedCapabilities[capability] = map[string]interface{}{}
+		}
+	}
+	if resources, ok := aggregatedCapabilities["resources"].(map[string]interface{}); ok {
+		for _, name := range p.servers.WithCapability("resources") {
+			if options, _ := p.servers.byName[name].Capability("resources"); options["subscribe"] == true {
+				resources["subscribe"] = true
+			}
+		}
+	}
+	
 	response := &pkg.MCPResponse{
 		JSONRPC: "2.0",
 		ID:      request.ID,
 	}
+	requested, _ := requestParams(request)["protocolVersion"].(string)
 	response.Result = map[string]interface{}{
-		"protocolVersion": "2025-03-26",
+		"protocolVersion": negotiateProtocolVersion(requested),
 		"capabilities":    aggregatedCapabilities,
 		"serverInfo": map[string]interface{}{
 			"name":    "mcpshield-proxy",
@@ -0,0 +1,1472 @@
+package mcpserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsxbet/mcpshield/pkg"
+	"github.com/nsxbet/mcpshield/pkg/mocks"
+	"github.com/nsxbet/mcpshield/pkg/sse"
+	"go.uber.org/mock/gomock"
+)
+
+// fakeUpstream answers the handshake, tool, prompt and resource requests of a
+// single MCP server. Resources are served two pages of one resource each.
+func fakeUpstream(ctx context.Context, input []byte) ([]byte, error) {
+	var request pkg.MCPRequest
+	if err := json.Unmarshal(input, &request); err != nil {
+		return nil, err
+	}
+	if request.ID == nil {
+		return nil, nil
+	}
+
+	params, _ := request.Params.(map[string]interface{})
+	response := &pkg.MCPResponse{JSONRPC: "2.0", ID: request.ID}
+	switch request.Method {
+	case "initialize":
+		response.Result = map[string]interface{}{
+			"protocolVersion": "2025-03-26",
+			"capabilities": map[string]interface{}{
+				"tools":       map[string]interface{}{},
+				"resources":   map[string]interface{}{"subscribe": true},
+				"prompts":     map[string]interface{}{"listChanged": true},
+				"logging":     map[string]interface{}{},
+				"completions": map[string]interface{}{},
+			},
+		}
+	case "prompts/list":
+		response.Result = map[string]interface{}{
+			"prompts": []interface{}{map[string]interface{}{"name": "review", "arguments": []interface{}{map[string]interface{}{"name": "file"}}}},
+		}
+	case "prompts/get":
+		arguments, _ := params["arguments"].(map[string]interface{})
+		response.Result = map[string]interface{}{
+			"messages": []interface{}{map[string]interface{}{
+				"role":    "user",
+				"content": map[string]interface{}{"type": "text", "text": fmt.Sprintf("%s %v", params["name"], arguments["file"])},
+			}},
+		}
+	case "resources/list":
+		if params["cursor"] == "page-2" {
+			response.Result = map[string]interface{}{
+				"resources": []interface{}{map[string]interface{}{"uri": "file:///b", "name": "b"}},
+			}
+		} else {
+			response.Result = map[string]interface{}{
+				"resources":  []interface{}{map[string]interface{}{"uri": "file:///a", "name": "a"}},
+				"nextCursor": "page-2",
+			}
+		}
+	case "resources/templates/list":
+		response.Result = map[string]interface{}{
+			"resourceTemplates": []interface{}{map[string]interface{}{"uriTemplate": "file:///{path}", "name": "files"}},
+		}
+	case "resources/subscribe", "resources/unsubscribe", "logging/setLevel":
+		response.Result = map[string]interface{}{}
+	case "completion/complete":
+		// Complete with the reference the server knows
+		ref, _ := params["ref"].(map[string]interface{})
+		value := ref["name"]
+		if value == nil {
+			value = ref["uri"]
+		}
+		response.Result = map[string]interface{}{
+			"completion": map[string]interface{}{"values": []interface{}{value}},
+		}
+	case "resources/read":
+		response.Result = map[string]interface{}{
+			"contents": []interface{}{map[string]interface{}{"uri": params["uri"], "text": "contents of " + params["uri"].(string)}},
+		}
+	case "tools/list":
+		response.Result = map[string]interface{}{
+			"tools": []interface{}{map[string]interface{}{"name": "echo", "inputSchema": map[string]interface{}{}}},
+		}
+	case "tools/call":
+		response.Result = map[string]interface{}{
+			"content": []interface{}{map[string]interface{}{"type": "text", "text": params["name"]}},
+		}
+	default:
+		response.Error = map[string]interface{}{"code": -32601, "message": "method not found"}
+	}
+	return json.Marshal(response)
+}
+
+func newTestProxy(t *testing.T) *httptest.Server {
+	return newTestProxyWithServers(t, "fake")
+}
+
+// testUpstream wraps fakeUpstream for one server, recording the methods the
+// proxy sent it and letting tests send messages on the server's initiative
+type testUpstream struct {
+	mu      sync.Mutex
+	methods []string
+	handler pkg.MessageHandler
+	// tools replaces the echo tool when set
+	tools []string
+	// cancelled counts calls that were abandoned while in flight
+	cancelled int
+	// progressTokens records the tokens calls reported progress with
+	progressTokens []interface{}
+	// replies receives the answers to requests the server sent the client
+	replies chan *pkg.MCPResponse
+	// perRequest sends server requests on the stream of the request they
+	// serve, like a Streamable HTTP server
+	perRequest bool
+	// subscribeResults, when set, holds resources/subscribe until it yields
+	// the error to refuse with, or nil to accept
+	subscribeResults chan error
+}
+
+func (u *testUpstream) exec(ctx context.Context, input []byte) ([]byte, error) {
+	var request pkg.MCPRequest
+	if err := json.Unmarshal(input, &request); err != nil {
+		return nil, err
+	}
+
+	if request.Method == "" {
+		var response pkg.MCPResponse
+		json.Unmarshal(input, &response)
+		u.replies <- &response
+		return nil, nil
+	}
+
+	u.mu.Lock()
+	u.methods = append(u.methods, request.Method)
+	tools := u.tools
+	subscribeResults := u.subscribeResults
+	u.mu.Unlock()
+
+	if request.Method == "resources/subscribe" && subscribeResults != nil {
+		if err := <-subscribeResults; err != nil {
+			return json.Marshal(&pkg.MCPResponse{JSONRPC: "2.0", ID: request.ID, Error: map[string]interface{}{"code": CodeInternalError, "message": err.Error()}})
+		}
+	}
+
+	// Calls with a wait argument run until they are cancelled
+	arguments, _ := requestParams(&request)["arguments"].(map[string]interface{})
+	if request.Method == "tools/call" && arguments["wait"] == true {
+		<-ctx.Done()
+		u.mu.Lock()
+		u.cancelled++
+		u.mu.Unlock()
+		return nil, ctx.Err()
+	}
+
+	// Calls with a progress argument report progress before answering
+	if request.Method == "tools/call" && arguments["progress"] == true {
+		token, _ := progressToken(&request)
+		u.mu.Lock()
+		u.progressTokens = append(u.progressTokens, token)
+		u.mu.Unlock()
+
+		notification, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"method":  "notifications/progress",
+			"params":  map[string]interface{}{"progressToken": token, "progress": 50, "total": 100},
+		})
+		u.send(string(notification))
+	}
+
+	// Calls with a sample argument ask the client for a sampled message and
+	// answer with it
+	if request.Method == "tools/call" && arguments["sample"] == true {
+		sample := `{"jsonrpc":"2.0","id":"srv-1","method":"sampling/createMessage","params":{"messages":[],"maxTokens":10}}`
+		if handler, ok := pkg.MessageHandlerFromContext(ctx); ok && u.perRequest {
+			handler([]byte(sample))
+		} else {
+			u.send(sample)
+		}
+
+		var reply *pkg.MCPResponse
+		select {
+		case reply = <-u.replies:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		text := fmt.Sprintf("error %v", reply.Error)
+		if result, ok := reply.Result.(map[string]interface{}); ok {
+			text = result["content"].(map[string]interface{})["text"].(string)
+		}
+		return json.Marshal(&pkg.MCPResponse{JSONRPC: "2.0", ID: request.ID, Result: map[string]interface{}{
+			"content": []interface{}{map[string]interface{}{"type": "text", "text": text}},
+		}})
+	}
+
+	if request.Method == "tools/list" && tools != nil {
+		var definitions []interface{}
+		for _, name := range tools {
+			definitions = append(definitions, map[string]interface{}{"name": name, "inputSchema": map[string]interface{}{}})
+		}
+		return json.Marshal(&pkg.MCPResponse{JSONRPC: "2.0", ID: request.ID, Result: map[string]interface{}{"tools": definitions}})
+	}
+	return fakeUpstream(ctx, input)
+}
+
+func (u *testUpstream) setTools(tools ...string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.tools = tools
+}
+
+func (u *testUpstream) setHandler(handler pkg.MessageHandler) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.handler = handler
+}
+
+func (u *testUpstream) cancelledCalls() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.cancelled
+}
+
+// count returns how many times the proxy sent the method
+func (u *testUpstream) count(method string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	count := 0
+	for _, sent := range u.methods {
+		if sent == method {
+			count++
+		}
+	}
+	return count
+}
+
+// send delivers a message as if the server had sent it
+func (u *testUpstream) send(message string) {
+	u.mu.Lock()
+	handler := u.handler
+	u.mu.Unlock()
+	handler([]byte(message))
+}
+
+// newTestProxyWithServers serves a proxy over one fakeUpstream per server name
+func newTestProxyWithServers(t *testing.T, names ...string) *httptest.Server {
+	server, _ := newTestProxyWithUpstreams(t, names...)
+	return server
+}
+
+func newTestProxyWithUpstreams(t *testing.T, names ...string) (*httptest.Server, map[string]*testUpstream) {
+	t.Helper()
+	return newTestProxyWithConfig(t, func(*pkg.MCPServerConfig) {}, names...)
+}
+
+// newTestProxyWithConfig is newTestProxyWithUpstreams with a hook to adjust
+// each server's configuration
+func newTestProxyWithConfig(t *testing.T, configure func(*pkg.MCPServerConfig), names ...string) (*httptest.Server, map[string]*testUpstream) {
+	t.Helper()
+	ctrl := gomock.NewController(t)
+
+	mockFactory := mocks.NewMockRuntimeFactory(ctrl)
+	config := &pkg.Config{}
+	upstreams := make(map[string]*testUpstream)
+	for _, name := range names {
+		upstream := &testUpstream{replies: make(chan *pkg.MCPResponse, 1)}
+		upstreams[name] = upstream
+
+		mockRuntime := mocks.NewMockRuntime(ctrl)
+		mockRuntime.EXPECT().Start(gomock.Any()).Return(nil)
+		mockRuntime.EXPECT().IsReady().Return(true).AnyTimes()
+		mockRuntime.EXPECT().Exec(gomock.Any(), gomock.Any()).DoAndReturn(upstream.exec).AnyTimes()
+		mockRuntime.EXPECT().Stop(gomock.Any()).Return(nil).AnyTimes()
+		mockRuntime.EXPECT().SetMessageHandler(gomock.Any()).Do(upstream.setHandler).AnyTimes()
+
+		mockFactory.EXPECT().CreateRuntime(name, name, gomock.Any(), gomock.Any(), gomock.Any()).Return(mockRuntime)
+		serverConfig := pkg.MCPServerConfig{Name: name, Image: name, Command: "fake"}
+		configure(&serverConfig)
+		config.MCPServers = append(config.MCPServers, serverConfig)
+	}
+
+	proxy, err := NewProxy(config, mockFactory)
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+	if err := proxy.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	t.Cleanup(func() { proxy.Stop(context.Background()) })
+
+	server := httptest.NewServer(proxy)
+	t.Cleanup(server.Close)
+	return server, upstreams
+}
+
+func postMessage(t *testing.T, url, sessionID, body, accept string) *http.Response {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+	if sessionID != "" {
+		req.Header.Set(sessionIDHeader, sessionID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// initializeSession performs the handshake and returns the minted session id
+func initializeSession(t *testing.T, url string) string {
+	t.Helper()
+	return initializeSessionWithVersion(t, url, "2025-03-26")
+}
+
+func initializeSessionWithVersion(t *testing.T, url, version string) string {
+	t.Helper()
+
+	body := `{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":"` + version + `","capabilities":{"roots":{},"sampling":{},"elicitation":{}},"clientInfo":{"name":"test"}}}`
+	resp := postMessage(t, url, "", body, "application/json, text/event-stream")
+	sessionID := resp.Header.Get(sessionIDHeader)
+	if sessionID == "" {
+		t.Fatal("Expected initialize to mint a session id")
+	}
+
+	resp = postMessage(t, url, sessionID, `{"jsonrpc":"2.0","method":"notifications/initialized"}`, "application/json")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected 202 for notifications/initialized, got %d", resp.StatusCode)
+	}
+	return sessionID
+}
+
+func TestProxyAnswersWithJSON(t *testing.T) {
+	server := newTestProxy(t)
+	sessionID := initializeSession(t, server.URL)
+
+	resp := postMessage(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, "application/json")
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("Expected application/json, got %s", contentType)
+	}
+
+	var response pkg.MCPResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if response.Result == nil {
+		t.Fatalf("Expected a result, got %+v", response)
+	}
+}
+
+func TestProxyStreamsToolCallsOverSSE(t *testing.T) {
+	server := newTestProxy(t)
+	sessionID := initializeSession(t, server.URL)
+
+	body := `{"jsonrpc":"2.0","id":"call-1","method":"tools/call","params":{"name":"ms_fake_echo","arguments":{}}}`
+	resp := postMessage(t, server.URL, sessionID, body, "application/json, text/event-stream")
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Expected text/event-stream, got %s", contentType)
+	}
+
+	event, err := sse.NewReader(resp.Body).Next()
+	if err != nil {
+		t.Fatalf("Failed to read event: %v", err)
+	}
+
+	var response pkg.MCPResponse
+	if err := json.Unmarshal([]byte(event.Data), &response); err != nil {
+		t.Fatalf("Invalid event data %q: %v", event.Data, err)
+	}
+	if response.ID != "call-1" || response.Result == nil {
+		t.Fatalf("Unexpected response: %+v", response)
+	}
+}
+
+func TestProxyAcceptsNotificationsWithoutBody(t *testing.T) {
+	server := newTestProxy(t)
+	sessionID := initializeSession(t, server.URL)
+
+	resp := postMessage(t, server.URL, sessionID, `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":1}}`, "application/json, text/event-stream")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", resp.StatusCode)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	if _, err := reader.Peek(1); err == nil {
+		t.Fatal("Expected an empty body")
+	}
+}
+
+func TestProxyAnswersBatches(t *testing.T) {
+	server := newTestProxy(t)
+	sessionID := initializeSession(t, server.URL)
+
+	body := `[
+		{"jsonrpc":"2.0","id":"list","method":"tools/list"},
+		{"jsonrpc":"2.0","method":"notifications/initialized"},
+		{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"ms_fake_echo","arguments":{}}},
+		{"jsonrpc":"2.0","id":8,"method":"bogus/method"},
+		{"jsonrpc":"2.0","id":9,"method":"tools/call","params":{}},
+		{"jsonrpc":"2.0","id":10,"method":"initialize","params":{}},
+		"garbage"
+	]`
+	resp := postMessage(t, server.URL, sessionID, body, "application/json, text/event-stream")
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("Expected batches to be answered with JSON, got %s", contentType)
+	}
+
+	var responses []struct {
+		ID     json.RawMessage `json:"id"`
+		Result interface{}     `json:"result"`
+		Error  struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&responses); err != nil {
+		t.Fatalf("Invalid batch response: %v", err)
+	}
+
+	var got []string
+	for _, response := range responses {
+		got = append(got, fmt.Sprintf("%s:%d", response.ID, response.Error.Code))
+	}
+	expected := []string{`"list":0`, "7:0", "8:-32601", "9:-32602", "10:-32600", "null:-32600"}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestProxyRejectsMalformedJSON(t *testing.T) {
+	server := newTestProxy(t)
+	sessionID := initializeSession(t, server.URL)
+
+	resp := postMessage(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":1,`, "application/json")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", resp.StatusCode)
+	}
+
+	var response pkg.MCPResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if response.ID != nil || errorCode(t, response) != CodeParseError {
+		t.Fatalf("Expected a parse error without id, got %+v", response)
+	}
+}
+
+func TestProxyAcceptsBatchesOfNotifications(t *testing.T) {
+	server := newTestProxy(t)
+	sessionID := initializeSession(t, server.URL)
+
+	body := `[{"jsonrpc":"2.0","method":"notifications/initialized"},{"jsonrpc":"2.0","id":"mcpshield-99","result":{}}]`
+	resp := postMessage(t, server.URL, sessionID, body, "application/json")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", resp.StatusCode)
+	}
+	if data, _ := io.ReadAll(resp.Body); len(data) != 0 {
+		t.Fatalf("Expected an empty body, got %q", data)
+	}
+}
+
+func TestProxyStandaloneStream(t *testing.T) {
+	server := newTestProxy(t)
+	sessionID := initializeSession(t, server.URL)
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotAcceptable {
+		t.Fatalf("Expected 406 without an SSE Accept header, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set(sessionIDHeader, sessionID)
+	stream, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer stream.Body.Close()
+
+	events := make(chan *sse.Event, 1)
+	go func() {
+		event, err := sse.NewReader(stream.Body).Next()
+		if err == nil {
+			events <- event
+		}
+		close(events)
+	}()
+
+	proxy := server.Config.Handler.(*Proxy)
+	session, _ := proxy.Sessions().Get(sessionID)
+	waitFor(t, func() bool {
+		session.mu.RLock()
+		defer session.mu.RUnlock()
+		return len(session.streams) == 1
+	})
+	proxy.notifyClients(&pkg.MCPRequest{JSONRPC: "2.0", Method: "notifications/tools/list_changed"})
+
+	event := <-events
+	if event == nil || !strings.Contains(event.Data, "notifications/tools/list_changed") {
+		t.Fatalf("Expected the notification on the stream, got %+v", event)
+	}
+
+	// DELETE terminates the session and ends its streams
+	req, _ = http.NewRequest(http.MethodDelete, server.URL, nil)
+	req.Header.Set(sessionIDHeader, sessionID)
+	deleted, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE failed: %v", err)
+	}
+	deleted.Body.Close()
+	if deleted.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", deleted.StatusCode)
+	}
+	if _, err := sse.NewReader(stream.Body).Next(); err == nil {
+		t.Fatal("Expected the stream to end with the session")
+	}
+
+	// The terminated session is gone for good
+	resp = postMessage(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`, "application/json")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected 404 for a terminated session, got %d", resp.StatusCode)
+	}
+}
+
+func TestProxySessions(t *testing.T) {
+	server := newTestProxy(t)
+	proxy := server.Config.Handler.(*Proxy)
+
+	resp := postMessage(t, server.URL, "", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, "application/json")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 without a session id, got %d", resp.StatusCode)
+	}
+
+	resp = postMessage(t, server.URL, "made-up", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, "application/json")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unknown session, got %d", resp.StatusCode)
+	}
+
+	sessionID := initializeSession(t, server.URL)
+	session, ok := proxy.Sessions().Get(sessionID)
+	if !ok {
+		t.Fatal("Expected the session to be registered")
+	}
+	if !session.IsInitialized() || session.ProtocolVersion != "2025-03-26" {
+		t.Fatalf("Unexpected session state: initialized=%v version=%q", session.IsInitialized(), session.ProtocolVersion)
+	}
+	if session.ClientInfo["name"] != "test" || session.ClientCapabilities["roots"] == nil {
+		t.Fatalf("Expected client info and capabilities, got %v %v", session.ClientInfo, session.ClientCapabilities)
+	}
+
+	// Middleware sees the session of every request
+	proxy.Use(func(next RequestHandler) RequestHandler {
+		return func(ctx context.Context, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+			if session, ok := SessionFromContext(ctx); ok {
+				session.Set("requests", 1)
+			}
+			return next(ctx, request)
+		}
+	})
+	postMessage(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, "application/json")
+	if value, _ := session.Get("requests"); value != 1 {
+		t.Fatal("Expected middleware to store state on the session")
+	}
+
+	// Idle sessions expire
+	if expired := proxy.Sessions().Expire(time.Now().Add(24 * time.Hour)); expired != 1 {
+		t.Fatalf("Expected one expired session, got %d", expired)
+	}
+	resp = postMessage(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, "application/json")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an expired session, got %d", resp.StatusCode)
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// openEventStream opens the session's standalone GET stream and returns its events
+func openEventStream(t *testing.T, server *httptest.Server, sessionID string) <-chan *sse.Event {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set(sessionIDHeader, sessionID)
+	stream, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	t.Cleanup(func() { stream.Body.Close() })
+
+	events := make(chan *sse.Event, 16)
+	go func() {
+		defer close(events)
+		reader := sse.NewReader(stream.Body)
+		for {
+			event, err := reader.Next()
+			if err != nil {
+				return
+			}
+			events <- event
+		}
+	}()
+
+	session, _ := proxyBehind(server).Sessions().lookup(sessionID)
+	waitFor(t, func() bool {
+		session.mu.RLock()
+		defer session.mu.RUnlock()
+		return len(session.streams) == 1
+	})
+	return events
+}
+
+// call posts a request on an initialized session and decodes the JSON answer
+func call(t *testing.T, url, sessionID, body string) *pkg.MCPResponse {
+	t.Helper()
+
+	resp := postMessage(t, url, sessionID, body, "application/json")
+	var response pkg.MCPResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if response.Error != nil {
+		t.Fatalf("Unexpected error for %s: %v", body, response.Error)
+	}
+	return &response
+}
+
+func TestProxyAdvertisesOnlyCapabilitiesItServes(t *testing.T) {
+	server := newTestProxy(t)
+
+	body := `{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{}}}`
+	response := call(t, server.URL, "", body)
+	capabilities := response.Result.(map[string]interface{})["capabilities"].(map[string]interface{})
+	resources, ok := capabilities["resources"].(map[string]interface{})
+	if !ok || len(resources) != 1 || resources["subscribe"] != true {
+		t.Fatalf("Expected resources with subscriptions only, got %v", capabilities["resources"])
+	}
+	prompts, ok := capabilities["prompts"].(map[string]interface{})
+	if !ok || len(prompts) != 0 {
+		t.Fatalf("Expected prompts without list change notifications, got %v", capabilities["prompts"])
+	}
+}
+
+func TestProxyOmitsPromptsWhenNoServerSupportsThem(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	mockRuntime := mocks.NewMockRuntime(ctrl)
+	mockRuntime.EXPECT().Start(gomock.Any()).Return(nil)
+	mockRuntime.EXPECT().IsReady().Return(true).AnyTimes()
+	mockRuntime.EXPECT().Exec(gomock.Any(), gomock.Any()).Return([]byte(`{"jsonrpc":"2.0","id":1,"result":{"protocolVersion":"2025-03-26","capabilities":{"tools":{}},"tools":[]}}`), nil).AnyTimes()
+	mockRuntime.EXPECT().Stop(gomock.Any()).Return(nil).AnyTimes()
+	mockRuntime.EXPECT().SetMessageHandler(gomock.Any()).AnyTimes()
+
+	mockFactory := mocks.NewMockRuntimeFactory(ctrl)
+	mockFactory.EXPECT().CreateRuntime(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(mockRuntime)
+
+	proxy, err := NewProxy(&pkg.Config{MCPServers: []pkg.MCPServerConfig{{Name: "plain", Image: "plain", Command: "plain"}}}, mockFactory)
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+	if err := proxy.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer proxy.Stop(context.Background())
+
+	response, err := proxy.ProcessInitialize(&pkg.MCPRequest{JSONRPC: "2.0", ID: 0, Method: "initialize"})
+	if err != nil {
+		t.Fatalf("Failed to initialize: %v", err)
+	}
+	capabilities := response.Result.(map[string]interface{})["capabilities"].(map[string]interface{})
+	if _, ok := capabilities["prompts"]; ok {
+		t.Fatalf("Expected no prompts capability, got %v", capabilities)
+	}
+	if _, ok := capabilities["resources"]; ok {
+		t.Fatalf("Expected no resources capability, got %v", capabilities)
+	}
+}
+
+func TestProxyDoesNotRetryNameCollisions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockFactory := mocks.NewMockRuntimeFactory(ctrl)
+	config := &pkg.Config{}
+	// Underscores in server names make ms_a_b_c ambiguous
+	for name, tool := range map[string]string{"a": "b_c", "a_b": "c"} {
+		upstream := &testUpstream{tools: []string{tool}}
+
+		mockRuntime := mocks.NewMockRuntime(ctrl)
+		mockRuntime.EXPECT().Start(gomock.Any()).Return(nil)
+		mockRuntime.EXPECT().IsReady().Return(true).AnyTimes()
+		mockRuntime.EXPECT().Exec(gomock.Any(), gomock.Any()).DoAndReturn(upstream.exec).AnyTimes()
+		mockRuntime.EXPECT().Stop(gomock.Any()).Return(nil).AnyTimes()
+		mockRuntime.EXPECT().SetMessageHandler(gomock.Any()).AnyTimes()
+
+		mockFactory.EXPECT().CreateRuntime(name, name, gomock.Any(), gomock.Any(), gomock.Any()).Return(mockRuntime)
+		config.MCPServers = append(config.MCPServers, pkg.MCPServerConfig{Name: name, Image: name, Command: "fake"})
+	}
+
+	proxy, err := NewProxy(config, mockFactory)
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+	defer proxy.Stop(context.Background())
+	err = proxy.Start(context.Background())
+	var collision *NameCollisionError
+	if !errors.As(err, &collision) {
+		t.Fatalf("Expected the collision to fail startup at once, got %v", err)
+	}
+}
+
+func TestProxyRefusesRemoteServersWithoutARemoteFactory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	config := &pkg.Config{MCPServers: []pkg.MCPServerConfig{{Name: "hosted", URL: "https://mcp.example.com/mcp"}}}
+
+	_, err := NewProxy(config, mocks.NewMockRuntimeFactory(ctrl))
+	if err == nil || !strings.Contains(err.Error(), "hosted") {
+		t.Fatalf("Expected the remote server to be refused, got %v", err)
+	}
+}
+
+func TestProxyListsAndRoutesPrompts(t *testing.T) {
+	server := newTestProxyWithServers(t, "alpha", "beta")
+	sessionID := initializeSession(t, server.URL)
+
+	result := call(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":1,"method":"prompts/list"}`).Result.(map[string]interface{})
+	var names []string
+	for _, prompt := range result["prompts"].([]interface{}) {
+		names = append(names, prompt.(map[string]interface{})["name"].(string))
+	}
+	sort.Strings(names)
+	if strings.Join(names, " ") != "ms_alpha_review ms_beta_review" {
+		t.Fatalf("Expected prefixed prompts from both servers, got %v", names)
+	}
+
+	body := `{"jsonrpc":"2.0","id":2,"method":"prompts/get","params":{"name":"ms_beta_review","arguments":{"file":"main.go"}}}`
+	messages := call(t, server.URL, sessionID, body).Result.(map[string]interface{})["messages"].([]interface{})
+	text := messages[0].(map[string]interface{})["content"].(map[string]interface{})["text"]
+	if text != "review main.go" {
+		t.Fatalf("Expected the original prompt name and arguments upstream, got %v", text)
+	}
+}
+
+func TestProxyPaginatesResourcesAcrossServers(t *testing.T) {
+	server := newTestProxyWithServers(t, "beta", "alpha")
+	sessionID := initializeSession(t, server.URL)
+
+	var uris []string
+	cursor := ""
+	for page := 0; page < 10; page++ {
+		body := `{"jsonrpc":"2.0","id":1,"method":"resources/list"}`
+		if cursor != "" {
+			body = `{"jsonrpc":"2.0","id":1,"method":"resources/list","params":{"cursor":"` + cursor + `"}}`
+		}
+		result := call(t, server.URL, sessionID, body).Result.(map[string]interface{})
+		for _, resource := range result["resources"].([]interface{}) {
+			uris = append(uris, resource.(map[string]interface{})["uri"].(string))
+		}
+
+		next, _ := result["nextCursor"].(string)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	expected := []string{"ms://alpha/file:///a", "ms://alpha/file:///b", "ms://beta/file:///a", "ms://beta/file:///b"}
+	if strings.Join(uris, " ") != strings.Join(expected, " ") {
+		t.Fatalf("Expected %v, got %v", expected, uris)
+	}
+}
+
+func TestProxyRejectsInvalidResourceCursor(t *testing.T) {
+	server := newTestProxy(t)
+	sessionID := initializeSession(t, server.URL)
+
+	for _, cursor := range []string{"not-base64!", encodeListCursor("missing", "")} {
+		body := `{"jsonrpc":"2.0","id":1,"method":"resources/list","params":{"cursor":"` + cursor + `"}}`
+		resp := postMessage(t, server.URL, sessionID, body, "application/json")
+		var response pkg.MCPResponse
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		if response.Error == nil {
+			t.Errorf("Expected cursor %q to be rejected", cursor)
+		}
+	}
+}
+
+func TestProxyNamespacesResourceTemplates(t *testing.T) {
+	server := newTestProxyWithServers(t, "alpha", "beta")
+	sessionID := initializeSession(t, server.URL)
+
+	result := call(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":1,"method":"resources/templates/list"}`).Result.(map[string]interface{})
+	templates := result["resourceTemplates"].([]interface{})
+	if len(templates) != 1 {
+		t.Fatalf("Expected one template per page, got %v", templates)
+	}
+	if uriTemplate := templates[0].(map[string]interface{})["uriTemplate"]; uriTemplate != "ms://alpha/file:///{path}" {
+		t.Fatalf("Expected a namespaced template, got %v", uriTemplate)
+	}
+}
+
+func TestProxyRoutesResourceReadsToOwningServer(t *testing.T) {
+	server := newTestProxyWithServers(t, "alpha", "beta")
+	sessionID := initializeSession(t, server.URL)
+
+	body := `{"jsonrpc":"2.0","id":"read-1","method":"resources/read","params":{"uri":"ms://beta/file:///notes/a.md"}}`
+	response := call(t, server.URL, sessionID, body)
+	if response.ID != "read-1" {
+		t.Fatalf("Expected the client id, got %v", response.ID)
+	}
+
+	contents := response.Result.(map[string]interface{})["contents"].([]interface{})
+	content := contents[0].(map[string]interface{})
+	if content["uri"] != "ms://beta/file:///notes/a.md" {
+		t.Fatalf("Expected the content uri in the proxy namespace, got %v", content["uri"])
+	}
+	if content["text"] != "contents of file:///notes/a.md" {
+		t.Fatalf("Expected the server to see its own uri, got %v", content["text"])
+	}
+
+	resp := postMessage(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":2,"method":"resources/read","params":{"uri":"file:///notes/a.md"}}`, "application/json")
+	var rejected pkg.MCPResponse
+	json.NewDecoder(resp.Body).Decode(&rejected)
+	if rejected.Error == nil {
+		t.Fatal("Expected a uri outside the proxy namespace to be rejected")
+	}
+}
+
+func TestProxyFansOutResourceUpdatesToSubscribers(t *testing.T) {
+	server, upstreams := newTestProxyWithUpstreams(t, "alpha")
+	upstream := upstreams["alpha"]
+
+	subscriber := initializeSession(t, server.URL)
+	other := initializeSession(t, server.URL)
+	subscriberEvents := openEventStream(t, server, subscriber)
+	otherEvents := openEventStream(t, server, other)
+
+	subscribe := `{"jsonrpc":"2.0","id":1,"method":"resources/subscribe","params":{"uri":"ms://alpha/file:///a"}}`
+	call(t, server.URL, subscriber, subscribe)
+	call(t, server.URL, other, subscribe)
+	if count := upstream.count("resources/subscribe"); count != 1 {
+		t.Fatalf("Expected the server to be subscribed once, got %d", count)
+	}
+
+	call(t, server.URL, other, `{"jsonrpc":"2.0","id":2,"method":"resources/unsubscribe","params":{"uri":"ms://alpha/file:///a"}}`)
+	if count := upstream.count("resources/unsubscribe"); count != 0 {
+		t.Fatalf("Expected the server to stay subscribed while a session is, got %d unsubscribes", count)
+	}
+
+	upstream.send(`{"jsonrpc":"2.0","method":"notifications/resources/updated","params":{"uri":"file:///a"}}`)
+	select {
+	case event := <-subscriberEvents:
+		var notification pkg.MCPRequest
+		json.Unmarshal([]byte(event.Data), &notification)
+		if uri := requestParams(&notification)["uri"]; uri != "ms://alpha/file:///a" {
+			t.Fatalf("Expected the uri in the proxy namespace, got %v", uri)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the update")
+	}
+	select {
+	case event := <-otherEvents:
+		t.Fatalf("Expected no update for an unsubscribed session, got %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Closing the last subscribed session unsubscribes the server
+	server.Config.Handler.(*Proxy).Sessions().Delete(subscriber)
+	if count := upstream.count("resources/unsubscribe"); count != 1 {
+		t.Fatalf("Expected the server to be unsubscribed once, got %d", count)
+	}
+}
+
+func TestProxySubscribersShareTheUpstreamResult(t *testing.T) {
+	server, upstreams := newTestProxyWithUpstreams(t, "alpha")
+	upstream := upstreams["alpha"]
+	upstream.subscribeResults = make(chan error)
+	proxy := server.Config.Handler.(*Proxy)
+
+	subscribe := `{"jsonrpc":"2.0","id":1,"method":"resources/subscribe","params":{"uri":"ms://alpha/file:///a"}}`
+	subscribeAll := func() []chan *pkg.MCPResponse {
+		var responses []chan *pkg.MCPResponse
+		for i := 0; i < 2; i++ {
+			sessionID := initializeSession(t, server.URL)
+			response := make(chan *pkg.MCPResponse, 1)
+			req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(subscribe))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", "application/json")
+			req.Header.Set(sessionIDHeader, sessionID)
+			go func() {
+				decoded := &pkg.MCPResponse{}
+				if resp, err := http.DefaultClient.Do(req); err == nil {
+					json.NewDecoder(resp.Body).Decode(decoded)
+					resp.Body.Close()
+				}
+				response <- decoded
+			}()
+			responses = append(responses, response)
+			waitFor(t, func() bool { return len(proxy.subscriptions.Subscribers("alpha", "file:///a")) == i+1 })
+		}
+		return responses
+	}
+
+	// The second subscriber is not answered before the server is
+	responses := subscribeAll()
+	select {
+	case response := <-responses[1]:
+		t.Fatalf("Expected the second subscriber to wait for the server, got %+v", response)
+	case <-time.After(50 * time.Millisecond):
+	}
+	upstream.subscribeResults <- errors.New("no such resource")
+	for _, response := range responses {
+		if code := errorCode(t, <-response); code != CodeInternalError {
+			t.Fatalf("Expected every subscriber to get the refusal, got %d", code)
+		}
+	}
+	if subscribers := proxy.subscriptions.Subscribers("alpha", "file:///a"); len(subscribers) != 0 {
+		t.Fatalf("Expected every subscription to be rolled back, got %v", subscribers)
+	}
+
+	// Once refused, the next subscriber asks the server again
+	responses = subscribeAll()
+	upstream.subscribeResults <- nil
+	for _, response := range responses {
+		if decoded := <-response; decoded.Error != nil {
+			t.Fatalf("Expected the subscription to be accepted, got %v", decoded.Error)
+		}
+	}
+	if count := upstream.count("resources/subscribe"); count != 2 {
+		t.Fatalf("Expected the server to be asked once per attempt, got %d", count)
+	}
+}
+
+func TestProxySubscriptionsOutliveTheRequestsThatStartedThem(t *testing.T) {
+	server, upstreams := newTestProxyWithUpstreams(t, "alpha")
+	upstream := upstreams["alpha"]
+	upstream.subscribeResults = make(chan error)
+	proxy := server.Config.Handler.(*Proxy)
+
+	// send posts body for the session in the background; cancelling ctx
+	// abandons it
+	send := func(ctx context.Context, sessionID, body string) chan *pkg.MCPResponse {
+		response := make(chan *pkg.MCPResponse, 1)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set(sessionIDHeader, sessionID)
+		go func() {
+			decoded := &pkg.MCPResponse{}
+			if resp, err := http.DefaultClient.Do(req); err == nil {
+				json.NewDecoder(resp.Body).Decode(decoded)
+				resp.Body.Close()
+			}
+			response <- decoded
+		}()
+		return response
+	}
+	subscribers := func(uri string) int { return len(proxy.subscriptions.Subscribers("alpha", uri)) }
+
+	// The first subscriber giving up does not fail the subscribe for the others
+	subscribeA := `{"jsonrpc":"2.0","id":1,"method":"resources/subscribe","params":{"uri":"ms://alpha/file:///a"}}`
+	ctx, cancel := context.WithCancel(context.Background())
+	send(ctx, initializeSession(t, server.URL), subscribeA)
+	waitFor(t, func() bool { return subscribers("file:///a") == 1 })
+	other := send(context.Background(), initializeSession(t, server.URL), subscribeA)
+	waitFor(t, func() bool { return subscribers("file:///a") == 2 })
+	cancel()
+	waitFor(t, func() bool { return subscribers("file:///a") == 1 })
+	upstream.subscribeResults <- nil
+	if response := <-other; response.Error != nil {
+		t.Fatalf("Expected the remaining subscriber to be accepted, got %v", response.Error)
+	}
+
+	// An unsubscribe overtaking the subscribe is sent once the server answered it
+	sessionID := initializeSession(t, server.URL)
+	send(context.Background(), sessionID, `{"jsonrpc":"2.0","id":1,"method":"resources/subscribe","params":{"uri":"ms://alpha/file:///b"}}`)
+	waitFor(t, func() bool { return subscribers("file:///b") == 1 })
+	unsubscribed := send(context.Background(), sessionID, `{"jsonrpc":"2.0","id":2,"method":"resources/unsubscribe","params":{"uri":"ms://alpha/file:///b"}}`)
+	waitFor(t, func() bool { return subscribers("file:///b") == 0 })
+	if count := upstream.count("resources/unsubscribe"); count != 0 {
+		t.Fatalf("Expected no unsubscribe before the subscribe was answered, got %d", count)
+	}
+	upstream.subscribeResults <- nil
+	if response := <-unsubscribed; response.Error != nil {
+		t.Fatalf("Expected the unsubscribe to succeed, got %v", response.Error)
+	}
+	if count := upstream.count("resources/unsubscribe"); count != 1 {
+		t.Fatalf("Expected the server to be unsubscribed once, got %d", count)
+	}
+
+	// The last subscriber giving up unsubscribes the server once it answered
+	ctx, cancel = context.WithCancel(context.Background())
+	send(ctx, initializeSession(t, server.URL), `{"jsonrpc":"2.0","id":1,"method":"resources/subscribe","params":{"uri":"ms://alpha/file:///c"}}`)
+	waitFor(t, func() bool { return subscribers("file:///c") == 1 })
+	cancel()
+	waitFor(t, func() bool { return subscribers("file:///c") == 0 })
+	upstream.subscribeResults <- nil
+	waitFor(t, func() bool { return upstream.count("resources/unsubscribe") == 2 })
+}
+
+func TestProxyRefreshesToolsWhenServerListChanges(t *testing.T) {
+	server, upstreams := newTestProxyWithUpstreams(t, "alpha", "beta")
+	sessionID := initializeSession(t, server.URL)
+	events := openEventStream(t, server, sessionID)
+
+	upstreams["alpha"].setTools("search")
+	upstreams["alpha"].send(`{"jsonrpc":"2.0","method":"notifications/tools/list_changed"}`)
+
+	select {
+	case event := <-events:
+		if !strings.Contains(event.Data, "notifications/tools/list_changed") {
+			t.Fatalf("Expected tools/list_changed, got %s", event.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for tools/list_changed")
+	}
+
+	result := call(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`).Result.(map[string]interface{})
+	var names []string
+	for _, tool := range result["tools"].([]interface{}) {
+		names = append(names, tool.(map[string]interface{})["name"].(string))
+	}
+	sort.Strings(names)
+	if strings.Join(names, " ") != "ms_alpha_search ms_beta_echo" {
+		t.Fatalf("Expected the removed tool to be dropped, got %v", names)
+	}
+
+	// An announcement without an actual change is not passed on
+	upstreams["beta"].send(`{"jsonrpc":"2.0","method":"notifications/tools/list_changed"}`)
+	select {
+	case event := <-events:
+		t.Fatalf("Expected no notification for an unchanged list, got %s", event.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// startWaitingCall posts a tools/call that runs until cancelled and waits for
+// it to reach the server
+func startWaitingCall(t *testing.T, ctx context.Context, url, sessionID string, upstream *testUpstream) <-chan *http.Response {
+	t.Helper()
+
+	body := `{"jsonrpc":"2.0","id":"call-1","method":"tools/call","params":{"name":"ms_fake_echo","arguments":{"wait":true}}}`
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	req.Header.Set(sessionIDHeader, sessionID)
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			close(responses)
+			return
+		}
+		responses <- resp
+	}()
+
+	waitFor(t, func() bool { return upstream.count("tools/call") == 1 })
+	return responses
+}
+
+func TestProxyCancelsRequestsOnNotification(t *testing.T) {
+	server, upstreams := newTestProxyWithUpstreams(t, "fake")
+	sessionID := initializeSession(t, server.URL)
+
+	responses := startWaitingCall(t, context.Background(), server.URL, sessionID, upstreams["fake"])
+
+	resp := postMessage(t, server.URL, sessionID, `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":"call-1","reason":"user aborted"}}`, "application/json")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", resp.StatusCode)
+	}
+	waitFor(t, func() bool { return upstreams["fake"].cancelledCalls() == 1 })
+
+	// The stream ends without answering the cancelled request
+	streamed := <-responses
+	if streamed == nil {
+		t.Fatal("Expected the call to be answered with a stream")
+	}
+	defer streamed.Body.Close()
+	if event, err := sse.NewReader(streamed.Body).Next(); err == nil {
+		t.Fatalf("Expected no response to a cancelled request, got %+v", event)
+	}
+}
+
+func TestProxyCancelsRequestsWhenClientDisconnects(t *testing.T) {
+	server, upstreams := newTestProxyWithUpstreams(t, "fake")
+	sessionID := initializeSession(t, server.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	startWaitingCall(t, ctx, server.URL, sessionID, upstreams["fake"])
+	cancel()
+
+	waitFor(t, func() bool { return upstreams["fake"].cancelledCalls() == 1 })
+}
+
+func TestProxyRelaysProgressOnTheRequestStream(t *testing.T) {
+	server, upstreams := newTestProxyWithUpstreams(t, "fake")
+	sessionID := initializeSession(t, server.URL)
+
+	body := `{"jsonrpc":"2.0","id":"call-1","method":"tools/call","params":{"name":"ms_fake_echo","arguments":{"progress":true},"_meta":{"progressToken":"client-token"}}}`
+	resp := postMessage(t, server.URL, sessionID, body, "application/json, text/event-stream")
+	reader := sse.NewReader(resp.Body)
+
+	event, err := reader.Next()
+	if err != nil {
+		t.Fatalf("Expected a progress event: %v", err)
+	}
+	var notification pkg.MCPRequest
+	json.Unmarshal([]byte(event.Data), &notification)
+	if notification.Method != "notifications/progress" || requestParams(&notification)["progressToken"] != "client-token" {
+		t.Fatalf("Expected progress with the client token, got %s", event.Data)
+	}
+
+	event, err = reader.Next()
+	if err != nil || !strings.Contains(event.Data, `"id":"call-1"`) {
+		t.Fatalf("Expected the response after the progress, got %+v (%v)", event, err)
+	}
+
+	upstreams["fake"].mu.Lock()
+	defer upstreams["fake"].mu.Unlock()
+	if len(upstreams["fake"].progressTokens) != 1 || upstreams["fake"].progressTokens[0] == "client-token" {
+		t.Fatalf("Expected the server to see a rewritten token, got %v", upstreams["fake"].progressTokens)
+	}
+}
+
+func TestProxyRelaysProgressOnTheStandaloneStream(t *testing.T) {
+	server, upstreams := newTestProxyWithUpstreams(t, "fake")
+	first := initializeSession(t, server.URL)
+	second := initializeSession(t, server.URL)
+	firstEvents := openEventStream(t, server, first)
+	secondEvents := openEventStream(t, server, second)
+
+	// Both clients use the same token; each must only get its own progress
+	body := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"ms_fake_echo","arguments":{"progress":true},"_meta":{"progressToken":1}}}`
+	call(t, server.URL, first, body)
+
+	select {
+	case event := <-firstEvents:
+		if !strings.Contains(event.Data, "notifications/progress") {
+			t.Fatalf("Expected progress, got %s", event.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for progress")
+	}
+	select {
+	case event := <-secondEvents:
+		t.Fatalf("Expected no progress for the other session, got %s", event.Data)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	call(t, server.URL, second, body)
+	upstreams["fake"].mu.Lock()
+	defer upstreams["fake"].mu.Unlock()
+	if tokens := upstreams["fake"].progressTokens; len(tokens) != 2 || tokens[0] == tokens[1] {
+		t.Fatalf("Expected distinct upstream tokens, got %v", tokens)
+	}
+}
+
+// recordingSender collects the messages sent to it
+type recordingSender struct {
+	messages []interface{}
+}
+
+func (r *recordingSender) Send(message interface{}) error {
+	r.messages = append(r.messages, message)
+	return nil
+}
+
+func TestProgressRouterOnlyHonoursTheServerATokenWentTo(t *testing.T) {
+	router := NewProgressRouter()
+	session := NewSessionRegistry(time.Minute).Create()
+	stream := &recordingSender{}
+	token, release := router.Register("alpha", session, stream, "client-token")
+	defer release()
+
+	progress := &pkg.MCPRequest{JSONRPC: "2.0", Method: "notifications/progress", Params: map[string]interface{}{"progressToken": token, "progress": 1}}
+	if router.Route("beta", progress) {
+		t.Fatal("Expected progress from another server to be dropped")
+	}
+	if !router.Route("alpha", progress) {
+		t.Fatal("Expected progress from the server the token went to")
+	}
+	if len(stream.messages) != 1 {
+		t.Fatalf("Expected only alpha's progress on the stream, got %d messages", len(stream.messages))
+	}
+}
+
+// answerClientRequests plays the client for a tools/call streamed over SSE:
+// every server-initiated request is answered by answer, and the final
+// response's text content is returned along with the methods seen
+func answerClientRequests(t *testing.T, url, sessionID string, answer func(method string) string) (string, []string) {
+	t.Helper()
+
+	body := `{"jsonrpc":"2.0","id":"call-1","method":"tools/call","params":{"name":"ms_fake_echo","arguments":{"sample":true}}}`
+	resp := postMessage(t, url, sessionID, body, "application/json, text/event-stream")
+	reader := sse.NewReader(resp.Body)
+
+	var methods []string
+	for {
+		event, err := reader.Next()
+		if err != nil {
+			t.Fatalf("Stream ended early: %v", err)
+		}
+
+		var message struct {
+			ID     interface{} `json:"id"`
+			Method string      `json:"method"`
+			Result struct {
+				Content []struct {
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"result"`
+		}
+		json.Unmarshal([]byte(event.Data), &message)
+		if message.Method == "" {
+			return message.Result.Content[0].Text, methods
+		}
+
+		methods = append(methods, message.Method)
+		id, _ := json.Marshal(message.ID)
+		reply := fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":%s}`, id, answer(message.Method))
+		if resp := postMessage(t, url, sessionID, reply, "application/json"); resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("Expected 202 for a client response, got %d", resp.StatusCode)
+		}
+	}
+}
+
+func sampledMessage(method string) string {
+	if method == "elicitation/create" {
+		return `{"action":"accept","content":{}}`
+	}
+	return `{"role":"assistant","content":{"type":"text","text":"sampled"},"model":"test"}`
+}
+
+func TestProxyForwardsSamplingToTheCallingClient(t *testing.T) {
+	server := newTestProxy(t)
+	sessionID := initializeSession(t, server.URL)
+
+	text, methods := answerClientRequests(t, server.URL, sessionID, sampledMessage)
+	if text != "sampled" {
+		t.Fatalf("Expected the client's sampled message to reach the server, got %q", text)
+	}
+	if strings.Join(methods, " ") != "sampling/createMessage" {
+		t.Fatalf("Unexpected client requests %v", methods)
+	}
+}
+
+func TestProxyBlocksSamplingByPolicy(t *testing.T) {
+	server, _ := newTestProxyWithConfig(t, func(config *pkg.MCPServerConfig) {
+		config.Sampling = pkg.SamplingBlock
+	}, "fake")
+	sessionID := initializeSession(t, server.URL)
+
+	text, methods := answerClientRequests(t, server.URL, sessionID, sampledMessage)
+	if len(methods) != 0 {
+		t.Fatalf("Expected nothing forwarded to the client, got %v", methods)
+	}
+	if !strings.Contains(text, "blocked") {
+		t.Fatalf("Expected the server to be told sampling is blocked, got %q", text)
+	}
+}
+
+func TestProxyAsksForSamplingApproval(t *testing.T) {
+	server, _ := newTestProxyWithConfig(t, func(config *pkg.MCPServerConfig) {
+		config.Sampling = pkg.SamplingApprove
+	}, "fake")
+	sessionID := initializeSession(t, server.URL)
+
+	text, methods := answerClientRequests(t, server.URL, sessionID, sampledMessage)
+	if text != "sampled" || strings.Join(methods, " ") != "elicitation/create sampling/createMessage" {
+		t.Fatalf("Expected approval then sampling, got %q after %v", text, methods)
+	}
+
+	text, methods = answerClientRequests(t, server.URL, sessionID, func(method string) string {
+		return `{"action":"decline"}`
+	})
+	if strings.Join(methods, " ") != "elicitation/create" || !strings.Contains(text, "not approved") {
+		t.Fatalf("Expected a declined approval to stop sampling, got %q after %v", text, methods)
+	}
+}
+
+func TestProxyRefusesServerRequestsWithSeveralSessionsInFlight(t *testing.T) {
+	server, upstreams := newTestProxyWithUpstreams(t, "fake")
+	other := initializeSession(t, server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startWaitingCall(t, ctx, server.URL, other, upstreams["fake"])
+
+	sessionID := initializeSession(t, server.URL)
+	text, methods := answerClientRequests(t, server.URL, sessionID, sampledMessage)
+	if len(methods) != 0 || !strings.Contains(text, "several sessions") {
+		t.Fatalf("Expected the sampling request to be refused, got %q after %v", text, methods)
+	}
+}
+
+func TestProxyRoutesServerRequestsToTheRequestTheyCameWith(t *testing.T) {
+	server, upstreams := newTestProxyWithUpstreams(t, "fake")
+	upstreams["fake"].perRequest = true
+	other := initializeSession(t, server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startWaitingCall(t, ctx, server.URL, other, upstreams["fake"])
+
+	sessionID := initializeSession(t, server.URL)
+	text, methods := answerClientRequests(t, server.URL, sessionID, sampledMessage)
+	if text != "sampled" || strings.Join(methods, " ") != "sampling/createMessage" {
+		t.Fatalf("Expected sampling from the calling session, got %q after %v", text, methods)
+	}
+}
+
+func TestProxyNegotiatesProtocolVersions(t *testing.T) {
+	server := newTestProxy(t)
+
+	for requested, expected := range map[string]string{
+		"2024-11-05": "2024-11-05",
+		"2025-06-18": "2025-06-18",
+		"1999-01-01": LatestProtocolVersion,
+	} {
+		body := `{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":"` + requested + `","capabilities":{}}}`
+		response := call(t, server.URL, "", body)
+		if version := response.Result.(map[string]interface{})["protocolVersion"]; version != expected {
+			t.Errorf("Requested %s: expected %s, got %v", requested, expected, version)
+		}
+	}
+
+	report := server.Config.Handler.(*Proxy).ProtocolVersions()
+	if report.Servers["fake"] != "2025-03-26" || report.Sessions["2024-11-05"] != 1 || report.Sessions[LatestProtocolVersion] != 2 {
+		t.Fatalf("Unexpected version report %+v", report)
+	}
+}
+
+func TestProxyChecksTheProtocolVersionHeader(t *testing.T) {
+	server := newTestProxy(t)
+	sessionID := initializeSessionWithVersion(t, server.URL, "2025-06-18")
+
+	for header, expected := range map[string]int{"2025-06-18": http.StatusOK, "2025-03-26": http.StatusBadRequest} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+		req.Header.Set(sessionIDHeader, sessionID)
+		req.Header.Set(protocolVersionHeader, header)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("Header %s: expected %d, got %d", header, expected, resp.StatusCode)
+		}
+	}
+
+	// Batching was removed in 2025-06-18
+	resp := postMessage(t, server.URL, sessionID, `[{"jsonrpc":"2.0","id":1,"method":"tools/list"}]`, "application/json")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected batches to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestProxyAnswersPingLocally(t *testing.T) {
+	server, upstreams := newTestProxyWithUpstreams(t, "alpha")
+	sessionID := initializeSession(t, server.URL)
+
+	response := call(t, server.URL, sessionID, `{"jsonrpc":"2.0","id":"p","method":"ping"}`)
+	if response.ID != "p" || len(response.Result.(map[string]interface{})) != 0 {
+		t.Fatalf("Expected an empty result, got %+v", response)
+	}
+	if count := upstreams["alpha"].count("ping"); count != 0 {
+		t.Fatalf("Expected ping not to reach the server, got %d", count)
+	}
+}
+
+func TestProxySetsLogLevelsAndRelaysMessages(t *testing.T) {
+	server, upstreams := newTestProxyWithUpstreams(t, "alpha", "beta")
+	verbose := initializeSession(t, server.URL)
+	quiet := initializeSession(t, server.URL)
+	verboseEvents := openEventStream(t, server, verbose)
+	quietEvents := openEventStream(t, server, quiet)
+
+	call(t, server.URL, verbose, `{"jsonrpc":"2.0","id":1,"method":"logging/setLevel","params":{"level":"debug"}}`)
+	call(t, server.URL, quiet, `{"jsonrpc":"2.0","id":1,"method":"logging/setLevel","params":{"level":"error"}}`)
+	for name, upstream := range upstreams {
+		if count := upstream.count("logging/setLevel"); count != 1 {
+			t.Fatalf("Expected %s to be set to the most verbose level once, got %d", name, count)
+		}
+	}
+
+	resp := postMessage(t, server.URL, quiet, `{"jsonrpc":"2.0","id":2,"method":"logging/setLevel","params":{"level":"loud"}}`, "application/json")
+	var response pkg.MCPResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	if errorCode(t, response) != CodeInvalidParams {
+		t.Fatalf("Expected an unknown level to be rejected, got %+v", response.Error)
+	}
+
+	upstreams["alpha"].send(`{"jsonrpc":"2.0","method":"notifications/message","params":{"level":"info","logger":"db","data":"connected"}}`)
+	upstreams["beta"].send(`{"jsonrpc":"2.0","method":"notifications/message","params":{"level":"error","data":"failed"}}`)
+
+	expectLogger := func(events <-chan *sse.Event, expected string) {
+		t.Helper()
+		select {
+		case event := <-events:
+			var notification pkg.MCPRequest
+			json.Unmarshal([]byte(event.Data), &notification)
+			if logger := requestParams(&notification)["logger"]; logger != expected {
+				t.Fatalf("Expected a message from %s, got %v", expected, logger)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for a message from %s", expected)
+		}
+	}
+	expectLogger(verboseEvents, "alpha/db")
+	expectLogger(verboseEvents, "beta")
+	// The info message is below the quiet session's level
+	expectLogger(quietEvents, "beta")
+}
+
+func TestProxyRoutesCompletions(t *testing.T) {
+	server, upstreams := newTestProxyWithUpstreams(t, "alpha", "beta")
+	sessionID := initializeSession(t, server.URL)
+
+	complete := func(ref string) *pkg.MCPResponse {
+		body := `{"jsonrpc":"2.0","id":1,"method":"completion/complete","params":{"ref":` + ref + `,"argument":{"name":"file","value":"RE"}}}`
+		resp := postMessage(t, server.URL, sessionID, body, "application/json")
+		var response pkg.MCPResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		return &response
+	}
+	values := func(response *pkg.MCPResponse) interface{} {
+		result, _ := response.Result.(map[string]interface{})
+		completion, _ := result["completion"].(map[string]interface{})
+		return completion["values"]
+	}
+
+	if got := values(complete(`{"type":"ref/prompt","name":"ms_beta_review"}`)); fmt.Sprint(got) != "[review]" {
+		t.Fatalf("Expected the original prompt name upstream, got %v", got)
+	}
+	if got := values(complete(`{"type":"ref/resource","uri":"ms://alpha/file:///{path}"}`)); fmt.Sprint(got) != "[file:///{path}]" {
+		t.Fatalf("Expected the original template upstream, got %v", got)
+	}
+	if upstreams["alpha"].count("completion/complete") != 1 || upstreams["beta"].count("completion/complete") != 1 {
+		t.Fatal("Expected each completion to reach the owning server only")
+	}
+
+	if response := complete(`{"type":"ref/prompt","name":"ms_gamma_review"}`); errorCode(t, response) != CodeInvalidParams {
+		t.Fatalf("Expected an unknown prompt to be rejected, got %+v", response.Error)
+	}
+}
@@ -0,0 +1,225 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+// resourceURIPrefix namespaces resource URIs per server, like the ms_ tool
+// prefix: file:///a on server github becomes ms://github/file:///a
+const resourceURIPrefix = "ms://"
+
+func namespacedURI(serverName, uri string) string {
+	return fmt.Sprintf("%s%s/%s", resourceURIPrefix, serverName, uri)
+}
+
+// parseNamespacedURI splits a proxy URI into the owning server and its original URI
+func parseNamespacedURI(uri string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(uri, resourceURIPrefix)
+	if !ok {
+		return "", "", false
+	}
+
+	serverName, original, ok := strings.Cut(rest, "/")
+	if !ok || serverName == "" || original == "" {
+		return "", "", false
+	}
+	return serverName, original, true
+}
+
+// rewriteURIField namespaces the URI stored under key, if any
+func rewriteURIField(key string) func(serverName string, item map[string]interface{}) {
+	return func(serverName string, item map[string]interface{}) {
+		if uri, ok := item[key].(string); ok {
+			item[key] = namespacedURI(serverName, uri)
+		}
+	}
+}
+
+func (p *Proxy) ProcessResourcesList(request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+	return p.processListPage(request, "resources", "resources/list", "resources", rewriteURIField("uri"))
+}
+
+func (p *Proxy) ProcessResourceTemplatesList(request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+	return p.processListPage(request, "resources", "resources/templates/list", "resourceTemplates", rewriteURIField("uriTemplate"))
+}
+
+func (p *Proxy) ProcessResourcesRead(ctx context.Context, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+	uri, _ := requestParams(request)["uri"].(string)
+	serverName, originalURI, ok := parseNamespacedURI(uri)
+	if !ok {
+		return nil, newRPCError(CodeResourceNotFound, "unknown resource: %s", uri)
+	}
+
+	return p.servers.ReadResource(ctx, serverName, originalURI, request)
+}
+
+// ProcessResourcesSubscribe records the session's subscription and subscribes
+// the owning server when it is the first for the resource. Every subscriber
+// is answered once the server is, and shares its refusal.
+func (p *Proxy) ProcessResourcesSubscribe(ctx context.Context, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+	session, ok := SessionFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("resource subscriptions require a session")
+	}
+
+	uri, _ := requestParams(request)["uri"].(string)
+	serverName, originalURI, ok := parseNamespacedURI(uri)
+	if !ok {
+		return nil, newRPCError(CodeResourceNotFound, "unknown resource: %s", uri)
+	}
+	if _, ok := p.servers.byName[serverName]; !ok {
+		return nil, newRPCError(CodeResourceNotFound, "unknown resource server: %s", serverName)
+	}
+
+	upstream, first := p.subscriptions.Add(serverName, originalURI, session.ID)
+	if first {
+		// The subscribe is shared by every subscriber, so it must not be
+		// abandoned when the first one gives up waiting
+		go func() {
+			response, err := p.servers.CallWithURI(context.WithoutCancel(ctx), serverName, originalURI, request)
+			p.subscriptions.Settle(serverName, originalURI, upstream, response, err)
+		}()
+	}
+
+	response, err := upstream.Wait(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			if upstream, last := p.subscriptions.Remove(serverName, originalURI, session.ID); last {
+				go p.unsubscribeUpstream(subscriptionKey{serverName: serverName, uri: originalURI}, upstream)
+			}
+		}
+		return nil, err
+	}
+	if response.Error != nil {
+		return &pkg.MCPResponse{JSONRPC: "2.0", ID: request.ID, Error: response.Error}, nil
+	}
+	return emptyResult(request), nil
+}
+
+// ProcessResourcesUnsubscribe drops the session's subscription and
+// unsubscribes the owning server when it was the last for the resource. An
+// unsubscribe overtaking the subscribe is sent after it was answered.
+func (p *Proxy) ProcessResourcesUnsubscribe(ctx context.Context, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+	session, ok := SessionFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("resource subscriptions require a session")
+	}
+
+	uri, _ := requestParams(request)["uri"].(string)
+	serverName, originalURI, ok := parseNamespacedURI(uri)
+	if !ok {
+		return nil, newRPCError(CodeResourceNotFound, "unknown resource: %s", uri)
+	}
+
+	upstream, last := p.subscriptions.Remove(serverName, originalURI, session.ID)
+	if !last {
+		return emptyResult(request), nil
+	}
+	if _, err := upstream.Wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			go p.unsubscribeUpstream(subscriptionKey{serverName: serverName, uri: originalURI}, upstream)
+		}
+		return nil, err
+	}
+	if upstream.failed() {
+		return emptyResult(request), nil
+	}
+	return p.servers.CallWithURI(ctx, serverName, originalURI, request)
+}
+
+// forwardResourceUpdated delivers an upstream resources/updated notification
+// to the sessions subscribed to the resource, in the proxy namespace
+func (p *Proxy) forwardResourceUpdated(serverName string, notification *pkg.MCPRequest) {
+	params := map[string]interface{}{}
+	for key, value := range requestParams(notification) {
+		params[key] = value
+	}
+	uri, ok := params["uri"].(string)
+	if !ok {
+		return
+	}
+	params["uri"] = namespacedURI(serverName, uri)
+
+	message := &pkg.MCPRequest{
+		JSONRPC: "2.0",
+		Method:  notification.Method,
+		Params:  params,
+	}
+	for _, sessionID := range p.subscriptions.Subscribers(serverName, uri) {
+		if session, ok := p.sessions.lookup(sessionID); ok {
+			session.Send(message)
+		}
+	}
+}
+
+// dropSubscriptions unsubscribes upstream from the resources only a closed
+// session was watching. Subscribes still in flight are undone once answered,
+// without holding up the close.
+func (p *Proxy) dropSubscriptions(session *Session) {
+	for key, upstream := range p.subscriptions.RemoveSession(session.ID) {
+		select {
+		case <-upstream.done:
+			p.unsubscribeUpstream(key, upstream)
+		default:
+			go p.unsubscribeUpstream(key, upstream)
+		}
+	}
+}
+
+// unsubscribeUpstream unsubscribes the server from a resource nobody watches
+// any more, once the subscribe made for it was answered. A refused subscribe
+// leaves nothing to undo.
+func (p *Proxy) unsubscribeUpstream(key subscriptionKey, upstream *upstreamSubscription) {
+	<-upstream.done
+	if upstream.failed() {
+		return
+	}
+	server, ok := p.servers.byName[key.serverName]
+	if !ok || !server.IsReady() {
+		return
+	}
+
+	_, err := server.Call(&pkg.MCPRequest{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  "resources/unsubscribe",
+		Params:  map[string]interface{}{"uri": key.uri},
+	})
+	if err != nil {
+		log.Printf("Failed to unsubscribe from %s on server %s: %v", key.uri, key.serverName, err)
+	}
+}
+
+func emptyResult(request *pkg.MCPRequest) *pkg.MCPResponse {
+	return &pkg.MCPResponse{
+		JSONRPC: "2.0",
+		ID:      request.ID,
+		Result:  map[string]interface{}{},
+	}
+}
+
+// processListPage answers a paginated list method aggregated across servers
+func (p *Proxy) processListPage(request *pkg.MCPRequest, capability, method, resultKey string, rewrite func(string, map[string]interface{})) (*pkg.MCPResponse, error) {
+	cursor, _ := requestParams(request)["cursor"].(string)
+	items, nextCursor, err := p.servers.ListPage(capability, method, resultKey, cursor, rewrite)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{
+		resultKey: items,
+	}
+	if nextCursor != "" {
+		result["nextCursor"] = nextCursor
+	}
+	return &pkg.MCPResponse{
+		JSONRPC: "2.0",
+		ID:      request.ID,
+		Result:  result,
+	}, nil
+}
@@ -0,0 +1,129 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+// caller is a client request in flight to an upstream server. Requests the
+// server sends to the client meanwhile are forwarded to the caller's session.
+type caller struct {
+	ctx     context.Context
+	session *Session
+	stream  messageSender
+}
+
+type callerKey struct{}
+
+// withCaller records in ctx the client request a server message was sent for
+func withCaller(ctx context.Context, c *caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, c)
+}
+
+func callerFromContext(ctx context.Context) (*caller, bool) {
+	c, ok := ctx.Value(callerKey{}).(*caller)
+	return c, ok
+}
+
+// clientCapabilityFor maps the requests servers may send to the client to
+// the client capability they need
+var clientCapabilityFor = map[string]string{
+	"sampling/createMessage": "sampling",
+	"elicitation/create":     "elicitation",
+	"roots/list":             "roots",
+}
+
+// handleServerRequest answers a request an upstream server sent to the
+// client. It waits on the client, so it must not run on the server's read loop.
+func (p *Proxy) handleServerRequest(ctx context.Context, server *MCPServer, request *pkg.MCPRequest) {
+	response := p.routeServerRequest(ctx, server, request)
+	response.ID = request.ID
+	if err := server.Respond(response); err != nil {
+		log.Printf("Failed to answer %s from server %s: %v", request.Method, server.Name, err)
+	}
+}
+
+func (p *Proxy) routeServerRequest(ctx context.Context, server *MCPServer, request *pkg.MCPRequest) *pkg.MCPResponse {
+	if request.Method == "ping" {
+		return &pkg.MCPResponse{JSONRPC: "2.0", Result: map[string]interface{}{}}
+	}
+
+	capability, ok := clientCapabilityFor[request.Method]
+	if !ok {
+		return errorResponse(nil, CodeMethodNotFound, fmt.Sprintf("Method not found: %s", request.Method))
+	}
+
+	sampling := request.Method == "sampling/createMessage"
+	if sampling && server.SamplingPolicy == pkg.SamplingBlock {
+		return errorResponse(nil, CodeForbidden, fmt.Sprintf("Sampling is blocked for server %s", server.Name))
+	}
+
+	caller, err := server.callerFor(ctx, request.Method)
+	if err != nil {
+		return errorResponse(nil, CodeInternalError, err.Error())
+	}
+	if !caller.session.hasClientCapability(capability) {
+		return errorResponse(nil, CodeMethodNotFound, fmt.Sprintf("Client does not support %s", request.Method))
+	}
+
+	if sampling && server.SamplingPolicy == pkg.SamplingApprove && !p.approveSampling(caller, server) {
+		return errorResponse(nil, CodeForbidden, "Sampling request was not approved")
+	}
+
+	response, err := p.requestClient(caller, request.Method, request.Params)
+	if err != nil {
+		return errorResponse(nil, CodeInternalError, err.Error())
+	}
+	return response
+}
+
+// approveSampling asks the user through the client whether the server may
+// sample from their model
+func (p *Proxy) approveSampling(caller *caller, server *MCPServer) bool {
+	if !caller.session.hasClientCapability("elicitation") {
+		return false
+	}
+
+	response, err := p.requestClient(caller, "elicitation/create", map[string]interface{}{
+		"message": fmt.Sprintf("MCP server %s wants to generate a message with your model. Allow it?", server.Name),
+		"requestedSchema": map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		},
+	})
+	if err != nil || response.Error != nil {
+		return false
+	}
+
+	result, _ := response.Result.(map[string]interface{})
+	return result["action"] == "accept"
+}
+
+// requestClient sends a request to the caller's client, on the stream of the
+// request being served when possible, and waits for the answer
+func (p *Proxy) requestClient(caller *caller, method string, params interface{}) (*pkg.MCPResponse, error) {
+	id, replies, release := caller.session.expectReply()
+	defer release()
+
+	message := &pkg.MCPRequest{
+		JSONRPC: "2.0",
+		ID:      id,
+		Method:  method,
+		Params:  params,
+	}
+	if caller.stream == nil || caller.stream.Send(message) != nil {
+		if !caller.session.Send(message) {
+			return nil, fmt.Errorf("client has no open stream to receive %s", method)
+		}
+	}
+
+	select {
+	case response := <-replies:
+		return response, nil
+	case <-caller.ctx.Done():
+		return nil, fmt.Errorf("client request ended before %s was answered", method)
+	}
+}
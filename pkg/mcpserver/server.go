@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 
 	"github.com/nsxbet/mcpshield/pkg"
 )
@@ -11,30 +12,59 @@ import (
 
 
 type MCPServer struct {
-	Name         string            `yaml:"name"`
-	Image        string            `yaml:"image"`
-	Command      string            `yaml:"command"`
-	Args         []string          `yaml:"args"`
-	Env          map[string]string `yaml:"env,omitempty"`
-	runtime      pkg.Runtime       `yaml:"-"`
-	ctx          context.Context   `yaml:"-"`
-	cancel       context.CancelFunc `yaml:"-"`
-	toolRegistry *ToolRegistry     `yaml:"-"`
-	initRegistry *InitializationRegistry `yaml:"-"`
+	Name           string                  `yaml:"name"`
+	Image          string                  `yaml:"image"`
+	Command        string                  `yaml:"command"`
+	Args           []string                `yaml:"args"`
+	Env            map[string]string       `yaml:"env,omitempty"`
+	URL            string                  `yaml:"url,omitempty"`
+	Transport      string                  `yaml:"transport,omitempty"`
+	SamplingPolicy string                  `yaml:"sampling,omitempty"`
+	// Prefix replaces the server name in tool and prompt names
+	Prefix         string                  `yaml:"prefix,omitempty"`
+	// Aliases maps tool names to the fixed names clients see
+	Aliases        map[string]string       `yaml:"aliases,omitempty"`
+	naming         *Naming                 `yaml:"-"`
+	runtime        pkg.Runtime             `yaml:"-"`
+	ctx            context.Context         `yaml:"-"`
+	cancel         context.CancelFunc      `yaml:"-"`
+	toolRegistry   *ToolRegistry           `yaml:"-"`
+	promptRegistry *PromptRegistry         `yaml:"-"`
+	initRegistry   *InitializationRegistry `yaml:"-"`
+	refreshMu      sync.Mutex              `yaml:"-"`
+	callers        []*caller               `yaml:"-"`
+	callersMu      sync.Mutex              `yaml:"-"`
+	onMessage      ServerMessageHandler    `yaml:"-"`
 }
 
 func NewMCPServer(name, image, command string, args []string, env map[string]string, RuntimeFactory pkg.RuntimeFactory) *MCPServer {
-	runtime := RuntimeFactory.CreateRuntime(image, command, args, env)
+	runtime := RuntimeFactory.CreateRuntime(name, image, command, args, env)
 	
 	return &MCPServer{
-		Name:         name,
-		Image:        image,
-		Command:      command,
-		Args:         args,
-		Env:          env,
-		runtime:      runtime,
-		toolRegistry: NewToolRegistry(),
-		initRegistry: NewInitializationRegistry(),
+		Name:           name,
+		Image:          image,
+		Command:        command,
+		Args:           args,
+		Env:            env,
+		runtime:        runtime,
+		naming:         DefaultNaming(),
+		toolRegistry:   NewToolRegistry(),
+		promptRegistry: NewPromptRegistry(),
+		initRegistry:   NewInitializationRegistry(),
+	}
+}
+
+// NewRemoteMCPServer creates a server for an MCP endpoint hosted elsewhere
+func NewRemoteMCPServer(name, url, transport string, headers map[string]string, factory pkg.RemoteRuntimeFactory) *MCPServer {
+	return &MCPServer{
+		Name:           name,
+		URL:            url,
+		Transport:      transport,
+		runtime:        factory.CreateRemoteRuntime(url, transport, headers),
+		naming:         DefaultNaming(),
+		toolRegistry:   NewToolRegistry(),
+		promptRegistry: NewPromptRegistry(),
+		initRegistry:   NewInitializationRegistry(),
 	}
 }
 
@@ -65,6 +95,12 @@ func (m *MCPServer) Stop(ctx context.Context) {
 
 // Call executes an MCP call and returns the response
 func (m *MCPServer) Call(request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+	return m.CallContext(context.Background(), request)
+}
+
+// CallContext is Call on behalf of a client request: cancelling ctx abandons
+// the call and the runtime tells the server to stop working on it
+func (m *MCPServer) CallContext(ctx context.Context, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
 	if m.ctx == nil {
 		return nil, fmt.Errorf("server not started")
 	}
@@ -78,7 +114,24 @@ func (m *MCPServer) Call(request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 	
-	responseBytes, err := m.runtime.Exec(m.ctx, requestBytes)
+	// Requests the server sends back while working on this go to the caller
+	if session, ok := SessionFromContext(ctx); ok {
+		c, release := m.trackCaller(ctx, session)
+		defer release()
+		if m.onMessage != nil {
+			ctx = pkg.WithMessageHandler(ctx, func(message []byte) {
+				m.dispatchMessage(withCaller(context.Background(), c), message)
+			})
+		}
+	}
+	
+	// The call ends with either the client request or the server
+	callCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(m.ctx, cancel)
+	defer stop()
+	
+	responseBytes, err := m.runtime.Exec(callCtx, requestBytes)
 	if err != nil {
 		return nil, fmt.Errorf("runtime exec failed: %w", err)
 	}
@@ -91,6 +144,98 @@ func (m *MCPServer) Call(request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
 	return &response, nil
 }
 
+// Respond answers a request the server sent to the client
+func (m *MCPServer) Respond(response *pkg.MCPResponse) error {
+	if m.ctx == nil {
+		return fmt.Errorf("server not started")
+	}
+	
+	responseBytes, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("failed to marshal response: %w", err)
+	}
+	
+	if _, err := m.runtime.Exec(m.ctx, responseBytes); err != nil {
+		return fmt.Errorf("runtime exec failed: %w", err)
+	}
+	return nil
+}
+
+// trackCaller records a client request in flight to the server and returns
+// it with the function that forgets it
+func (m *MCPServer) trackCaller(ctx context.Context, session *Session) (*caller, func()) {
+	stream, _ := requestStreamFromContext(ctx)
+	c := &caller{ctx: ctx, session: session, stream: stream}
+	
+	m.callersMu.Lock()
+	defer m.callersMu.Unlock()
+	m.callers = append(m.callers, c)
+	
+	return c, func() {
+		m.callersMu.Lock()
+		defer m.callersMu.Unlock()
+		for i, tracked := range m.callers {
+			if tracked == c {
+				m.callers = append(m.callers[:i], m.callers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// callerFor returns the client request a server-initiated request serves:
+// the one whose stream it arrived on when the transport says, otherwise the
+// only session with calls in flight. With several, the request could belong
+// to any of them, so it is refused rather than risk reaching the wrong user.
+func (m *MCPServer) callerFor(ctx context.Context, method string) (*caller, error) {
+	if c, ok := callerFromContext(ctx); ok {
+		return c, nil
+	}
+	
+	m.callersMu.Lock()
+	defer m.callersMu.Unlock()
+	
+	if len(m.callers) == 0 {
+		return nil, fmt.Errorf("no client request in flight to forward %s to", method)
+	}
+	latest := m.callers[len(m.callers)-1]
+	for _, c := range m.callers {
+		if c.session != latest.session {
+			return nil, fmt.Errorf("requests from several sessions are in flight to server %s, cannot tell which one %s is for", m.Name, method)
+		}
+	}
+	return latest, nil
+}
+
+// clientCapabilities is what the proxy offers the server as its client
+func (m *MCPServer) clientCapabilities() map[string]interface{} {
+	capabilities := map[string]interface{}{
+		"roots":       map[string]interface{}{},
+		"elicitation": map[string]interface{}{},
+	}
+	if m.SamplingPolicy != pkg.SamplingBlock {
+		capabilities["sampling"] = map[string]interface{}{}
+	}
+	return capabilities
+}
+
+// Notify sends a JSON-RPC notification; no response is expected
+func (m *MCPServer) Notify(notification *pkg.MCPRequest) error {
+	if m.ctx == nil {
+		return fmt.Errorf("server not started")
+	}
+	
+	notificationBytes, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+	
+	if _, err := m.runtime.Exec(m.ctx, notificationBytes); err != nil {
+		return fmt.Errorf("runtime exec failed: %w", err)
+	}
+	return nil
+}
+
 func (m *MCPServer) IsReady() bool {
 	if m.ctx == nil || m.ctx.Err() != nil {
 		return false
@@ -98,9 +243,113 @@ func (m *MCPServer) IsReady() bool {
 	return m.runtime.IsReady()
 }
 
+// HasCapability reports whether the server advertised the capability on initialize
+func (m *MCPServer) HasCapability(capability string) bool {
+	_, ok := m.Capability(capability)
+	return ok
+}
+
+// Capability returns the options the server advertised for the capability on initialize
+func (m *MCPServer) Capability(capability string) (map[string]interface{}, bool) {
+	response := m.initRegistry.GetResponses()[m.Name]
+	if response == nil {
+		return nil, false
+	}
+	
+	result, ok := response.Result.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	
+	capabilities, ok := result["capabilities"].(map[string]interface{})
+	if !ok || capabilities[capability] == nil {
+		return nil, false
+	}
+	
+	options, _ := capabilities[capability].(map[string]interface{})
+	return options, true
+}
+
+// ProtocolVersion returns the protocol version negotiated with the server
+func (m *MCPServer) ProtocolVersion() string {
+	response := m.initRegistry.GetResponses()[m.Name]
+	if response == nil {
+		return ""
+	}
+	
+	result, _ := response.Result.(map[string]interface{})
+	version, _ := result["protocolVersion"].(string)
+	return version
+}
+
+// ServerMessageHandler receives the messages an upstream server sends on its
+// own initiative. ctx carries the client request the message was sent for,
+// when the transport ties it to one.
+type ServerMessageHandler func(ctx context.Context, server *MCPServer, message *pkg.MCPRequest)
+
+// OnMessage routes the server's own messages to handler; it must be called
+// before Start
+func (m *MCPServer) OnMessage(handler ServerMessageHandler) {
+	m.onMessage = handler
+	m.runtime.SetMessageHandler(func(message []byte) {
+		m.dispatchMessage(context.Background(), message)
+	})
+}
+
+// dispatchMessage hands a message from the server to the OnMessage handler
+func (m *MCPServer) dispatchMessage(ctx context.Context, message []byte) {
+	var request pkg.MCPRequest
+	if err := json.Unmarshal(message, &request); err != nil {
+		return
+	}
+	m.onMessage(ctx, m, &request)
+}
+
+// ListPage fetches one page of a paginated list method, returning the items
+// under resultKey and the server's next cursor
+func (m *MCPServer) ListPage(method, resultKey, cursor string) ([]interface{}, string, error) {
+	request := &pkg.MCPRequest{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  method,
+	}
+	if cursor != "" {
+		request.Params = map[string]interface{}{"cursor": cursor}
+	}
+	
+	response, err := m.Call(request)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to call %s on server %s: %w", method, m.Name, err)
+	}
+	if response.Error != nil {
+		return nil, "", fmt.Errorf("server %s failed %s: %v", m.Name, method, response.Error)
+	}
+	
+	result, ok := response.Result.(map[string]interface{})
+	if !ok {
+		return nil, "", fmt.Errorf("invalid response format from server %s", m.Name)
+	}
+	
+	items, _ := result[resultKey].([]interface{})
+	nextCursor, _ := result["nextCursor"].(string)
+	return items, nextCursor, nil
+}
+
+// UpdateToolRegistry rediscovers the server's tools, replacing the ones known
+// so far
 func (m *MCPServer) UpdateToolRegistry() error {
+	_, err := m.RefreshTools()
+	return err
+}
+
+// RefreshTools rediscovers the server's tools and reports whether the
+// registry changed
+func (m *MCPServer) RefreshTools() (bool, error) {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+	
 	if !m.IsReady() {
-		return fmt.Errorf("server %s is not ready", m.Name)
+		return false, fmt.Errorf("server %s is not ready", m.Name)
 	}
 	
 	response, err := m.Call(&pkg.MCPRequest{
@@ -109,23 +358,21 @@ func (m *MCPServer) UpdateToolRegistry() error {
 		Method:  "tools/list",
 	})
 	if err != nil {
-		return fmt.Errorf("failed to get tools from server %s: %w", m.Name, err)
+		return false, fmt.Errorf("failed to get tools from server %s: %w", m.Name, err)
 	}
 	
 	if response.Result == nil {
-		return fmt.Errorf("no response result from server %s", m.Name)
+		return false, fmt.Errorf("no response result from server %s", m.Name)
 	}
 	
 	result, ok := response.Result.(map[string]interface{})
 	if !ok {
-		return fmt.Errorf("invalid response format from server %s", m.Name)
+		return false, fmt.Errorf("invalid response format from server %s", m.Name)
 	}
 	
-	toolsInterface, ok := result["tools"].([]interface{})
-	if !ok {
-		return nil // No tools is ok
-	}
+	toolsInterface, _ := result["tools"].([]interface{}) // No tools is ok
 	
+	var tools []Tool
 	for _, tool := range toolsInterface {
 		toolMap, ok := tool.(map[string]interface{})
 		if !ok {
@@ -140,11 +387,76 @@ func (m *MCPServer) UpdateToolRegistry() error {
 		tool := Tool{
 			originalName: toolName,
 			serverName:   m.Name,
+			name:         m.toolName(toolName),
 			definition:   toolMap,
 		}
-		m.toolRegistry.UpdateTool(tool)
+		tools = append(tools, tool)
+	}
+	return m.toolRegistry.Replace(tools), nil
+}
+
+// SetNaming changes how tool and prompt names are built; it must be called
+// before the server lists them
+func (m *MCPServer) SetNaming(naming *Naming) {
+	m.naming = naming
+}
+
+func (m *MCPServer) prefix() string {
+	if m.Prefix == "" {
+		return m.Name
+	}
+	return m.Prefix
+}
+
+// toolName is the name clients call a tool by: its alias, or else the name
+// the naming template gives it
+func (m *MCPServer) toolName(originalName string) string {
+	if alias, ok := m.Aliases[originalName]; ok {
+		return alias
+	}
+	return m.naming.Name(m.prefix(), m.Name, originalName)
+}
+
+// UpdatePromptRegistry discovers the server's prompts, following every page
+func (m *MCPServer) UpdatePromptRegistry() error {
+	if !m.IsReady() {
+		return fmt.Errorf("server %s is not ready", m.Name)
+	}
+	if !m.HasCapability("prompts") {
+		return nil
+	}
+	
+	cursor := ""
+	for {
+		prompts, nextCursor, err := m.ListPage("prompts/list", "prompts", cursor)
+		if err != nil {
+			return err
+		}
+		
+		for _, prompt := range prompts {
+			promptMap, ok := prompt.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			
+			promptName, ok := promptMap["name"].(string)
+			if !ok {
+				continue
+			}
+			
+			m.promptRegistry.UpdatePrompt(Prompt{
+				originalName: promptName,
+				serverName:   m.Name,
+				name:         m.naming.Name(m.prefix(), m.Name, promptName),
+				definition:   promptMap,
+			})
+		}
+		
+		if nextCursor == "" {
+			return nil
+		}
+		cursor = nextCursor
 	}
-	return nil
 }
 
 func (m *MCPServer) UpdateInitializationRegistry() error {
@@ -157,8 +469,8 @@ func (m *MCPServer) UpdateInitializationRegistry() error {
 		ID:      1,
 		Method:  "initialize",
 		Params:  map[string]interface{}{
-			"protocolVersion": "2025-03-26",
-			"capabilities":    map[string]interface{}{},
+			"protocolVersion": LatestProtocolVersion,
+			"capabilities":    m.clientCapabilities(),
 			"clientInfo": map[string]interface{}{
 				"name":    "mcpshield-proxy",
 				"version": "1.0.0",
@@ -169,6 +481,18 @@ func (m *MCPServer) UpdateInitializationRegistry() error {
 		return fmt.Errorf("failed to get initialization from server %s: %w", m.Name, err)
 	}
 	
+	// Servers answer with the version they speak when it is not ours
+	result, _ := response.Result.(map[string]interface{})
+	version, _ := result["protocolVersion"].(string)
+	if !isSupportedProtocolVersion(version) {
+		return fmt.Errorf("server %s speaks unsupported protocol version %q", m.Name, version)
+	}
+	
 	m.initRegistry.UpdateInitialization(m.Name, response)
-	return nil
+	
+	// The session is persistent, so complete the handshake before any other request
+	return m.Notify(&pkg.MCPRequest{
+		JSONRPC: "2.0",
+		Method:  "notifications/initialized",
+	})
 }
\ No newline at end of file
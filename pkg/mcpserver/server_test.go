@@ -20,6 +20,7 @@ func TestMCPServerLifecycle(t *testing.T) {
 
 	mockFactory := mocks.NewMockRuntimeFactory(ctrl)
 	mockFactory.EXPECT().CreateRuntime(
+		"test-server",
 		"node:18-alpine",
 		"npx", 
 		[]string{"-y", "@modelcontextprotocol/server-github"},
@@ -2,16 +2,51 @@ package mcpserver
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"sort"
 
 	"github.com/nsxbet/mcpshield/pkg"
 )
 
-type MCPServers map[string]*MCPServer
+// MCPServers are the servers by name, with an index of the tool and prompt
+// names clients see
+type MCPServers struct {
+	byName map[string]*MCPServer
+	names  *nameIndex
+}
+
+func newMCPServers() MCPServers {
+	return MCPServers{byName: make(map[string]*MCPServer), names: &nameIndex{}}
+}
 
-func NewServers(config *pkg.Config, factory pkg.RuntimeFactory) MCPServers {
-	servers := make(MCPServers)
+// NewServers creates the configured servers; remote ones need a factory that
+// is also a RemoteRuntimeFactory
+func NewServers(config *pkg.Config, factory pkg.RuntimeFactory) (MCPServers, error) {
+	servers := newMCPServers()
+	naming := NewNaming(config)
+	remoteFactory, _ := factory.(pkg.RemoteRuntimeFactory)
 	for _, serverConfig := range config.GetMCPServers() {
+		if serverConfig.IsRemote() {
+			if remoteFactory == nil {
+				return MCPServers{}, fmt.Errorf("server %s is remote but the runtime factory cannot reach remote servers", serverConfig.Name)
+			}
+			server := NewRemoteMCPServer(
+				serverConfig.Name,
+				serverConfig.URL,
+				serverConfig.Transport,
+				serverConfig.Headers,
+				remoteFactory,
+			)
+			server.SamplingPolicy = serverConfig.GetSamplingPolicy()
+			server.Prefix = serverConfig.Prefix
+			server.Aliases = serverConfig.Aliases
+			server.SetNaming(naming)
+			servers.byName[serverConfig.Name] = server
+			continue
+		}
+		
 		server := NewMCPServer(
 			serverConfig.Name,
 			serverConfig.Image,
@@ -20,13 +55,17 @@ func NewServers(config *pkg.Config, factory pkg.RuntimeFactory) MCPServers {
 			serverConfig.Env,
 			factory,
 		)
-		servers[serverConfig.Name] = server
+		server.SamplingPolicy = serverConfig.GetSamplingPolicy()
+		server.Prefix = serverConfig.Prefix
+		server.Aliases = serverConfig.Aliases
+		server.SetNaming(naming)
+		servers.byName[serverConfig.Name] = server
 	}
-	return servers
+	return servers, nil
 }
 
 func (s MCPServers) StartAll(ctx context.Context) error {
-	for name, server := range s {
+	for name, server := range s.byName {
 		if err := server.Start(ctx); err != nil {
 			return fmt.Errorf("failed to start server %s: %w", name, err)
 		}
@@ -36,12 +75,21 @@ func (s MCPServers) StartAll(ctx context.Context) error {
 		}
 	}
 	
+	// Servers must be initialized before they answer anything else
+	if err := s.UpdateAllInitializationRegistries(); err != nil {
+		return fmt.Errorf("failed to update initialization registries: %w", err)
+	}
+
 	if err := s.UpdateAllToolRegistries(); err != nil {
 		return fmt.Errorf("failed to update tool registries: %w", err)
 	}
-
-	if err := s.UpdateAllInitializationRegistries(); err != nil {
-		return fmt.Errorf("failed to update initialization registries: %w", err)
+	
+	if err := s.UpdateAllPromptRegistries(); err != nil {
+		return fmt.Errorf("failed to update prompt registries: %w", err)
+	}
+	
+	if err := s.reindex(); err != nil {
+		return err
 	}
 		
 	fmt.Printf("🎉 All MCP servers started successfully\n")
@@ -52,39 +100,277 @@ func (s MCPServers) StartAll(ctx context.Context) error {
 }
 
 func (s MCPServers) StopAll(ctx context.Context) {
-	for _, server := range s {
+	for _, server := range s.byName {
 		server.Stop(ctx)
 	}
 }
 
 func (s MCPServers) AllTools() []interface{} {
 	var allTools []interface{}
-	for _, server := range s {
+	for _, server := range s.byName {
 		allTools = append(allTools, server.toolRegistry.ToList()...)
 	}
 	return allTools
 }
 
-func (s MCPServers) CallTool(toolName string, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
-	for _, server := range s {
-		tool, found := server.toolRegistry.FindByName(toolName)
-		if !found {
-			continue
+func (s MCPServers) CallTool(ctx context.Context, toolName string, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+	server, tool, found := s.findTool(toolName)
+	if !found {
+		return nil, newRPCError(CodeInvalidParams, "tool not found: %s", toolName)
+	}
+	if !server.IsReady() {
+		return nil, fmt.Errorf("server not ready: %s", server.Name)
+	}
+	
+	params := map[string]interface{}{}
+	for key, value := range requestParams(request) {
+		params[key] = value
+	}
+	params["name"] = tool.GetOriginalName()
+	return server.CallContext(ctx, &pkg.MCPRequest{
+		JSONRPC: request.JSONRPC,
+		ID:      request.ID,
+		Method:  request.Method,
+		Params:  params,
+	})
+}
+
+func (s MCPServers) AllPrompts() []interface{} {
+	allPrompts := []interface{}{}
+	for _, server := range s.byName {
+		allPrompts = append(allPrompts, server.promptRegistry.ToList()...)
+	}
+	return allPrompts
+}
+
+// GetPrompt forwards prompts/get to the owning server with the original
+// prompt name; arguments are passed through untouched
+func (s MCPServers) GetPrompt(ctx context.Context, promptName string, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+	server, prompt, found := s.findPrompt(promptName)
+	if !found {
+		return nil, newRPCError(CodeInvalidParams, "prompt not found: %s", promptName)
+	}
+	if !server.IsReady() {
+		return nil, fmt.Errorf("server not ready: %s", server.Name)
+	}
+	
+	params := map[string]interface{}{}
+	for key, value := range requestParams(request) {
+		params[key] = value
+	}
+	params["name"] = prompt.GetOriginalName()
+	return server.CallContext(ctx, &pkg.MCPRequest{
+		JSONRPC: request.JSONRPC,
+		ID:      request.ID,
+		Method:  request.Method,
+		Params:  params,
+	})
+}
+
+// ToolOwner returns the server offering the tool and the name the server
+// knows it by
+func (s MCPServers) ToolOwner(toolName string) (string, string, bool) {
+	server, tool, found := s.findTool(toolName)
+	if !found {
+		return "", "", false
+	}
+	return server.Name, tool.GetOriginalName(), true
+}
+
+// ToolsByServer returns the tools each server offers, by the names the
+// servers know them by
+func (s MCPServers) ToolsByServer() map[string][]string {
+	tools := make(map[string][]string, len(s.byName))
+	for _, server := range s.byName {
+		names := []string{}
+		for _, tool := range server.toolRegistry.Tools() {
+			names = append(names, tool.GetOriginalName())
+		}
+		sort.Strings(names)
+		tools[server.Name] = names
+	}
+	return tools
+}
+
+// PromptOwner returns the server offering the prompt and the name the server
+// knows it by
+func (s MCPServers) PromptOwner(promptName string) (string, string, bool) {
+	server, prompt, found := s.findPrompt(promptName)
+	if !found {
+		return "", "", false
+	}
+	return server.Name, prompt.GetOriginalName(), true
+}
+
+// Complete forwards completion/complete to the server with the reference the
+// server knows
+func (s MCPServers) Complete(ctx context.Context, serverName string, ref map[string]interface{}, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+	server, ok := s.byName[serverName]
+	if !ok {
+		return nil, newRPCError(CodeInvalidParams, "unknown server: %s", serverName)
+	}
+	if !server.IsReady() {
+		return nil, fmt.Errorf("server not ready: %s", server.Name)
+	}
+	
+	params := map[string]interface{}{}
+	for key, value := range requestParams(request) {
+		params[key] = value
+	}
+	params["ref"] = ref
+	
+	return server.CallContext(ctx, &pkg.MCPRequest{
+		JSONRPC: request.JSONRPC,
+		ID:      request.ID,
+		Method:  request.Method,
+		Params:  params,
+	})
+}
+
+// OnMessage routes the messages every server sends on its own initiative to
+// handler; it must be called before StartAll
+func (s MCPServers) OnMessage(handler ServerMessageHandler) {
+	for _, server := range s.byName {
+		server.OnMessage(handler)
+	}
+}
+
+// CallWithURI forwards a resource request to the owning server with the URI
+// the server knows the resource by
+func (s MCPServers) CallWithURI(ctx context.Context, serverName, uri string, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+	server, ok := s.byName[serverName]
+	if !ok {
+		return nil, newRPCError(CodeResourceNotFound, "unknown resource server: %s", serverName)
+	}
+	if !server.IsReady() {
+		return nil, fmt.Errorf("server not ready: %s", server.Name)
+	}
+	
+	params := map[string]interface{}{}
+	for key, value := range requestParams(request) {
+		params[key] = value
+	}
+	params["uri"] = uri
+	
+	return server.CallContext(ctx, &pkg.MCPRequest{
+		JSONRPC: request.JSONRPC,
+		ID:      request.ID,
+		Method:  request.Method,
+		Params:  params,
+	})
+}
+
+// ReadResource forwards resources/read to the owning server and namespaces
+// the URIs of the returned contents
+func (s MCPServers) ReadResource(ctx context.Context, serverName, uri string, request *pkg.MCPRequest) (*pkg.MCPResponse, error) {
+	response, err := s.CallWithURI(ctx, serverName, uri, request)
+	if err != nil {
+		return nil, err
+	}
+	
+	if result, ok := response.Result.(map[string]interface{}); ok {
+		contents, _ := result["contents"].([]interface{})
+		rewrite := rewriteURIField("uri")
+		for _, content := range contents {
+			if contentMap, ok := content.(map[string]interface{}); ok {
+				rewrite(serverName, contentMap)
+			}
+		}
+	}
+	return response, nil
+}
+
+// WithCapability returns the names of ready servers advertising the
+// capability, sorted so that pagination has a stable order
+func (s MCPServers) WithCapability(capability string) []string {
+	var names []string
+	for name, server := range s.byName {
+		if server.IsReady() && server.HasCapability(capability) {
+			names = append(names, name)
 		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// listCursor is the position of an aggregated list: the server being paged
+// and that server's own cursor
+type listCursor struct {
+	Server string `json:"s"`
+	Cursor string `json:"c,omitempty"`
+}
+
+func encodeListCursor(server, cursor string) string {
+	data, _ := json.Marshal(listCursor{Server: server, Cursor: cursor})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(cursor string) (*listCursor, error) {
+	position := &listCursor{}
+	if cursor == "" {
+		return position, nil
+	}
+	
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, newRPCError(CodeInvalidParams, "invalid cursor")
+	}
+	if err := json.Unmarshal(data, position); err != nil {
+		return nil, newRPCError(CodeInvalidParams, "invalid cursor")
+	}
+	return position, nil
+}
+
+// ListPage returns one page of a list method aggregated across the servers
+// with the capability. Servers are paged one after the other and the returned
+// cursor encodes which server and upstream cursor come next. rewrite
+// namespaces each item for the server it came from.
+func (s MCPServers) ListPage(capability, method, resultKey, cursor string, rewrite func(serverName string, item map[string]interface{})) ([]interface{}, string, error) {
+	position, err := decodeListCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	
+	names := s.WithCapability(capability)
+	start := 0
+	if position.Server != "" {
+		start = sort.SearchStrings(names, position.Server)
+		if start == len(names) || names[start] != position.Server {
+			return nil, "", newRPCError(CodeInvalidParams, "invalid cursor: server %s is not available", position.Server)
+		}
+	}
+	
+	upstreamCursor := position.Cursor
+	for i := start; i < len(names); i++ {
+		items, nextCursor, err := s.byName[names[i]].ListPage(method, resultKey, upstreamCursor)
+		if err != nil {
+			return nil, "", err
+		}
+		upstreamCursor = ""
 		
-		if !server.IsReady() {
-			return nil, fmt.Errorf("server not ready: %s", server.Name)
+		for _, item := range items {
+			if itemMap, ok := item.(map[string]interface{}); ok {
+				rewrite(names[i], itemMap)
+			}
 		}
 		
-		params := request.Params.(map[string]interface{})
-		params["name"] = tool.GetOriginalName()
-		return server.Call(request)
+		if nextCursor != "" {
+			return items, encodeListCursor(names[i], nextCursor), nil
+		}
+		// Skip servers with nothing to list instead of returning empty pages
+		if len(items) == 0 {
+			continue
+		}
+		if i+1 < len(names) {
+			return items, encodeListCursor(names[i+1], ""), nil
+		}
+		return items, "", nil
 	}
-	return nil, fmt.Errorf("tool not found: %s", toolName)
+	return []interface{}{}, "", nil
 }
 
 func (s MCPServers) UpdateAllToolRegistries() error {
-	for name, server := range s {
+	for name, server := range s.byName {
 		if err := server.UpdateToolRegistry(); err != nil {
 			return fmt.Errorf("failed to update tool registry for server %s: %w", name, err)
 		}
@@ -92,8 +378,17 @@ func (s MCPServers) UpdateAllToolRegistries() error {
 	return nil
 }
 
+func (s MCPServers) UpdateAllPromptRegistries() error {
+	for name, server := range s.byName {
+		if err := server.UpdatePromptRegistry(); err != nil {
+			return fmt.Errorf("failed to update prompt registry for server %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
 func (s MCPServers) UpdateAllInitializationRegistries() error {
-	for name, server := range s {
+	for name, server := range s.byName {
 		if err := server.UpdateInitializationRegistry(); err != nil {
 			return fmt.Errorf("failed to update initialization registry for server %s: %w", name, err)
 		}
@@ -103,7 +398,7 @@ func (s MCPServers) UpdateAllInitializationRegistries() error {
 
 func (s MCPServers) GetAllInitializationResponses() map[string]*pkg.MCPResponse {
 	allResponses := make(map[string]*pkg.MCPResponse)
-	for _, server := range s {
+	for _, server := range s.byName {
 		responses := server.initRegistry.GetResponses()
 		for serverName, response := range responses {
 			allResponses[serverName] = response
@@ -113,7 +408,8 @@ func (s MCPServers) GetAllInitializationResponses() map[string]*pkg.MCPResponse
 }
 
 func (s MCPServers) PrintAllTools() {
-	for _, server := range s {
+	for _, server := range s.byName {
 		server.toolRegistry.Print()
+		server.promptRegistry.Print()
 	}
 } 
\ No newline at end of file
@@ -0,0 +1,414 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nsxbet/mcpshield/pkg"
+	"github.com/nsxbet/mcpshield/pkg/auth"
+)
+
+// sessionStream carries server-initiated messages to a client: an SSE stream,
+// or stdout for the stdio transport
+type sessionStream interface {
+	messageSender
+	Close()
+}
+
+// Session is the state of one client connection negotiated on initialize
+type Session struct {
+	ID                 string
+	ProtocolVersion    string
+	ClientCapabilities map[string]interface{}
+	ClientInfo         map[string]interface{}
+	initialized        bool
+	lastSeen           time.Time
+	values             map[string]interface{}
+	streams            map[sessionStream]struct{}
+	inflight           map[string]*context.CancelFunc
+	replies            map[string]chan *pkg.MCPResponse
+	nextReplyID        int64
+	logLevel           string
+	// principal initialized the session, which is theirs alone; nil when
+	// requests are not authenticated
+	principal *auth.Principal
+	mu        sync.RWMutex
+}
+
+// Get returns a value stored on the session by middleware
+func (s *Session) Get(key string) (interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// Set stores a value on the session for later requests
+func (s *Session) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+}
+
+// IsInitialized reports whether the client sent notifications/initialized
+func (s *Session) IsInitialized() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.initialized
+}
+
+// negotiate records what the client and the proxy agreed on during initialize
+func (s *Session) negotiate(request *pkg.MCPRequest, response *pkg.MCPResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if params, ok := request.Params.(map[string]interface{}); ok {
+		s.ClientCapabilities, _ = params["capabilities"].(map[string]interface{})
+		s.ClientInfo, _ = params["clientInfo"].(map[string]interface{})
+	}
+	if result, ok := response.Result.(map[string]interface{}); ok {
+		s.ProtocolVersion, _ = result["protocolVersion"].(string)
+	}
+}
+
+// bindPrincipal makes the session belong to the principal that initialized it
+func (s *Session) bindPrincipal(principal *auth.Principal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.principal = principal
+}
+
+// belongsTo reports whether the principal is the one the session was
+// initialized by. Tokens are refreshed during a session, so the user is
+// compared rather than the claims of one token.
+func (s *Session) belongsTo(principal *auth.Principal) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.principal == nil || principal == nil {
+		return s.principal == nil && principal == nil
+	}
+	return s.principal.Username == principal.Username && s.principal.UID == principal.UID
+}
+
+// owner returns the principal the session belongs to, nil when requests are
+// not authenticated
+func (s *Session) owner() *auth.Principal {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.principal
+}
+
+func (s *Session) protocolVersion() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ProtocolVersion
+}
+
+// setLogLevel records the level the client asked logging/setLevel for
+func (s *Session) setLogLevel(level string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logLevel = level
+}
+
+// LogLevel returns the client's log level, empty until it sets one
+func (s *Session) LogLevel() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.logLevel
+}
+
+// hasClientCapability reports whether the client declared the capability on initialize
+func (s *Session) hasClientCapability(capability string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ClientCapabilities[capability] != nil
+}
+
+// expectReply reserves an id for a request to the client and returns the
+// channel its answer arrives on, and the function that stops waiting
+func (s *Session) expectReply() (string, <-chan *pkg.MCPResponse, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextReplyID++
+	id := fmt.Sprintf("mcpshield-%d", s.nextReplyID)
+	replyCh := make(chan *pkg.MCPResponse, 1)
+	s.replies[requestKey(id)] = replyCh
+
+	return id, replyCh, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.replies, requestKey(id))
+	}
+}
+
+// deliverReply hands the client's answer to whoever awaits it and reports
+// whether anyone did
+func (s *Session) deliverReply(response *pkg.MCPResponse) bool {
+	s.mu.Lock()
+	replyCh, ok := s.replies[requestKey(response.ID)]
+	delete(s.replies, requestKey(response.ID))
+	s.mu.Unlock()
+
+	if ok {
+		replyCh <- response
+	}
+	return ok
+}
+
+func (s *Session) markInitialized() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.initialized = true
+}
+
+// Send delivers a server-initiated message on the session's open streams and
+// reports whether any stream took it. Streams queue what they are sent, so
+// a slow client never holds up the sender.
+func (s *Session) Send(message interface{}) bool {
+	s.mu.RLock()
+	streams := make([]sessionStream, 0, len(s.streams))
+	for stream := range s.streams {
+		streams = append(streams, stream)
+	}
+	s.mu.RUnlock()
+
+	sent := false
+	for _, stream := range streams {
+		if stream.Send(message) == nil {
+			sent = true
+		}
+	}
+	return sent
+}
+
+// trackRequest makes an in-flight request cancellable by its client id and
+// returns the function that stops tracking it
+func (s *Session) trackRequest(id interface{}, cancel context.CancelFunc) func() {
+	key := requestKey(id)
+	entry := &cancel
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inflight[key] = entry
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// A client reusing the id may have replaced the entry meanwhile
+		if s.inflight[key] == entry {
+			delete(s.inflight, key)
+		}
+		cancel()
+	}
+}
+
+// cancelRequest cancels the in-flight request with the client id and reports
+// whether there was one
+func (s *Session) cancelRequest(id interface{}) bool {
+	s.mu.Lock()
+	cancel, ok := s.inflight[requestKey(id)]
+	s.mu.Unlock()
+
+	if ok {
+		(*cancel)()
+	}
+	return ok
+}
+
+// requestKey normalizes a JSON-RPC id so that 1 and "1" stay distinct
+func requestKey(id interface{}) string {
+	key, _ := json.Marshal(id)
+	return string(key)
+}
+
+func (s *Session) addStream(stream sessionStream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams[stream] = struct{}{}
+}
+
+func (s *Session) removeStream(stream sessionStream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, stream)
+	s.lastSeen = time.Now()
+}
+
+func (s *Session) touch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastSeen = time.Now()
+}
+
+func (s *Session) idleSince(now time.Time) time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.streams) > 0 {
+		return 0
+	}
+	return now.Sub(s.lastSeen)
+}
+
+func (s *Session) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, cancel := range s.inflight {
+		(*cancel)()
+	}
+
+	for stream := range s.streams {
+		stream.Close()
+	}
+	s.streams = make(map[sessionStream]struct{})
+}
+
+// SessionRegistry holds the live client sessions
+type SessionRegistry struct {
+	sessions    map[string]*Session
+	idleTimeout time.Duration
+	onClose     []func(*Session)
+	mu          sync.RWMutex
+}
+
+func NewSessionRegistry(idleTimeout time.Duration) *SessionRegistry {
+	return &SessionRegistry{
+		sessions:    make(map[string]*Session),
+		idleTimeout: idleTimeout,
+	}
+}
+
+// Create mints a session with a random, unguessable id
+func (r *SessionRegistry) Create() *Session {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	session := &Session{
+		ID:       hex.EncodeToString(id),
+		lastSeen: time.Now(),
+		values:   make(map[string]interface{}),
+		streams:  make(map[sessionStream]struct{}),
+		inflight: make(map[string]*context.CancelFunc),
+		replies:  make(map[string]chan *pkg.MCPResponse),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[session.ID] = session
+	return session
+}
+
+// Get returns the session and marks it as active
+func (r *SessionRegistry) Get(id string) (*Session, bool) {
+	r.mu.RLock()
+	session, ok := r.sessions[id]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+	session.touch()
+	return session, true
+}
+
+// OnClose registers a function to run when a session is deleted or expires
+func (r *SessionRegistry) OnClose(fn func(*Session)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onClose = append(r.onClose, fn)
+}
+
+// lookup returns the session without marking it as active, for deliveries
+// that are not client activity
+func (r *SessionRegistry) lookup(id string) (*Session, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	session, ok := r.sessions[id]
+	return session, ok
+}
+
+// Delete terminates the session and closes its streams
+func (r *SessionRegistry) Delete(id string) bool {
+	r.mu.Lock()
+	session, ok := r.sessions[id]
+	delete(r.sessions, id)
+	onClose := r.onClose
+	r.mu.Unlock()
+
+	if ok {
+		session.close()
+		for _, fn := range onClose {
+			fn(session)
+		}
+	}
+	return ok
+}
+
+// List returns a snapshot of the live sessions
+func (r *SessionRegistry) List() []*Session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sessions := make([]*Session, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// Broadcast sends a server-initiated message to every session
+func (r *SessionRegistry) Broadcast(message interface{}) {
+	for _, session := range r.List() {
+		session.Send(message)
+	}
+}
+
+// Expire deletes sessions idle for longer than the timeout
+func (r *SessionRegistry) Expire(now time.Time) int {
+	expired := 0
+	for _, session := range r.List() {
+		if session.idleSince(now) > r.idleTimeout {
+			r.Delete(session.ID)
+			expired++
+		}
+	}
+	return expired
+}
+
+// Run expires idle sessions until the context is done
+func (r *SessionRegistry) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.idleTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.Expire(now)
+		}
+	}
+}
+
+type sessionContextKey struct{}
+
+// SessionFromContext returns the client session of the request being handled
+func SessionFromContext(ctx context.Context) (*Session, bool) {
+	session, ok := ctx.Value(sessionContextKey{}).(*Session)
+	return session, ok
+}
+
+func withSession(ctx context.Context, session *Session) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, session)
+}
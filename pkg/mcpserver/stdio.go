@@ -0,0 +1,170 @@
+package mcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+// maxStdioMessageSize bounds one line read from a stdio client
+const maxStdioMessageSize = 16 * 1024 * 1024
+
+// stdioWriter writes JSON-RPC messages to a stdio client, one per line, from
+// a goroutine of its own
+type stdioWriter struct {
+	out     io.Writer
+	queue   *sendQueue
+	stopped chan struct{}
+}
+
+func newStdioWriter(out io.Writer) *stdioWriter {
+	w := &stdioWriter{
+		out:     out,
+		queue:   newSendQueue(),
+		stopped: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Send queues one JSON-RPC message to be written
+func (w *stdioWriter) Send(message interface{}) error {
+	return w.queue.push(message)
+}
+
+// Close does nothing: stdout belongs to the process, not to the session
+func (w *stdioWriter) Close() {}
+
+// stop writes what is still queued and waits for the writer to finish
+func (w *stdioWriter) stop() {
+	w.queue.close(nil)
+	<-w.stopped
+}
+
+func (w *stdioWriter) run() {
+	defer close(w.stopped)
+
+	for {
+		select {
+		case data := <-w.queue.messages:
+			if err := w.write(data); err != nil {
+				w.queue.close(err)
+				return
+			}
+		case <-w.queue.closed:
+			w.queue.drain(w.write)
+			return
+		}
+	}
+}
+
+func (w *stdioWriter) write(data []byte) error {
+	_, err := w.out.Write(append(data, '\n'))
+	return err
+}
+
+// ServeStdio serves the proxy to a single client over the stdio transport,
+// newline-delimited JSON-RPC on in and out, until in ends or ctx is done.
+// The client gets one session for the lifetime of the connection, which ends
+// early when out fails or the client falls too far behind reading it.
+func (p *Proxy) ServeStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	writer := newStdioWriter(out)
+	defer writer.stop()
+	session := p.sessions.Create()
+	defer p.sessions.Delete(session.ID)
+	session.addStream(writer)
+
+	// Closing stdin abandons whatever is still in flight
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx = withRequestStream(withSession(ctx, session), writer)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	lines := make(chan []byte)
+	scanErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64*1024), maxStdioMessageSize)
+		for scanner.Scan() {
+			select {
+			case lines <- bytes.Clone(scanner.Bytes()):
+			case <-ctx.Done():
+				return
+			}
+		}
+		scanErr <- scanner.Err()
+	}()
+
+	for {
+		var line []byte
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-writer.queue.closed:
+			return writer.queue.failure()
+		case line, ok = <-lines:
+		}
+		if !ok {
+			cancel()
+			select {
+			case err := <-scanErr:
+				return err
+			default:
+				return nil
+			}
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		messages, batch, failure := decodeMessages(line)
+		if failure != nil {
+			writer.Send(failure)
+			continue
+		}
+
+		// Requests run concurrently so that a long call does not hold up
+		// cancellations and replies; everything else is handled in order
+		request := messages[0].request
+		switch {
+		case batch:
+			if version := session.protocolVersion(); version >= versionWithoutBatches {
+				writer.Send(errorResponse(nil, CodeInvalidRequest, "Invalid Request: batches are not supported in protocol version "+version))
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if responses := p.handleMessages(ctx, session, messages); len(responses) > 0 {
+					writer.Send(responses)
+				}
+			}()
+		case request != nil && request.ID != nil && request.Method == "initialize":
+			response := p.answer(ctx, request)
+			if response.Error == nil {
+				session.negotiate(request, response)
+			}
+			writer.Send(response)
+		case request != nil && request.ID != nil:
+			wg.Add(1)
+			go func(request *pkg.MCPRequest) {
+				defer wg.Done()
+				if response, ok := p.answerUnlessCancelled(ctx, request); ok {
+					writer.Send(response)
+				}
+			}(request)
+		default:
+			for _, response := range p.handleMessages(ctx, session, messages) {
+				writer.Send(response)
+			}
+		}
+	}
+}
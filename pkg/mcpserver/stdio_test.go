@@ -0,0 +1,129 @@
+package mcpserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+// stdioClient drives ServeStdio through pipes
+type stdioClient struct {
+	in       *io.PipeWriter
+	messages chan map[string]interface{}
+	done     chan error
+}
+
+func startStdioClient(t *testing.T, proxy *Proxy) *stdioClient {
+	t.Helper()
+
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	client := &stdioClient{
+		in:       inWriter,
+		messages: make(chan map[string]interface{}, 16),
+		done:     make(chan error, 1),
+	}
+
+	go func() {
+		client.done <- proxy.ServeStdio(context.Background(), inReader, outWriter)
+		outWriter.Close()
+	}()
+	go func() {
+		scanner := bufio.NewScanner(outReader)
+		for scanner.Scan() {
+			var message map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+				t.Errorf("Invalid line %q: %v", scanner.Text(), err)
+				continue
+			}
+			client.messages <- message
+		}
+		close(client.messages)
+	}()
+	t.Cleanup(func() { inWriter.Close() })
+	return client
+}
+
+func (c *stdioClient) send(t *testing.T, message string) {
+	t.Helper()
+	if _, err := io.WriteString(c.in, message+"\n"); err != nil {
+		t.Fatalf("Failed to write %s: %v", message, err)
+	}
+}
+
+func (c *stdioClient) next(t *testing.T) map[string]interface{} {
+	t.Helper()
+	select {
+	case message, ok := <-c.messages:
+		if !ok {
+			t.Fatal("Output ended early")
+		}
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a message")
+		return nil
+	}
+}
+
+func TestProxyServesStdio(t *testing.T) {
+	server, upstreams := newTestProxyWithUpstreams(t, "fake")
+	client := startStdioClient(t, server.Config.Handler.(*Proxy))
+
+	client.send(t, `{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{}}}`)
+	if result, _ := client.next(t)["result"].(map[string]interface{}); result["protocolVersion"] != "2025-06-18" {
+		t.Fatalf("Expected the requested version, got %v", result)
+	}
+	client.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+
+	client.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"ms_fake_echo","arguments":{}}}`)
+	if response := client.next(t); response["id"] != "call" || response["result"] == nil {
+		t.Fatalf("Unexpected response %v", response)
+	}
+
+	client.send(t, `not json`)
+	if code := errorCode(t, client.next(t)); code != CodeParseError {
+		t.Fatalf("Expected a parse error, got %d", code)
+	}
+
+	// Server-initiated messages share stdout with the responses
+	upstreams["fake"].setTools("search")
+	upstreams["fake"].send(`{"jsonrpc":"2.0","method":"notifications/tools/list_changed"}`)
+	if notification := client.next(t); notification["method"] != "notifications/tools/list_changed" {
+		t.Fatalf("Expected the list change to be relayed, got %v", notification)
+	}
+
+	client.in.Close()
+	select {
+	case err := <-client.done:
+		if err != nil {
+			t.Fatalf("Expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for ServeStdio to return")
+	}
+	if len(server.Config.Handler.(*Proxy).Sessions().List()) != 0 {
+		t.Fatal("Expected the session to end with stdin")
+	}
+}
+
+func TestProxyCancelsStdioRequests(t *testing.T) {
+	server, upstreams := newTestProxyWithUpstreams(t, "fake")
+	client := startStdioClient(t, server.Config.Handler.(*Proxy))
+
+	client.send(t, `{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{}}}`)
+	client.next(t)
+
+	client.send(t, `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"ms_fake_echo","arguments":{"wait":true}}}`)
+	waitFor(t, func() bool { return upstreams["fake"].count("tools/call") == 1 })
+	client.send(t, `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":7}}`)
+	waitFor(t, func() bool { return upstreams["fake"].cancelledCalls() == 1 })
+
+	// A cancelled request is not answered, so the next message is the ping's
+	client.send(t, `{"jsonrpc":"2.0","id":8,"method":"ping"}`)
+	if response := client.next(t); response["id"] != float64(8) {
+		t.Fatalf("Expected only the ping to be answered, got %v", response)
+	}
+}
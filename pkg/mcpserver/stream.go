@@ -0,0 +1,186 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nsxbet/mcpshield/pkg/sse"
+)
+
+var (
+	errStreamClosed = errors.New("stream closed")
+	errStreamBehind = errors.New("client fell too far behind on the stream")
+)
+
+// keepAliveInterval keeps idle streams from being cut by proxies and load balancers
+const keepAliveInterval = 15 * time.Second
+
+// maxQueuedMessages bounds what a stream holds for a client that is slow to
+// read it
+const maxQueuedMessages = 256
+
+// sendQueue hands messages to the goroutine writing a stream, so that senders
+// never wait on the client. A client falling maxQueuedMessages behind gets
+// its stream closed rather than holding up everyone delivering to it.
+type sendQueue struct {
+	messages chan []byte
+	closed   chan struct{}
+	mu       sync.Mutex
+	done     bool
+	err      error
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{
+		messages: make(chan []byte, maxQueuedMessages),
+		closed:   make(chan struct{}),
+	}
+}
+
+// push queues one JSON-RPC message for the writer
+func (q *sendQueue) push(message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.done {
+		return errStreamClosed
+	}
+	select {
+	case q.messages <- data:
+		return nil
+	default:
+		q.finish(errStreamBehind)
+		return errStreamBehind
+	}
+}
+
+// close stops taking messages. The writer still writes what is queued when
+// err is nil, and drops it otherwise.
+func (q *sendQueue) close(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.finish(err)
+}
+
+func (q *sendQueue) finish(err error) {
+	if !q.done {
+		q.done = true
+		q.err = err
+		close(q.closed)
+	}
+}
+
+// failure returns why the queue was closed, nil for a clean close
+func (q *sendQueue) failure() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+// drain writes what was queued before a clean close
+func (q *sendQueue) drain(write func([]byte) error) {
+	if q.failure() != nil {
+		return
+	}
+
+	for {
+		select {
+		case data := <-q.messages:
+			if write(data) != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// eventStream writes JSON-RPC messages to a client as server-sent events. Only
+// serve writes to the response.
+type eventStream struct {
+	writer  *sse.Writer
+	flusher http.Flusher
+	queue   *sendQueue
+}
+
+func newEventStream(w http.ResponseWriter) (*eventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming not supported")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &eventStream{
+		writer:  sse.NewWriter(w),
+		flusher: flusher,
+		queue:   newSendQueue(),
+	}, nil
+}
+
+// Send queues one JSON-RPC message to be written as a message event
+func (s *eventStream) Send(message interface{}) error {
+	return s.queue.push(message)
+}
+
+// Close ends the stream once what was sent is written; the handler serving it
+// returns and nothing is written to the response afterwards
+func (s *eventStream) Close() {
+	s.queue.close(nil)
+}
+
+// serve writes the queued messages, with keep-alives in between, until the
+// client goes away or the stream is closed
+func (s *eventStream) serve(ctx context.Context) {
+	defer s.queue.close(errStreamClosed)
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data := <-s.queue.messages:
+			if err := s.write(data); err != nil {
+				return
+			}
+		case <-s.queue.closed:
+			s.queue.drain(s.write)
+			return
+		case <-ticker.C:
+			if err := s.ping(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *eventStream) write(data []byte) error {
+	if err := s.writer.WriteEvent(&sse.Event{Event: "message", Data: string(data)}); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *eventStream) ping() error {
+	if err := s.writer.WriteComment("keep-alive"); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
@@ -0,0 +1,68 @@
+package mcpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// stalledWriter is a response whose client stops reading after the headers
+type stalledWriter struct {
+	header  http.Header
+	release chan struct{}
+}
+
+func (w *stalledWriter) Header() http.Header { return w.header }
+
+func (w *stalledWriter) WriteHeader(int) {}
+
+func (w *stalledWriter) Write(data []byte) (int, error) {
+	<-w.release
+	return 0, errors.New("client went away")
+}
+
+func (w *stalledWriter) Flush() {}
+
+func TestEventStreamClosesWhenClientFallsBehind(t *testing.T) {
+	w := &stalledWriter{header: http.Header{}, release: make(chan struct{})}
+	stream, err := newEventStream(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan struct{})
+	go func() {
+		stream.serve(context.Background())
+		close(served)
+	}()
+
+	// Senders never wait on the client, however far behind it is
+	sent := make(chan error)
+	go func() {
+		for i := 0; ; i++ {
+			if err := stream.Send(map[string]interface{}{"jsonrpc": "2.0", "method": "notifications/message", "params": map[string]interface{}{"seq": i}}); err != nil {
+				sent <- err
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-sent:
+		if !errors.Is(err, errStreamBehind) {
+			t.Fatalf("Expected the stream to give up on the client, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out sending to a stalled client")
+	}
+	if err := stream.Send(map[string]interface{}{}); !errors.Is(err, errStreamClosed) {
+		t.Fatalf("Expected the stream to stay closed, got %v", err)
+	}
+
+	close(w.release)
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the stream to end")
+	}
+}
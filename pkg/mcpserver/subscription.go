@@ -0,0 +1,156 @@
+package mcpserver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+// subscriptionKey identifies an upstream resource by its owning server and
+// the URI the server knows it by
+type subscriptionKey struct {
+	serverName string
+	uri        string
+}
+
+// subscription is a resource the sessions subscribed to, and the upstream
+// subscribe made for them
+type subscription struct {
+	sessions map[string]struct{}
+	upstream *upstreamSubscription
+}
+
+// upstreamSubscription is the resources/subscribe sent to the owning server
+// for the first subscriber. done is closed once it is answered.
+type upstreamSubscription struct {
+	done     chan struct{}
+	response *pkg.MCPResponse
+	err      error
+}
+
+// Wait blocks until the server answered the subscribe and returns what it
+// answered
+func (u *upstreamSubscription) Wait(ctx context.Context) (*pkg.MCPResponse, error) {
+	select {
+	case <-u.done:
+		return u.response, u.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// failed reports whether the server refused the subscribe or never answered
+func (u *upstreamSubscription) failed() bool {
+	return u.err != nil || u.response == nil || u.response.Error != nil
+}
+
+// SubscriptionTable tracks which client sessions subscribed to which upstream
+// resources. The upstream server is subscribed once, when the first session
+// subscribes, and unsubscribed when the last one leaves.
+type SubscriptionTable struct {
+	subscribers map[subscriptionKey]*subscription
+	mu          sync.Mutex
+}
+
+func NewSubscriptionTable() *SubscriptionTable {
+	return &SubscriptionTable{
+		subscribers: make(map[subscriptionKey]*subscription),
+	}
+}
+
+// Add records the subscription and returns the upstream subscribe for the
+// resource, reporting whether it is the first. The first subscriber starts
+// the upstream subscribe and reports its result with Settle; every
+// subscriber waits on it.
+func (t *SubscriptionTable) Add(serverName, uri, sessionID string) (*upstreamSubscription, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := subscriptionKey{serverName: serverName, uri: uri}
+	entry, ok := t.subscribers[key]
+	if !ok {
+		entry = &subscription{
+			sessions: make(map[string]struct{}),
+			upstream: &upstreamSubscription{done: make(chan struct{})},
+		}
+		t.subscribers[key] = entry
+	}
+	entry.sessions[sessionID] = struct{}{}
+	return entry.upstream, !ok
+}
+
+// Settle records what the server answered the upstream subscribe with. When
+// it failed, every session that subscribed meanwhile is dropped with it.
+// When they all left before, whoever removed the last one unsubscribes the
+// server once it settled.
+func (t *SubscriptionTable) Settle(serverName, uri string, upstream *upstreamSubscription, response *pkg.MCPResponse, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	upstream.response, upstream.err = response, err
+	key := subscriptionKey{serverName: serverName, uri: uri}
+	if entry, ok := t.subscribers[key]; ok && entry.upstream == upstream && upstream.failed() {
+		delete(t.subscribers, key)
+	}
+	close(upstream.done)
+}
+
+// Remove drops the subscription. When it was the last for the resource, it
+// returns the upstream subscribe the server is to be unsubscribed from.
+func (t *SubscriptionTable) Remove(serverName, uri, sessionID string) (*upstreamSubscription, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := subscriptionKey{serverName: serverName, uri: uri}
+	entry, ok := t.subscribers[key]
+	if !ok {
+		return nil, false
+	}
+	if _, subscribed := entry.sessions[sessionID]; !subscribed {
+		return nil, false
+	}
+
+	delete(entry.sessions, sessionID)
+	if len(entry.sessions) > 0 {
+		return nil, false
+	}
+	delete(t.subscribers, key)
+	return entry.upstream, true
+}
+
+// RemoveSession drops every subscription of the session and returns the
+// upstream subscribes of the resources left without subscribers
+func (t *SubscriptionTable) RemoveSession(sessionID string) map[subscriptionKey]*upstreamSubscription {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	orphaned := make(map[subscriptionKey]*upstreamSubscription)
+	for key, entry := range t.subscribers {
+		if _, ok := entry.sessions[sessionID]; !ok {
+			continue
+		}
+		delete(entry.sessions, sessionID)
+		if len(entry.sessions) == 0 {
+			delete(t.subscribers, key)
+			orphaned[key] = entry.upstream
+		}
+	}
+	return orphaned
+}
+
+// Subscribers returns the ids of the sessions subscribed to the resource
+func (t *SubscriptionTable) Subscribers(serverName, uri string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.subscribers[subscriptionKey{serverName: serverName, uri: uri}]
+	if !ok {
+		return []string{}
+	}
+	ids := make([]string, 0, len(entry.sessions))
+	for id := range entry.sessions {
+		ids = append(ids, id)
+	}
+	return ids
+}
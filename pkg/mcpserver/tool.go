@@ -2,12 +2,15 @@ package mcpserver
 
 import (
 	"fmt"
+	"reflect"
 	"sync"
 )
 
 type Tool struct {
 	originalName string
 	serverName   string
+	// name is what clients call the tool, given by the server's naming
+	name         string
 	definition   map[string]interface{}
 }
 
@@ -16,7 +19,7 @@ func (t *Tool) Key() string {
 }
 
 func (t *Tool) Name() string {
-	return fmt.Sprintf("ms_%s_%s", t.serverName, t.originalName)
+	return t.name
 }
 
 func (t *Tool) GetServerName() string {
@@ -29,12 +32,15 @@ func (t *Tool) GetOriginalName() string {
 
 type ToolRegistry struct {
 	tools map[string]Tool
+	// names indexes the tools by the name clients call them by
+	names map[string]string
 	mu    sync.RWMutex
 }
 
 func NewToolRegistry() *ToolRegistry {
 	return &ToolRegistry{
 		tools: make(map[string]Tool),
+		names: make(map[string]string),
 	}
 }
 
@@ -43,7 +49,37 @@ func (tr *ToolRegistry) UpdateTool(tool Tool) {
 	defer tr.mu.Unlock()
 	
 	key := tool.Key()
+	if existing, ok := tr.tools[key]; ok {
+		delete(tr.names, existing.Name())
+	}
 	tr.tools[key] = tool
+	tr.names[tool.Name()] = key
+}
+
+// Replace swaps in the tools a server currently offers, dropping the ones it
+// no longer does, and reports whether anything changed
+func (tr *ToolRegistry) Replace(tools []Tool) bool {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	
+	replacement := make(map[string]Tool, len(tools))
+	names := make(map[string]string, len(tools))
+	for _, tool := range tools {
+		replacement[tool.Key()] = tool
+		names[tool.Name()] = tool.Key()
+	}
+	
+	changed := len(replacement) != len(tr.tools)
+	for key, tool := range replacement {
+		existing, ok := tr.tools[key]
+		if !ok || existing.Name() != tool.Name() || !reflect.DeepEqual(existing.definition, tool.definition) {
+			changed = true
+		}
+	}
+	
+	tr.tools = replacement
+	tr.names = names
+	return changed
 }
 
 func (tr *ToolRegistry) ToList() []interface{} {
@@ -71,16 +107,29 @@ func (tr *ToolRegistry) Print() {
 		fmt.Printf("  🔧 %s -> %s (from %s)\n", tool.GetOriginalName(), tool.Name(), tool.GetServerName())
 	}
 }
-func (tr *ToolRegistry) FindByName(name string) (*Tool, bool) {
+
+// Tools returns a copy of every tool
+func (tr *ToolRegistry) Tools() []Tool {
 	tr.mu.RLock()
 	defer tr.mu.RUnlock()
 	
+	tools := make([]Tool, 0, len(tr.tools))
 	for _, tool := range tr.tools {
-		if tool.Name() == name {
-			return &tool, true
-		}
+		tools = append(tools, tool)
+	}
+	return tools
+}
+
+func (tr *ToolRegistry) FindByName(name string) (*Tool, bool) {
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+	
+	key, ok := tr.names[name]
+	if !ok {
+		return nil, false
 	}
-	return nil, false
+	tool := tr.tools[key]
+	return &tool, true
 }
 
  
\ No newline at end of file
@@ -0,0 +1,272 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/nsxbet/mcpshield/pkg"
+	"github.com/nsxbet/mcpshield/pkg/auth"
+)
+
+// sessionIDHeader carries the client session id of the Streamable HTTP transport
+const sessionIDHeader = "Mcp-Session-Id"
+
+// ServeHTTP makes Proxy implement http.Handler with the Streamable HTTP transport
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", sessionIDHeader)
+
+	switch r.Method {
+	case http.MethodPost:
+		p.handlePost(w, r)
+	case http.MethodGet:
+		p.handleGet(w, r)
+	case http.MethodDelete:
+		p.handleDelete(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePost answers a client message or batch of messages, as a JSON body or
+// as an SSE stream for single calls that may run long
+func (p *Proxy) handlePost(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	messages, batch, failure := decodeMessages(body)
+	if failure != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(failure)
+		return
+	}
+
+	single := messages[0].request
+	if !batch && single != nil && single.Method == "initialize" {
+		p.handleInitialize(w, r, single)
+		return
+	}
+
+	session, status := p.lookupSession(r)
+	if session == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	ctx := withSession(r.Context(), session)
+
+	if version := session.protocolVersion(); batch && version >= versionWithoutBatches {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(errorResponse(nil, CodeInvalidRequest, "Invalid Request: batches are not supported in protocol version "+version))
+		return
+	}
+
+	if !batch && single != nil && single.ID != nil && single.Method == "tools/call" && acceptsEventStream(r) {
+		p.streamResponse(ctx, w, single)
+		return
+	}
+
+	// Notifications and responses are accepted without a body
+	responses := p.handleMessages(ctx, session, messages)
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if batch {
+		json.NewEncoder(w).Encode(responses)
+		return
+	}
+	json.NewEncoder(w).Encode(responses[0])
+}
+
+// handleMessages handles the messages of one post, running its requests
+// concurrently, and returns their responses in the order they were sent
+func (p *Proxy) handleMessages(ctx context.Context, session *Session, messages []*clientMessage) []*pkg.MCPResponse {
+	answers := make([]*pkg.MCPResponse, len(messages))
+	var wg sync.WaitGroup
+	for i, message := range messages {
+		switch {
+		case message.invalid != nil:
+			answers[i] = message.invalid
+		case message.response != nil:
+			session.deliverReply(message.response)
+		case message.request.ID == nil:
+			p.handleNotification(session, message.request)
+		case message.request.Method == "initialize":
+			answers[i] = errorResponse(message.request.ID, CodeInvalidRequest, "Invalid Request: initialize must not be part of a batch")
+		default:
+			wg.Add(1)
+			go func(i int, request *pkg.MCPRequest) {
+				defer wg.Done()
+				answers[i] = p.answer(ctx, request)
+			}(i, message.request)
+		}
+	}
+	wg.Wait()
+
+	responses := []*pkg.MCPResponse{}
+	for _, answer := range answers {
+		if answer != nil {
+			responses = append(responses, answer)
+		}
+	}
+	return responses
+}
+
+// answer handles a request and returns its response or error, carrying the
+// id the client sent
+func (p *Proxy) answer(ctx context.Context, request *pkg.MCPRequest) *pkg.MCPResponse {
+	response, err := p.handle(ctx, request)
+	if err != nil {
+		return errorResponseFor(request.ID, err)
+	}
+	response.ID = request.ID
+	return response
+}
+
+// answerUnlessCancelled is answer for requests answered asynchronously; it
+// reports false when the client went away or cancelled the request, since
+// nobody is waiting for the response then
+func (p *Proxy) answerUnlessCancelled(ctx context.Context, request *pkg.MCPRequest) (*pkg.MCPResponse, bool) {
+	response, err := p.handle(ctx, request)
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return nil, false
+	}
+	if err != nil {
+		return errorResponseFor(request.ID, err), true
+	}
+	response.ID = request.ID
+	return response, true
+}
+
+// handleInitialize starts a new session; its id is returned in the
+// Mcp-Session-Id header and must accompany every later request
+func (p *Proxy) handleInitialize(w http.ResponseWriter, r *http.Request, request *pkg.MCPRequest) {
+	session := p.sessions.Create()
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	session.bindPrincipal(principal)
+
+	w.Header().Set("Content-Type", "application/json")
+	response := p.answer(withSession(r.Context(), session), request)
+	if response.Error != nil {
+		p.sessions.Delete(session.ID)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	session.negotiate(request, response)
+	w.Header().Set(sessionIDHeader, session.ID)
+	json.NewEncoder(w).Encode(response)
+}
+
+// streamResponse runs the request while keeping an SSE stream open, then
+// sends the response as the final event
+func (p *Proxy) streamResponse(ctx context.Context, w http.ResponseWriter, request *pkg.MCPRequest) {
+	stream, err := newEventStream(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer stream.Close()
+
+	go func() {
+		if response, ok := p.answerUnlessCancelled(withRequestStream(ctx, stream), request); ok {
+			stream.Send(response)
+		}
+		stream.Close()
+	}()
+
+	stream.serve(ctx)
+}
+
+// handleGet opens a standalone stream for server-initiated messages
+func (p *Proxy) handleGet(w http.ResponseWriter, r *http.Request) {
+	if !acceptsEventStream(r) {
+		http.Error(w, "Accept must include text/event-stream", http.StatusNotAcceptable)
+		return
+	}
+
+	session, status := p.lookupSession(r)
+	if session == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	stream, err := newEventStream(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer stream.Close()
+
+	session.addStream(stream)
+	defer session.removeStream(stream)
+
+	stream.serve(r.Context())
+}
+
+// handleDelete terminates a session, closing its open streams
+func (p *Proxy) handleDelete(w http.ResponseWriter, r *http.Request) {
+	session, status := p.lookupSession(r)
+	if session == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	if !p.sessions.Delete(session.ID) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookupSession resolves the request's session, or the HTTP status to reject
+// it with: 400 without a session id or with a protocol version other than the
+// negotiated one, 404 for unknown or expired sessions and for sessions another
+// principal initialized, so that a leaked session id is of no use
+func (p *Proxy) lookupSession(r *http.Request) (*Session, int) {
+	sessionID := r.Header.Get(sessionIDHeader)
+	if sessionID == "" {
+		return nil, http.StatusBadRequest
+	}
+
+	session, ok := p.sessions.lookup(sessionID)
+	if !ok {
+		return nil, http.StatusNotFound
+	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !session.belongsTo(principal) {
+		return nil, http.StatusNotFound
+	}
+	session.touch()
+
+	// Clients before 2025-06-18 do not send the header
+	if version := r.Header.Get(protocolVersionHeader); version != "" && version != session.protocolVersion() {
+		return nil, http.StatusBadRequest
+	}
+	return session, http.StatusOK
+}
+
+func acceptsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+func errorResponse(id interface{}, code int, message string) *pkg.MCPResponse {
+	return &pkg.MCPResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   map[string]interface{}{"code": code, "message": message},
+	}
+}
@@ -0,0 +1,139 @@
+package mcpserver
+
+import (
+	"encoding/json"
+
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+// LatestProtocolVersion is offered to upstream servers and to clients asking
+// for a version the proxy does not speak
+const LatestProtocolVersion = "2025-06-18"
+
+// protocolVersionHeader accompanies every request after initialize from
+// protocol version 2025-06-18 on
+const protocolVersionHeader = "MCP-Protocol-Version"
+
+// SupportedProtocolVersions lists the protocol versions the proxy speaks,
+// newest first
+var SupportedProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// Versions that introduced features needing translation for older peers.
+// Versions are dates, so they compare as strings.
+const (
+	versionToolAnnotations  = "2025-03-26"
+	versionStructuredOutput = "2025-06-18"
+	versionResourceLinks    = "2025-06-18"
+	versionWithoutBatches   = "2025-06-18"
+)
+
+func isSupportedProtocolVersion(version string) bool {
+	for _, supported := range SupportedProtocolVersions {
+		if version == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateProtocolVersion answers the version a client requested: the same
+// one when the proxy speaks it, otherwise the latest and the client decides
+// whether to carry on
+func negotiateProtocolVersion(requested string) string {
+	if isSupportedProtocolVersion(requested) {
+		return requested
+	}
+	return LatestProtocolVersion
+}
+
+// translateResponse rewrites a response for a client on an older protocol
+// version, dropping what it would not understand. Upstream servers on older
+// versions need no translation since newer fields are all optional.
+func translateResponse(version, method string, response *pkg.MCPResponse) {
+	result, ok := response.Result.(map[string]interface{})
+	if !ok || version == "" || version >= LatestProtocolVersion {
+		return
+	}
+
+	switch method {
+	case "tools/list":
+		tools, _ := result["tools"].([]interface{})
+		for i, tool := range tools {
+			if definition, ok := tool.(map[string]interface{}); ok {
+				tools[i] = translateTool(version, definition)
+			}
+		}
+	case "tools/call":
+		translateToolResult(version, result)
+	}
+}
+
+func translateTool(version string, definition map[string]interface{}) map[string]interface{} {
+	translated := make(map[string]interface{}, len(definition))
+	for key, value := range definition {
+		translated[key] = value
+	}
+	if version < versionStructuredOutput {
+		delete(translated, "outputSchema")
+		delete(translated, "title")
+	}
+	if version < versionToolAnnotations {
+		delete(translated, "annotations")
+	}
+	return translated
+}
+
+func translateToolResult(version string, result map[string]interface{}) {
+	content, _ := result["content"].([]interface{})
+
+	if version < versionResourceLinks {
+		// Links become text naming the resource
+		for i, item := range content {
+			if block, ok := item.(map[string]interface{}); ok && block["type"] == "resource_link" {
+				uri, _ := block["uri"].(string)
+				content[i] = map[string]interface{}{"type": "text", "text": uri}
+			}
+		}
+	}
+
+	if structured, ok := result["structuredContent"]; ok && version < versionStructuredOutput {
+		// Servers should also serialize structured output as text; when one
+		// did not, the client would be left with nothing
+		delete(result, "structuredContent")
+		if len(content) == 0 {
+			data, _ := json.Marshal(structured)
+			content = []interface{}{map[string]interface{}{"type": "text", "text": string(data)}}
+		}
+	}
+
+	if content != nil {
+		result["content"] = content
+	}
+}
+
+// ProtocolVersionReport lists the protocol versions the proxy speaks and the
+// ones it negotiated with each upstream server and its client sessions
+type ProtocolVersionReport struct {
+	Supported []string          `json:"supported"`
+	Servers   map[string]string `json:"servers"`
+	// Sessions counts the live client sessions per negotiated version
+	Sessions map[string]int `json:"sessions"`
+}
+
+// ProtocolVersions reports the negotiated protocol versions
+func (p *Proxy) ProtocolVersions() *ProtocolVersionReport {
+	report := &ProtocolVersionReport{
+		Supported: SupportedProtocolVersions,
+		Servers:   map[string]string{},
+		Sessions:  map[string]int{},
+	}
+	for name, server := range p.servers.byName {
+		report.Servers[name] = server.ProtocolVersion()
+	}
+	for _, session := range p.sessions.List() {
+		if version := session.protocolVersion(); version != "" {
+			report.Sessions[version]++
+		}
+	}
+	return report
+}
@@ -0,0 +1,68 @@
+package mcpserver
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+func TestTranslateToolsListForOlderClients(t *testing.T) {
+	definition := map[string]interface{}{
+		"name":         "ms_fake_echo",
+		"title":        "Echo",
+		"inputSchema":  map[string]interface{}{},
+		"outputSchema": map[string]interface{}{},
+		"annotations":  map[string]interface{}{"readOnlyHint": true},
+	}
+
+	tests := map[string][]string{
+		"2025-06-18": {"annotations", "inputSchema", "name", "outputSchema", "title"},
+		"2025-03-26": {"annotations", "inputSchema", "name"},
+		"2024-11-05": {"inputSchema", "name"},
+	}
+	for version, expected := range tests {
+		response := &pkg.MCPResponse{Result: map[string]interface{}{"tools": []interface{}{definition}}}
+		translateResponse(version, "tools/list", response)
+
+		tool := response.Result.(map[string]interface{})["tools"].([]interface{})[0].(map[string]interface{})
+		var keys []string
+		for _, key := range []string{"annotations", "inputSchema", "name", "outputSchema", "title"} {
+			if _, ok := tool[key]; ok {
+				keys = append(keys, key)
+			}
+		}
+		if !reflect.DeepEqual(keys, expected) {
+			t.Errorf("%s: expected %v, got %v", version, expected, keys)
+		}
+	}
+
+	if _, ok := definition["outputSchema"]; !ok {
+		t.Fatal("Expected the registered definition to be left untouched")
+	}
+}
+
+func TestTranslateToolResultForOlderClients(t *testing.T) {
+	response := &pkg.MCPResponse{Result: map[string]interface{}{
+		"content":           []interface{}{map[string]interface{}{"type": "resource_link", "uri": "file:///a"}},
+		"structuredContent": map[string]interface{}{"ok": true},
+	}}
+	translateResponse("2025-03-26", "tools/call", response)
+
+	expected := map[string]interface{}{
+		"content": []interface{}{map[string]interface{}{"type": "text", "text": "file:///a"}},
+	}
+	if !reflect.DeepEqual(response.Result, expected) {
+		t.Fatalf("Expected %v, got %v", expected, response.Result)
+	}
+
+	// Structured output alone is serialized so that the result is not lost
+	response = &pkg.MCPResponse{Result: map[string]interface{}{"structuredContent": map[string]interface{}{"ok": true}}}
+	translateResponse("2025-03-26", "tools/call", response)
+	expected = map[string]interface{}{
+		"content": []interface{}{map[string]interface{}{"type": "text", "text": `{"ok":true}`}},
+	}
+	if !reflect.DeepEqual(response.Result, expected) {
+		t.Fatalf("Expected %v, got %v", expected, response.Result)
+	}
+}
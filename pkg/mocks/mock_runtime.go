@@ -70,6 +70,18 @@ func (mr *MockRuntimeMockRecorder) IsReady() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsReady", reflect.TypeOf((*MockRuntime)(nil).IsReady))
 }
 
+// SetMessageHandler mocks base method.
+func (m *MockRuntime) SetMessageHandler(handler pkg.MessageHandler) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetMessageHandler", handler)
+}
+
+// SetMessageHandler indicates an expected call of SetMessageHandler.
+func (mr *MockRuntimeMockRecorder) SetMessageHandler(handler any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMessageHandler", reflect.TypeOf((*MockRuntime)(nil).SetMessageHandler), handler)
+}
+
 // Start mocks base method.
 func (m *MockRuntime) Start(ctx context.Context) error {
 	m.ctrl.T.Helper()
@@ -123,15 +135,53 @@ func (m *MockRuntimeFactory) EXPECT() *MockRuntimeFactoryMockRecorder {
 }
 
 // CreateRuntime mocks base method.
-func (m *MockRuntimeFactory) CreateRuntime(image, command string, args []string, env map[string]string) pkg.Runtime {
+func (m *MockRuntimeFactory) CreateRuntime(name, image, command string, args []string, env map[string]string) pkg.Runtime {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateRuntime", image, command, args, env)
+	ret := m.ctrl.Call(m, "CreateRuntime", name, image, command, args, env)
 	ret0, _ := ret[0].(pkg.Runtime)
 	return ret0
 }
 
 // CreateRuntime indicates an expected call of CreateRuntime.
-func (mr *MockRuntimeFactoryMockRecorder) CreateRuntime(image, command, args, env any) *gomock.Call {
+func (mr *MockRuntimeFactoryMockRecorder) CreateRuntime(name, image, command, args, env any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRuntime", reflect.TypeOf((*MockRuntimeFactory)(nil).CreateRuntime), name, image, command, args, env)
+}
+
+// MockRemoteRuntimeFactory is a mock of RemoteRuntimeFactory interface.
+type MockRemoteRuntimeFactory struct {
+	ctrl     *gomock.Controller
+	recorder *MockRemoteRuntimeFactoryMockRecorder
+	isgomock struct{}
+}
+
+// MockRemoteRuntimeFactoryMockRecorder is the mock recorder for MockRemoteRuntimeFactory.
+type MockRemoteRuntimeFactoryMockRecorder struct {
+	mock *MockRemoteRuntimeFactory
+}
+
+// NewMockRemoteRuntimeFactory creates a new mock instance.
+func NewMockRemoteRuntimeFactory(ctrl *gomock.Controller) *MockRemoteRuntimeFactory {
+	mock := &MockRemoteRuntimeFactory{ctrl: ctrl}
+	mock.recorder = &MockRemoteRuntimeFactoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRemoteRuntimeFactory) EXPECT() *MockRemoteRuntimeFactoryMockRecorder {
+	return m.recorder
+}
+
+// CreateRemoteRuntime mocks base method.
+func (m *MockRemoteRuntimeFactory) CreateRemoteRuntime(url, transport string, headers map[string]string) pkg.Runtime {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateRemoteRuntime", url, transport, headers)
+	ret0, _ := ret[0].(pkg.Runtime)
+	return ret0
+}
+
+// CreateRemoteRuntime indicates an expected call of CreateRemoteRuntime.
+func (mr *MockRemoteRuntimeFactoryMockRecorder) CreateRemoteRuntime(url, transport, headers any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRuntime", reflect.TypeOf((*MockRuntimeFactory)(nil).CreateRuntime), image, command, args, env)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRemoteRuntime", reflect.TypeOf((*MockRemoteRuntimeFactory)(nil).CreateRemoteRuntime), url, transport, headers)
 }
@@ -0,0 +1,265 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+const (
+	// DefaultDockerHost is the engine socket used when none is configured
+	DefaultDockerHost = "unix:///var/run/docker.sock"
+
+	dockerManagedLabel = "mcpshield.io/managed"
+	dockerNameLabel    = "mcpshield.io/name"
+)
+
+// DockerRuntime runs an MCP server in a container on a local container engine
+// and talks to it over the container's attached stdin/stdout
+type DockerRuntime struct {
+	client      *dockerClient
+	logger      *log.Logger
+	network     string
+	name        string
+	image       string
+	command     string
+	args        []string
+	env         map[string]string
+	containerID string
+	session     *stdioSession
+	handler     pkg.MessageHandler
+	mu          sync.Mutex
+}
+
+type DockerRuntimeFactory struct {
+	client  *dockerClient
+	network string
+	logger  *log.Logger
+}
+
+func NewDockerRuntimeFactory(host, network string, logger *log.Logger) (pkg.RuntimeFactory, error) {
+	if host == "" {
+		host = DefaultDockerHost
+	}
+
+	client, err := newDockerClient(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container engine client: %w", err)
+	}
+
+	return &DockerRuntimeFactory{
+		client:  client,
+		network: network,
+		logger:  logger,
+	}, nil
+}
+
+// CreateRuntime names the container after the server, so that servers
+// sharing an image do not replace each other's containers
+func (f *DockerRuntimeFactory) CreateRuntime(serverName, image, command string, args []string, env map[string]string) pkg.Runtime {
+	name := fmt.Sprintf("mcp-%s", cleanContainerName(serverName))
+	return &DockerRuntime{
+		client:  f.client,
+		logger:  f.logger.With("container", name),
+		network: f.network,
+		name:    name,
+		image:   image,
+		command: command,
+		args:    args,
+		env:     env,
+	}
+}
+
+func (d *DockerRuntime) Start(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Containers left behind by a previous run are replaced, like deployments
+	if err := d.removeStaleContainers(ctx); err != nil {
+		return fmt.Errorf("failed to remove stale containers: %w", err)
+	}
+
+	containerID, err := d.createContainer(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+	d.containerID = containerID
+
+	// Attach before starting so that no output is lost
+	session, err := d.attach(ctx)
+	if err != nil {
+		d.client.removeContainer(context.Background(), containerID)
+		return fmt.Errorf("failed to attach to container: %w", err)
+	}
+
+	if err := d.client.startContainer(ctx, containerID); err != nil {
+		session.Close()
+		d.client.removeContainer(context.Background(), containerID)
+		return fmt.Errorf("failed to start container: %w", err)
+	}
+
+	d.session = session
+	return nil
+}
+
+func (d *DockerRuntime) Exec(ctx context.Context, input []byte) ([]byte, error) {
+	d.mu.Lock()
+	session := d.session
+	d.mu.Unlock()
+
+	if session == nil {
+		return nil, fmt.Errorf("container not started")
+	}
+	return session.Exec(ctx, input)
+}
+
+func (d *DockerRuntime) Stop(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.session != nil {
+		d.session.Close()
+		d.session = nil
+	}
+
+	if d.containerID == "" {
+		return nil
+	}
+
+	if err := d.client.removeContainer(ctx, d.containerID); err != nil && !isDockerNotFound(err) {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+	d.containerID = ""
+	return nil
+}
+
+func (d *DockerRuntime) IsReady() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.containerID == "" || d.session == nil {
+		return false
+	}
+
+	select {
+	case <-d.session.Done():
+		return false
+	default:
+	}
+
+	state, err := d.client.inspectContainer(context.Background(), d.containerID)
+	if err != nil {
+		return false
+	}
+	return state.Running
+}
+
+func (d *DockerRuntime) SetMessageHandler(handler pkg.MessageHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handler = handler
+}
+
+func (d *DockerRuntime) labels() map[string]string {
+	return map[string]string{
+		dockerManagedLabel: "true",
+		dockerNameLabel:    d.name,
+	}
+}
+
+func (d *DockerRuntime) removeStaleContainers(ctx context.Context) error {
+	ids, err := d.client.listContainers(ctx, d.labels())
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := d.client.removeContainer(ctx, id); err != nil && !isDockerNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DockerRuntime) createContainer(ctx context.Context) (string, error) {
+	env := make([]string, 0, len(d.env))
+	for key, value := range d.env {
+		env = append(env, fmt.Sprintf("%s=%s", key, os.ExpandEnv(value)))
+	}
+
+	spec := &dockerContainerSpec{
+		Image:  d.image,
+		Cmd:    d.args,
+		Env:    env,
+		Labels: d.labels(),
+		// Stdin stays open so the server keeps its state; no TTY so the
+		// JSON-RPC stream is not mangled
+		OpenStdin:    true,
+		StdinOnce:    false,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          false,
+		HostConfig:   dockerHostConfig{NetworkMode: d.network},
+	}
+	if d.command != "" {
+		spec.Entrypoint = []string{d.command}
+	}
+
+	containerID, err := d.client.createContainer(ctx, spec)
+	if !isDockerNotFound(err) {
+		return containerID, err
+	}
+
+	d.logger.Info("Pulling image", "image", d.image)
+	if err := d.client.pullImage(ctx, d.image); err != nil {
+		return "", fmt.Errorf("failed to pull image %s: %w", d.image, err)
+	}
+	return d.client.createContainer(ctx, spec)
+}
+
+func (d *DockerRuntime) attach(ctx context.Context) (*stdioSession, error) {
+	stream, err := d.client.attachContainer(ctx, d.containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	stdoutReader, stdoutWriter := io.Pipe()
+	stderrReader, stderrWriter := io.Pipe()
+
+	go func() {
+		err := demuxDockerStream(stream, stdoutWriter, stderrWriter)
+		stdoutWriter.CloseWithError(err)
+		stderrWriter.CloseWithError(err)
+	}()
+	go logStderr(d.logger, stderrReader)
+
+	return newStdioSession(stream, stdoutReader, d.handler), nil
+}
+
+// cleanContainerName keeps the characters container names allow
+func cleanContainerName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, name)
+}
+
+func isDockerNotFound(err error) bool {
+	var engineErr *dockerError
+	return errors.As(err, &engineErr) && engineErr.StatusCode == http.StatusNotFound
+}
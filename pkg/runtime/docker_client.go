@@ -0,0 +1,287 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// dockerClient is a minimal client for the Docker Engine API, which Podman and
+// other engines also serve, spoken over a local unix socket
+type dockerClient struct {
+	http *http.Client
+}
+
+type dockerError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *dockerError) Error() string {
+	return fmt.Sprintf("container engine returned %d: %s", e.StatusCode, e.Message)
+}
+
+type dockerContainerSpec struct {
+	Image        string            `json:"Image"`
+	Entrypoint   []string          `json:"Entrypoint,omitempty"`
+	Cmd          []string          `json:"Cmd,omitempty"`
+	Env          []string          `json:"Env,omitempty"`
+	Labels       map[string]string `json:"Labels,omitempty"`
+	OpenStdin    bool              `json:"OpenStdin"`
+	StdinOnce    bool              `json:"StdinOnce"`
+	AttachStdin  bool              `json:"AttachStdin"`
+	AttachStdout bool              `json:"AttachStdout"`
+	AttachStderr bool              `json:"AttachStderr"`
+	Tty          bool              `json:"Tty"`
+	HostConfig   dockerHostConfig  `json:"HostConfig"`
+}
+
+type dockerHostConfig struct {
+	NetworkMode string `json:"NetworkMode,omitempty"`
+}
+
+type dockerContainerState struct {
+	Running bool `json:"Running"`
+}
+
+func newDockerClient(host string) (*dockerClient, error) {
+	socketPath, ok := strings.CutPrefix(host, "unix://")
+	if !ok {
+		return nil, fmt.Errorf("unsupported container engine host %q: only unix:// sockets are supported", host)
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	return &dockerClient{http: &http.Client{Transport: transport}}, nil
+}
+
+func (c *dockerClient) newRequest(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	// The host is ignored by the unix socket dialer
+	endpoint := url.URL{Scheme: "http", Host: "docker", Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+func (c *dockerClient) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	req, err := c.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return readDockerError(resp)
+	}
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *dockerClient) createContainer(ctx context.Context, spec *dockerContainerSpec) (string, error) {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/containers/create", nil, spec, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// pullImage blocks until the engine has finished pulling the image. The
+// engine answers with a stream of progress messages and reports failures in
+// it, after the 200 status.
+func (c *dockerClient) pullImage(ctx context.Context, image string) error {
+	repository, tag := splitImageReference(image)
+	query := url.Values{"fromImage": {repository}}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, "/images/create", query, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return readDockerError(resp)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var progress struct {
+			Error       string `json:"error"`
+			ErrorDetail struct {
+				Message string `json:"message"`
+			} `json:"errorDetail"`
+		}
+		if err := decoder.Decode(&progress); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read pull progress: %w", err)
+		}
+		if progress.ErrorDetail.Message != "" {
+			return fmt.Errorf("%s", progress.ErrorDetail.Message)
+		}
+		if progress.Error != "" {
+			return fmt.Errorf("%s", progress.Error)
+		}
+	}
+}
+
+// splitImageReference splits an image into the repository and tag to pull.
+// Without a tag the engine pulls every tag of the repository, so latest is
+// assumed like docker pull does; references pinned by digest are pulled as
+// they are.
+func splitImageReference(image string) (string, string) {
+	if strings.Contains(image, "@") {
+		return image, ""
+	}
+	// A colon before the last slash separates a registry port, not a tag
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, "latest"
+}
+
+func (c *dockerClient) startContainer(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil, nil)
+}
+
+func (c *dockerClient) inspectContainer(ctx context.Context, id string) (*dockerContainerState, error) {
+	var inspect struct {
+		State dockerContainerState `json:"State"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/containers/"+id+"/json", nil, nil, &inspect); err != nil {
+		return nil, err
+	}
+	return &inspect.State, nil
+}
+
+func (c *dockerClient) removeContainer(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/containers/"+id, url.Values{"force": {"1"}}, nil, nil)
+}
+
+// listContainers returns the ids of all containers, running or not, carrying the labels
+func (c *dockerClient) listContainers(ctx context.Context, labels map[string]string) ([]string, error) {
+	var selectors []string
+	for key, value := range labels {
+		selectors = append(selectors, key+"="+value)
+	}
+	filters, err := json.Marshal(map[string][]string{"label": selectors})
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []struct {
+		ID string `json:"Id"`
+	}
+	query := url.Values{"all": {"1"}, "filters": {string(filters)}}
+	if err := c.do(ctx, http.MethodGet, "/containers/json", query, nil, &containers); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(containers))
+	for _, container := range containers {
+		ids = append(ids, container.ID)
+	}
+	return ids, nil
+}
+
+// attachContainer hijacks the connection and returns the raw, multiplexed
+// stdio stream of the container
+func (c *dockerClient) attachContainer(ctx context.Context, id string) (io.ReadWriteCloser, error) {
+	query := url.Values{"stream": {"1"}, "stdin": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+	req, err := c.newRequest(ctx, http.MethodPost, "/containers/"+id+"/attach", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		return nil, readDockerError(resp)
+	}
+
+	stream, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, fmt.Errorf("container engine did not upgrade the attach connection")
+	}
+	return stream, nil
+}
+
+func readDockerError(resp *http.Response) error {
+	var body struct {
+		Message string `json:"message"`
+	}
+	payload, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(payload, &body); err != nil || body.Message == "" {
+		body.Message = strings.TrimSpace(string(payload))
+	}
+	return &dockerError{StatusCode: resp.StatusCode, Message: body.Message}
+}
+
+// demuxDockerStream splits a non-TTY attach stream into stdout and stderr.
+// Each frame is an 8 byte header (stream type, 3 bytes padding, big endian
+// uint32 length) followed by the payload.
+func demuxDockerStream(stream io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(stream, header); err != nil {
+			return err
+		}
+
+		destination := stdout
+		if header[0] == 2 {
+			destination = stderr
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(destination, stream, size); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package runtime
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+type fakeContainer struct {
+	spec    dockerContainerSpec
+	running bool
+	stream  net.Conn
+}
+
+// fakeEngine serves the subset of the Docker Engine API the runtime uses over
+// a unix socket. Attached containers behave like an echo MCP server.
+type fakeEngine struct {
+	mu         sync.Mutex
+	containers map[string]*fakeContainer
+	images     map[string]bool
+	pulls      []string
+	nextID     int
+}
+
+func startFakeEngine(t *testing.T) (*fakeEngine, string) {
+	t.Helper()
+
+	socketPath := filepath.Join(t.TempDir(), "engine.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen on unix socket: %v", err)
+	}
+
+	engine := &fakeEngine{
+		containers: make(map[string]*fakeContainer),
+		images:     make(map[string]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /containers/create", engine.create)
+	mux.HandleFunc("POST /images/create", engine.pull)
+	mux.HandleFunc("POST /containers/{id}/attach", engine.attach)
+	mux.HandleFunc("POST /containers/{id}/start", engine.start)
+	mux.HandleFunc("GET /containers/{id}/json", engine.inspect)
+	mux.HandleFunc("DELETE /containers/{id}", engine.remove)
+	mux.HandleFunc("GET /containers/json", engine.list)
+
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return engine, "unix://" + socketPath
+}
+
+func (e *fakeEngine) addContainer(spec dockerContainerSpec) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.nextID++
+	id := fmt.Sprintf("container-%d", e.nextID)
+	e.containers[id] = &fakeContainer{spec: spec}
+	return id
+}
+
+func (e *fakeEngine) container(r *http.Request) (*fakeContainer, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	container, ok := e.containers[r.PathValue("id")]
+	return container, ok
+}
+
+func writeEngineError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+func (e *fakeEngine) create(w http.ResponseWriter, r *http.Request) {
+	var spec dockerContainerSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writeEngineError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	e.mu.Lock()
+	pulled := e.images[spec.Image]
+	e.mu.Unlock()
+	if !pulled {
+		writeEngineError(w, http.StatusNotFound, "No such image: "+spec.Image)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"Id": e.addContainer(spec)})
+}
+
+func (e *fakeEngine) pull(w http.ResponseWriter, r *http.Request) {
+	image := r.URL.Query().Get("fromImage")
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		image += ":" + tag
+	}
+
+	// Like the engine, failures arrive in the stream after the status
+	if strings.HasPrefix(image, "private/") {
+		fmt.Fprintf(w, "{\"status\":\"Pulling from %s\"}\n{\"errorDetail\":{\"message\":\"pull access denied for %s\"},\"error\":\"pull access denied\"}\n", image, image)
+		return
+	}
+
+	e.mu.Lock()
+	e.images[image] = true
+	e.pulls = append(e.pulls, image)
+	e.mu.Unlock()
+
+	fmt.Fprintf(w, "{\"status\":\"Pulling from %s\"}\n{\"status\":\"Downloaded newer image\"}\n", image)
+}
+
+func (e *fakeEngine) start(w http.ResponseWriter, r *http.Request) {
+	container, ok := e.container(r)
+	if !ok {
+		writeEngineError(w, http.StatusNotFound, "No such container")
+		return
+	}
+
+	e.mu.Lock()
+	container.running = true
+	e.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *fakeEngine) inspect(w http.ResponseWriter, r *http.Request) {
+	container, ok := e.container(r)
+	if !ok {
+		writeEngineError(w, http.StatusNotFound, "No such container")
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{"State": map[string]bool{"Running": container.running}})
+}
+
+func (e *fakeEngine) remove(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	container, ok := e.containers[r.PathValue("id")]
+	if !ok {
+		writeEngineError(w, http.StatusNotFound, "No such container")
+		return
+	}
+	if container.stream != nil {
+		container.stream.Close()
+	}
+	delete(e.containers, r.PathValue("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *fakeEngine) list(w http.ResponseWriter, r *http.Request) {
+	var filters map[string][]string
+	json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	matches := []map[string]string{}
+	for id, container := range e.containers {
+		if containerMatches(container, filters["label"]) {
+			matches = append(matches, map[string]string{"Id": id})
+		}
+	}
+	json.NewEncoder(w).Encode(matches)
+}
+
+func containerMatches(container *fakeContainer, selectors []string) bool {
+	for _, selector := range selectors {
+		key, value, _ := strings.Cut(selector, "=")
+		if container.spec.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *fakeEngine) attach(w http.ResponseWriter, r *http.Request) {
+	container, ok := e.container(r)
+	if !ok {
+		writeEngineError(w, http.StatusNotFound, "No such container")
+		return
+	}
+
+	conn, buffered, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	container.stream = conn
+	e.mu.Unlock()
+
+	fmt.Fprint(conn, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	go serveAttachedEcho(conn, buffered.Reader)
+}
+
+// serveAttachedEcho answers every request with its method and params, framed
+// like a non-TTY attach stream
+func serveAttachedEcho(conn net.Conn, reader *bufio.Reader) {
+	defer conn.Close()
+
+	var writeMu sync.Mutex
+	writeFrame := func(stream byte, payload []byte) {
+		header := make([]byte, 8)
+		header[0] = stream
+		binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.Write(append(header, payload...))
+	}
+
+	writeFrame(2, []byte("echo server listening on stdio\n"))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+
+		var request map[string]interface{}
+		if json.Unmarshal(line, &request) != nil || request["id"] == nil {
+			continue
+		}
+
+		response, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      request["id"],
+			"result":  map[string]interface{}{"method": request["method"], "params": request["params"]},
+		})
+		// Split the response across frames to exercise reassembly
+		half := len(response) / 2
+		writeFrame(1, response[:half])
+		writeFrame(1, append(response[half:], '\n'))
+	}
+}
+
+func TestDockerRuntimeLifecycle(t *testing.T) {
+	engine, host := startFakeEngine(t)
+
+	factory, err := NewDockerRuntimeFactory(host, "mcp-net", log.New(io.Discard))
+	if err != nil {
+		t.Fatalf("failed to create factory: %v", err)
+	}
+	runtime := factory.CreateRuntime("everything", "node:18-alpine", "npx", []string{"-y", "server-everything"}, map[string]string{"TOKEN": "secret"})
+
+	// A container left behind by a previous run must be replaced
+	staleID := engine.addContainer(dockerContainerSpec{
+		Image:  "node:18-alpine",
+		Labels: map[string]string{dockerManagedLabel: "true", dockerNameLabel: "mcp-everything"},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := runtime.Start(ctx); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if !runtime.IsReady() {
+		t.Fatal("runtime should be ready after start")
+	}
+
+	engine.mu.Lock()
+	if _, ok := engine.containers[staleID]; ok {
+		t.Error("stale container was not removed")
+	}
+	if len(engine.pulls) != 1 || engine.pulls[0] != "node:18-alpine" {
+		t.Errorf("expected the missing image to be pulled once, got %v", engine.pulls)
+	}
+	if len(engine.containers) != 1 {
+		t.Fatalf("expected exactly one container, got %d", len(engine.containers))
+	}
+	for _, container := range engine.containers {
+		spec := container.spec
+		if strings.Join(spec.Entrypoint, " ") != "npx" || strings.Join(spec.Cmd, " ") != "-y server-everything" {
+			t.Errorf("unexpected argv: entrypoint=%v cmd=%v", spec.Entrypoint, spec.Cmd)
+		}
+		if !spec.OpenStdin || spec.StdinOnce || spec.Tty {
+			t.Errorf("container must keep a non-TTY stdin open: %+v", spec)
+		}
+		if spec.HostConfig.NetworkMode != "mcp-net" {
+			t.Errorf("expected network mcp-net, got %q", spec.HostConfig.NetworkMode)
+		}
+		if len(spec.Env) != 1 || spec.Env[0] != "TOKEN=secret" {
+			t.Errorf("unexpected env: %v", spec.Env)
+		}
+	}
+	engine.mu.Unlock()
+
+	for i, text := range []string{"hello", "it's $(id)\n"} {
+		request := fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"tools/call","params":{"name":"echo","arguments":{"text":%q}}}`, i, text)
+		output, err := runtime.Exec(ctx, []byte(request))
+		if err != nil {
+			t.Fatalf("exec failed: %v", err)
+		}
+
+		var response struct {
+			ID     int `json:"id"`
+			Result struct {
+				Params struct {
+					Arguments struct {
+						Text string `json:"text"`
+					} `json:"arguments"`
+				} `json:"params"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(output, &response); err != nil {
+			t.Fatalf("invalid response %s: %v", output, err)
+		}
+		if response.ID != i || response.Result.Params.Arguments.Text != text {
+			t.Fatalf("unexpected response: %s", output)
+		}
+	}
+
+	if err := runtime.Stop(ctx); err != nil {
+		t.Fatalf("failed to stop: %v", err)
+	}
+	if runtime.IsReady() {
+		t.Fatal("runtime should not be ready after stop")
+	}
+
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if len(engine.containers) != 0 {
+		t.Fatalf("expected the container to be removed, %d left", len(engine.containers))
+	}
+}
+
+func TestDockerRuntimeFactoryRejectsRemoteHosts(t *testing.T) {
+	if _, err := NewDockerRuntimeFactory("tcp://10.0.0.1:2375", "", log.New(io.Discard)); err == nil {
+		t.Fatal("expected non-unix hosts to be rejected")
+	}
+}
+
+func TestDockerRuntimeNamesContainersByServer(t *testing.T) {
+	engine, host := startFakeEngine(t)
+	factory, err := NewDockerRuntimeFactory(host, "", log.New(io.Discard))
+	if err != nil {
+		t.Fatalf("failed to create factory: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Two servers on the same image must not replace each other's containers
+	for _, name := range []string{"GitHub", "github-readonly"} {
+		runtime := factory.CreateRuntime(name, "node:18-alpine", "npx", nil, nil)
+		if err := runtime.Start(ctx); err != nil {
+			t.Fatalf("failed to start %s: %v", name, err)
+		}
+		defer runtime.Stop(context.Background())
+	}
+
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	names := map[string]bool{}
+	for _, container := range engine.containers {
+		names[container.spec.Labels[dockerNameLabel]] = true
+	}
+	if len(names) != 2 || !names["mcp-github"] || !names["mcp-github-readonly"] {
+		t.Fatalf("expected one container per server, got %v", names)
+	}
+}
+
+func TestDockerRuntimePullsTaggedImages(t *testing.T) {
+	engine, host := startFakeEngine(t)
+	factory, err := NewDockerRuntimeFactory(host, "", log.New(io.Discard))
+	if err != nil {
+		t.Fatalf("failed to create factory: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	runtime := factory.CreateRuntime("registry", "localhost:5000/mcp/server", "", nil, nil)
+	runtime.Start(ctx)
+	defer runtime.Stop(context.Background())
+	engine.mu.Lock()
+	if len(engine.pulls) != 1 || engine.pulls[0] != "localhost:5000/mcp/server:latest" {
+		t.Errorf("expected the latest tag to be pulled, got %v", engine.pulls)
+	}
+	engine.mu.Unlock()
+
+	// Errors reported in the pull stream fail the start
+	err = factory.CreateRuntime("private", "private/server:1.0", "", nil, nil).Start(ctx)
+	if err == nil || !strings.Contains(err.Error(), "pull access denied") {
+		t.Fatalf("expected the pull error, got %v", err)
+	}
+}
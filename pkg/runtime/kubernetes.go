@@ -3,9 +3,11 @@ package runtime
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nsxbet/mcpshield/pkg"
@@ -21,6 +23,7 @@ import (
 
 type KubernetesRuntime struct {
 	client         *kubernetes.Clientset
+	serverName     string
 	config         *KubernetesConfig
 	namespace      string
 	deploymentName string
@@ -28,8 +31,20 @@ type KubernetesRuntime struct {
 	command        string
 	args           []string
 	env            map[string]string
+	session        *stdioSession
+	cancelAttach   context.CancelFunc
+	// attaching is closed once the attach in progress is done
+	attaching chan struct{}
+	// handshake is the initialize request and initialized notification the
+	// server got, replayed when a restarted container is attached to
+	handshake [][]byte
+	handler   pkg.MessageHandler
+	mu        sync.Mutex
 }
 
+// containerName is the name of the MCP server container inside the pod
+const containerName = "mcp-server"
+
 type KubernetesConfig struct {
 	ClientConfig clientcmd.ClientConfig
 }
@@ -65,15 +80,16 @@ func NewKubernetesRuntimeFactoryWithClient(client *kubernetes.Clientset, clientC
 	}
 }
 
-func (f *KubernetesRuntimeFactory) CreateRuntime(image, command string, args []string, env map[string]string) pkg.Runtime {
+func (f *KubernetesRuntimeFactory) CreateRuntime(name, image, command string, args []string, env map[string]string) pkg.Runtime {
 	return &KubernetesRuntime{
-		client:    f.client,
-		config:    f.config,
-		namespace: f.namespace,
-		image:     image,
-		command:   command,
-		args:      args,
-		env:       env,
+		client:     f.client,
+		config:     f.config,
+		namespace:  f.namespace,
+		serverName: name,
+		image:      image,
+		command:    command,
+		args:       args,
+		env:        env,
 	}
 }
 
@@ -106,27 +122,104 @@ func (k *KubernetesRuntime) Start(ctx context.Context) error {
 }
 
 func (k *KubernetesRuntime) Exec(ctx context.Context, input []byte) ([]byte, error) {
+	session, err := k.getSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	
+	output, err := session.Exec(ctx, input)
+	if err == nil {
+		k.recordHandshake(input)
+	}
+	return output, err
+}
+
+// recordHandshake keeps the messages that initialize the server, so that a
+// restarted container can be brought to the same state
+func (k *KubernetesRuntime) recordHandshake(input []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.handshake = appendHandshake(k.handshake, input)
+}
+
+// getSession returns the attached stdio session, re-attaching when the
+// previous stream is gone (e.g. the pod was restarted). Waiting for the pod
+// happens without the lock; concurrent callers share one attach.
+func (k *KubernetesRuntime) getSession(ctx context.Context) (*stdioSession, error) {
+	for {
+		k.mu.Lock()
+		if k.session != nil {
+			select {
+			case <-k.session.Done():
+				k.closeSession()
+			default:
+				session := k.session
+				k.mu.Unlock()
+				return session, nil
+			}
+		}
+		
+		if attaching := k.attaching; attaching != nil {
+			k.mu.Unlock()
+			select {
+			case <-attaching:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		
+		attaching := make(chan struct{})
+		k.attaching = attaching
+		handler, handshake := k.handler, k.handshake
+		k.mu.Unlock()
+		
+		session, cancel, err := k.attach(ctx, handler, handshake)
+		
+		k.mu.Lock()
+		if err == nil {
+			k.session = session
+			k.cancelAttach = cancel
+		}
+		k.attaching = nil
+		close(attaching)
+		k.mu.Unlock()
+		return session, err
+	}
+}
+
+// attach waits for the pod, attaches to it and replays the handshake, which
+// a restarted server has not seen
+func (k *KubernetesRuntime) attach(ctx context.Context, handler pkg.MessageHandler, handshake [][]byte) (*stdioSession, context.CancelFunc, error) {
 	podName, err := k.getPodFromDeployment()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get pod: %w", err)
+		return nil, nil, fmt.Errorf("failed to get pod: %w", err)
 	}
 	
 	if err := k.waitForPodReady(ctx, podName); err != nil {
-		return nil, fmt.Errorf("pod not ready: %w", err)
+		return nil, nil, fmt.Errorf("pod not ready: %w", err)
 	}
 	
-	cmdStr := fmt.Sprintf("%s %s", k.command, strings.Join(k.args, " "))
-	cmd := []string{"sh", "-c", fmt.Sprintf("echo '%s' | %s", string(input), cmdStr)}
-	
-	stdout, stderr, err := k.execInPod(ctx, podName, cmd)
+	session, cancel, err := k.attachToPod(podName, handler)
 	if err != nil {
-		return nil, fmt.Errorf("exec error: %w, stderr: %s", err, stderr)
+		return nil, nil, fmt.Errorf("failed to attach to pod: %w", err)
 	}
 	
-	return []byte(stdout), nil
+	for _, message := range handshake {
+		if _, err := session.Exec(ctx, message); err != nil {
+			session.Close()
+			cancel()
+			return nil, nil, fmt.Errorf("failed to initialize the restarted server: %w", err)
+		}
+	}
+	return session, cancel, nil
 }
 
 func (k *KubernetesRuntime) Stop(ctx context.Context) error {
+	k.mu.Lock()
+	k.closeSession()
+	k.mu.Unlock()
+	
 	if k.deploymentName == "" {
 		return nil
 	}
@@ -196,13 +289,16 @@ func (k *KubernetesRuntime) createDeployment(ctx context.Context) error {
 					RestartPolicy: corev1.RestartPolicyAlways,
 					Containers: []corev1.Container{
 						{
-							Name:    "mcp-server",
+							Name:    containerName,
 							Image:   k.image,
-							Command: []string{k.command},
+							Command: k.containerCommand(),
 							Args:    k.args,
 							Env:     envVars,
-							Stdin:   true,
-							TTY:     true,
+							// Stdin stays open across attaches so the server keeps its
+							// state; no TTY so the JSON-RPC stream is not mangled
+							Stdin:     true,
+							StdinOnce: false,
+							TTY:       false,
 						},
 					},
 				},
@@ -218,6 +314,15 @@ func (k *KubernetesRuntime) createDeployment(ctx context.Context) error {
 	return err
 }
 
+// containerCommand is passed to the container as an argv array, never through
+// a shell; an empty command keeps the image entrypoint
+func (k *KubernetesRuntime) containerCommand() []string {
+	if k.command == "" {
+		return nil
+	}
+	return []string{k.command}
+}
+
 func (k *KubernetesRuntime) waitForDeploymentReady(ctx context.Context) error {
 	timeout := time.After(60 * time.Second)
 	ticker := time.NewTicker(2 * time.Second)
@@ -328,54 +433,99 @@ func (k *KubernetesRuntime) waitForPodReady(ctx context.Context, podName string)
 	}
 }
 
-func (k *KubernetesRuntime) execInPod(ctx context.Context, podName string, cmd []string) (string, string, error) {
+// attachToPod opens a long-lived stdio stream to the MCP server container;
+// the returned function tears the stream down
+func (k *KubernetesRuntime) attachToPod(podName string, handler pkg.MessageHandler) (*stdioSession, context.CancelFunc, error) {
 	req := k.client.CoreV1().RESTClient().Post().
 		Resource("pods").
 		Name(podName).
 		Namespace(k.namespace).
-		SubResource("exec")
+		SubResource("attach")
 	
-	req.VersionedParams(&corev1.PodExecOptions{
-		Command: cmd,
-		Stdin:   false,
-		Stdout:  true,
-		Stderr:  true,
-		TTY:     false,
+	req.VersionedParams(&corev1.PodAttachOptions{
+		Container: containerName,
+		Stdin:     true,
+		Stdout:    true,
+		Stderr:    false,
+		TTY:       false,
 	}, scheme.ParameterCodec)
 	
 	config, err := k.getRESTConfig()
 	if err != nil {
-		return "", "", err
+		return nil, nil, err
 	}
 	
 	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
 	if err != nil {
-		return "", "", err
+		return nil, nil, err
 	}
 	
-	var stdout, stderr strings.Builder
-	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
-		Stdin:  nil,
-		Stdout: &stdout,
-		Stderr: &stderr,
-	})
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	
+	// The stream outlives the request that opened it, so it gets its own context
+	streamCtx, cancel := context.WithCancel(context.Background())
+	
+	go func() {
+		err := exec.StreamWithContext(streamCtx, remotecommand.StreamOptions{
+			Stdin:  stdinReader,
+			Stdout: stdoutWriter,
+		})
+		if err == nil {
+			err = io.EOF
+		}
+		stdoutWriter.CloseWithError(err)
+		stdinReader.CloseWithError(err)
+	}()
 	
-	return stdout.String(), stderr.String(), err
+	return newStdioSession(stdinWriter, stdoutReader, handler), cancel, nil
+}
+
+func (k *KubernetesRuntime) SetMessageHandler(handler pkg.MessageHandler) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.handler = handler
+}
+
+// closeSession tears down the attach stream; callers must hold k.mu
+func (k *KubernetesRuntime) closeSession() {
+	if k.session != nil {
+		k.session.Close()
+		k.session = nil
+	}
+	if k.cancelAttach != nil {
+		k.cancelAttach()
+		k.cancelAttach = nil
+	}
 }
 
 func (k *KubernetesRuntime) getRESTConfig() (*rest.Config, error) {
 	return k.config.ClientConfig.ClientConfig()
 }
 
+// getCleanName names the deployment after the server, so that servers
+// sharing an image get one each
 func (k *KubernetesRuntime) getCleanName() string {
-	// Clean the image name to create a valid deployment name
-	name := strings.ToLower(k.image)
-	name = strings.ReplaceAll(name, ":", "-")
-	name = strings.ReplaceAll(name, "/", "-")
+	return cleanDeploymentName(k.serverName)
+}
+
+// cleanDeploymentName turns a server name into a DNS label, short enough to
+// leave room for the prefix and the suffixes of the pods
+func cleanDeploymentName(serverName string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		default:
+			return '-'
+		}
+	}, serverName)
 	if len(name) > 40 {
 		name = name[:40]
 	}
-	return name
+	return strings.Trim(name, "-")
 }
 
 func CreateKubernetesClient() (*kubernetes.Clientset, clientcmd.ClientConfig, error) {
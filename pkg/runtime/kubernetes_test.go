@@ -0,0 +1,20 @@
+package runtime
+
+import "testing"
+
+func TestCleanDeploymentName(t *testing.T) {
+	tests := []struct {
+		server, want string
+	}{
+		{"github", "github"},
+		{"GitHub_Enterprise", "github-enterprise"},
+		{"slack.bot", "slack-bot"},
+		{"-edge-", "edge"},
+		{"a-very-long-server-name-that-goes-past-the-limit", "a-very-long-server-name-that-goes-past-t"},
+	}
+	for _, tt := range tests {
+		if got := cleanDeploymentName(tt.server); got != tt.want {
+			t.Errorf("cleanDeploymentName(%q) = %q, want %q", tt.server, got, tt.want)
+		}
+	}
+}
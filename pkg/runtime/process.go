@@ -0,0 +1,198 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+// processStopTimeout is how long a process gets to exit after its stdin is
+// closed before it is signalled
+const processStopTimeout = 5 * time.Second
+
+// ProcessRuntime runs an MCP server as a local child process and talks to it
+// over a persistent stdin/stdout pipe
+type ProcessRuntime struct {
+	command string
+	args    []string
+	env     map[string]string
+	workDir string
+	logger  *log.Logger
+	cmd     *exec.Cmd
+	session *stdioSession
+	handler pkg.MessageHandler
+	exited  chan struct{}
+	mu      sync.Mutex
+}
+
+type ProcessRuntimeFactory struct {
+	workDir string
+	logger  *log.Logger
+}
+
+func NewProcessRuntimeFactory(workDir string, logger *log.Logger) pkg.RuntimeFactory {
+	return &ProcessRuntimeFactory{
+		workDir: workDir,
+		logger:  logger,
+	}
+}
+
+func (f *ProcessRuntimeFactory) CreateRuntime(name, image, command string, args []string, env map[string]string) pkg.Runtime {
+	return &ProcessRuntime{
+		command: command,
+		args:    args,
+		env:     env,
+		workDir: f.workDir,
+		logger:  f.logger.With("command", command),
+	}
+}
+
+func (p *ProcessRuntime) Start(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.command == "" {
+		return fmt.Errorf("process runtime requires a command")
+	}
+
+	cmd := exec.Command(p.command, p.args...)
+	cmd.Dir = p.workDir
+	cmd.Env = os.Environ()
+	for key, value := range p.env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, os.ExpandEnv(value)))
+	}
+	setProcessGroup(cmd)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open stdin: %w", err)
+	}
+	// Plain pipes rather than cmd.StdoutPipe, which Wait closes while the
+	// readers may still have output to get through
+	stdout, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to open stdout: %w", err)
+	}
+	stderr, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdout.Close()
+		stdoutWriter.Close()
+		return fmt.Errorf("failed to open stderr: %w", err)
+	}
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
+
+	err = cmd.Start()
+	// The child has its own copies; the readers see EOF once it and anything
+	// it spawned are gone
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if err != nil {
+		stdin.Close()
+		stdout.Close()
+		stderr.Close()
+		return fmt.Errorf("failed to start process: %w", err)
+	}
+
+	go func() {
+		logStderr(p.logger, stderr)
+		stderr.Close()
+	}()
+
+	session := newStdioSession(stdin, stdout, p.handler)
+	go func() {
+		<-session.Done()
+		stdout.Close()
+	}()
+
+	p.cmd = cmd
+	p.session = session
+	p.exited = make(chan struct{})
+
+	go func(exited chan struct{}) {
+		err := cmd.Wait()
+		p.logger.Debug("MCP server process exited", "pid", cmd.Process.Pid, "error", err)
+		close(exited)
+	}(p.exited)
+
+	return nil
+}
+
+func (p *ProcessRuntime) Exec(ctx context.Context, input []byte) ([]byte, error) {
+	p.mu.Lock()
+	session := p.session
+	p.mu.Unlock()
+
+	if session == nil {
+		return nil, fmt.Errorf("process not started")
+	}
+	return session.Exec(ctx, input)
+}
+
+func (p *ProcessRuntime) Stop(ctx context.Context) error {
+	// The lock is not held while the process gets its time to exit
+	p.mu.Lock()
+	cmd, session, exited := p.cmd, p.session, p.exited
+	p.cmd = nil
+	p.mu.Unlock()
+
+	if cmd == nil {
+		return nil
+	}
+	// Whatever the server spawned may outlive it and keep its pipes open
+	defer signalProcessGroup(cmd, true)
+
+	// Closing stdin is the polite way to ask a stdio server to exit
+	session.Close()
+	if waitForExit(ctx, exited, processStopTimeout) {
+		return nil
+	}
+
+	signalProcessGroup(cmd, false)
+	if waitForExit(ctx, exited, processStopTimeout) {
+		return nil
+	}
+
+	signalProcessGroup(cmd, true)
+	<-exited
+	return nil
+}
+
+func (p *ProcessRuntime) IsReady() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cmd == nil {
+		return false
+	}
+
+	select {
+	case <-p.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *ProcessRuntime) SetMessageHandler(handler pkg.MessageHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handler = handler
+}
+
+func waitForExit(ctx context.Context, exited <-chan struct{}, timeout time.Duration) bool {
+	select {
+	case <-exited:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-time.After(timeout):
+		return false
+	}
+}
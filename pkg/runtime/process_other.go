@@ -0,0 +1,18 @@
+//go:build !unix
+
+package runtime
+
+import (
+	"os"
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func signalProcessGroup(cmd *exec.Cmd, kill bool) {
+	if kill {
+		cmd.Process.Kill()
+		return
+	}
+	cmd.Process.Signal(os.Interrupt)
+}
@@ -0,0 +1,65 @@
+//go:build unix
+
+package runtime
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+func TestProcessRuntimeStopsWhatTheServerSpawned(t *testing.T) {
+	factory := NewProcessRuntimeFactory(t.TempDir(), log.New(io.Discard))
+	// The background sleep holds on to stdout after the shell exits
+	runtime := factory.CreateRuntime("echo", "", "sh", []string{"-c", "sleep 60 & exec cat"}, nil).(*ProcessRuntime)
+	if err := runtime.Start(context.Background()); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	session := runtime.session
+
+	response, err := runtime.Exec(context.Background(), []byte(`{"jsonrpc":"2.0","method":"notifications/initialized"}`))
+	if err != nil || response != nil {
+		t.Fatalf("expected the notification to be written, got %s, %v", response, err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		runtime.Stop(context.Background())
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the process to stop")
+	}
+	select {
+	case <-session.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected stdout to close once the spawned processes are gone")
+	}
+}
+
+func TestProcessRuntimeKeepsReadingLongStderrLines(t *testing.T) {
+	factory := NewProcessRuntimeFactory(t.TempDir(), log.New(io.Discard))
+	// More than a pipe buffer of over-long lines, then a message on stdout
+	script := `for i in 1 2 3 4 5 6; do head -c 200000 /dev/zero | tr '\0' a >&2; echo >&2; done
+echo '{"jsonrpc":"2.0","method":"notifications/message","params":{}}'
+exec cat`
+	runtime := factory.CreateRuntime("noisy", "", "sh", []string{"-c", script}, nil)
+	messages := make(chan []byte, 1)
+	runtime.SetMessageHandler(func(message []byte) { messages <- message })
+	if err := runtime.Start(context.Background()); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer runtime.Stop(context.Background())
+
+	select {
+	case <-messages:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected stdout to keep flowing past the long stderr lines")
+	}
+}
@@ -0,0 +1,724 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nsxbet/mcpshield/pkg"
+	"github.com/nsxbet/mcpshield/pkg/sse"
+)
+
+const (
+	sessionIDHeader       = "Mcp-Session-Id"
+	protocolVersionHeader = "MCP-Protocol-Version"
+	// sseReconnectTimeout bounds one attempt to bring back a dropped legacy
+	// SSE stream, handshake included
+	sseReconnectTimeout = 30 * time.Second
+)
+
+// RemoteRuntime talks to an MCP server that is already hosted elsewhere, over
+// the Streamable HTTP transport or the legacy HTTP+SSE transport
+type RemoteRuntime struct {
+	url       string
+	transport string
+	headers   map[string]string
+	client    *http.Client
+	sessionID string
+	started   bool
+	handler   pkg.MessageHandler
+	// nextID numbers requests so that callers reusing ids do not collide upstream
+	nextID atomic.Int64
+	// protocolVersion is the version the server answered initialize with; it
+	// accompanies every later request
+	protocolVersion atomic.Value
+	// cancelListen stops the standalone GET stream of the Streamable HTTP transport
+	cancelListen context.CancelFunc
+	// sseSession carries messages for the legacy SSE transport
+	sseSession *stdioSession
+	// stopReconnect stops bringing back the legacy SSE stream after drops
+	stopReconnect chan struct{}
+	// handshake is the initialize request and initialized notification the
+	// server got, replayed when the server forgets the session
+	handshake [][]byte
+	mu        sync.Mutex
+	// reconnectMu serializes bringing back a session the server lost, so that
+	// concurrent callers do not each start one
+	reconnectMu sync.Mutex
+}
+
+// RemoteRuntimeFactory creates remote runtimes and delegates everything else
+// to the configured local runtime factory, which may be nil when every server
+// is remote
+type RemoteRuntimeFactory struct {
+	local  pkg.RuntimeFactory
+	client *http.Client
+}
+
+func NewRemoteRuntimeFactory(local pkg.RuntimeFactory) *RemoteRuntimeFactory {
+	return &RemoteRuntimeFactory{
+		local:  local,
+		client: &http.Client{},
+	}
+}
+
+func (f *RemoteRuntimeFactory) CreateRuntime(name, image, command string, args []string, env map[string]string) pkg.Runtime {
+	if f.local == nil {
+		return &unavailableRuntime{}
+	}
+	return f.local.CreateRuntime(name, image, command, args, env)
+}
+
+func (f *RemoteRuntimeFactory) CreateRemoteRuntime(url, transport string, headers map[string]string) pkg.Runtime {
+	if transport == "" {
+		transport = pkg.TransportStreamableHTTP
+	}
+
+	expandedHeaders := make(map[string]string, len(headers))
+	for key, value := range headers {
+		expandedHeaders[key] = os.ExpandEnv(value)
+	}
+
+	return &RemoteRuntime{
+		url:       url,
+		transport: transport,
+		headers:   expandedHeaders,
+		client:    f.client,
+	}
+}
+
+func (r *RemoteRuntime) Start(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch r.transport {
+	case pkg.TransportStreamableHTTP:
+		// Streamable HTTP is connectionless until the first request
+		r.started = true
+		return nil
+	case pkg.TransportSSE:
+		session, err := r.connectSSE(ctx, r.handler)
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s: %w", r.url, err)
+		}
+		r.sseSession = session
+		r.started = true
+		r.stopReconnect = make(chan struct{})
+		go r.reconnectSSE(session, r.stopReconnect)
+		return nil
+	default:
+		return fmt.Errorf("unsupported transport %q", r.transport)
+	}
+}
+
+func (r *RemoteRuntime) Exec(ctx context.Context, input []byte) ([]byte, error) {
+	r.mu.Lock()
+	started, sseSession := r.started, r.sseSession
+	r.mu.Unlock()
+
+	if !started {
+		return nil, fmt.Errorf("remote runtime not started")
+	}
+
+	var output []byte
+	var err error
+	if sseSession != nil {
+		output, err = r.execSSE(ctx, input)
+	} else {
+		output, err = r.post(ctx, input, true)
+	}
+	if err == nil {
+		r.recordHandshake(input)
+	}
+	return output, err
+}
+
+// recordHandshake keeps the messages that initialize the server, so that a
+// new session can be brought to the same state
+func (r *RemoteRuntime) recordHandshake(input []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handshake = appendHandshake(r.handshake, input)
+}
+
+// execSSE sends a message over the legacy SSE transport, reconnecting first
+// when the stream dropped. A dropped stream fails the calls waiting on it.
+func (r *RemoteRuntime) execSSE(ctx context.Context, input []byte) ([]byte, error) {
+	session, err := r.getSSESession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return session.Exec(ctx, input)
+}
+
+// getSSESession returns the legacy SSE session, opening a new stream and
+// replaying the handshake when the previous stream is gone: the server ties
+// its session to the stream.
+func (r *RemoteRuntime) getSSESession(ctx context.Context) (*stdioSession, error) {
+	r.mu.Lock()
+	session := r.sseSession
+	r.mu.Unlock()
+	if session != nil && !isDone(session) {
+		return session, nil
+	}
+
+	r.reconnectMu.Lock()
+	defer r.reconnectMu.Unlock()
+
+	r.mu.Lock()
+	started, session, handler, handshake := r.started, r.sseSession, r.handler, r.handshake
+	r.mu.Unlock()
+	if !started {
+		return nil, fmt.Errorf("remote runtime not started")
+	}
+	if session != nil && !isDone(session) {
+		// Another caller reconnected meanwhile
+		return session, nil
+	}
+	if session != nil {
+		session.Close()
+	}
+
+	session, err := r.connectSSE(ctx, handler)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect to %s: %w", r.url, err)
+	}
+	for _, message := range handshake {
+		if _, err := session.Exec(ctx, message); err != nil {
+			session.Close()
+			return nil, fmt.Errorf("failed to initialize the new session: %w", err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started {
+		session.Close()
+		return nil, fmt.Errorf("remote runtime not started")
+	}
+	r.sseSession = session
+	return session, nil
+}
+
+// reconnectSSE brings the legacy SSE stream back whenever it drops, until the
+// runtime stops. Meanwhile the runtime is not ready, and the calls that were
+// waiting on the dropped stream have failed with it.
+func (r *RemoteRuntime) reconnectSSE(session *stdioSession, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-session.Done():
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), sseReconnectTimeout)
+		next, err := r.getSSESession(ctx)
+		cancel()
+		if err == nil {
+			session = next
+			continue
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func isDone(session *stdioSession) bool {
+	select {
+	case <-session.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *RemoteRuntime) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.started = false
+	if r.cancelListen != nil {
+		r.cancelListen()
+		r.cancelListen = nil
+	}
+	if r.stopReconnect != nil {
+		close(r.stopReconnect)
+		r.stopReconnect = nil
+	}
+	if r.sseSession != nil {
+		r.sseSession.Close()
+		r.sseSession = nil
+	}
+
+	if r.sessionID == "" {
+		return nil
+	}
+
+	// Servers that do not support explicit termination answer 405, which is fine
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, r.url, nil)
+	if err != nil {
+		return err
+	}
+	r.setHeaders(req, r.sessionID)
+	r.sessionID = ""
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to terminate session: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (r *RemoteRuntime) IsReady() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.started {
+		return false
+	}
+	return r.sseSession == nil || !isDone(r.sseSession)
+}
+
+func (r *RemoteRuntime) SetMessageHandler(handler pkg.MessageHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handler = handler
+}
+
+// deliver passes a server-initiated message to the handler
+func (r *RemoteRuntime) deliver(message []byte) {
+	r.mu.Lock()
+	handler := r.handler
+	r.mu.Unlock()
+
+	if handler != nil {
+		handler(message)
+	}
+}
+
+func (r *RemoteRuntime) setHeaders(req *http.Request, sessionID string) {
+	for key, value := range r.headers {
+		req.Header.Set(key, value)
+	}
+	if sessionID != "" {
+		req.Header.Set(sessionIDHeader, sessionID)
+	}
+	if version, _ := r.protocolVersion.Load().(string); version != "" {
+		req.Header.Set(protocolVersionHeader, version)
+	}
+}
+
+// sessionExpiredError reports that the server no longer knows the session
+type sessionExpiredError struct {
+	sessionID string
+}
+
+func (e *sessionExpiredError) Error() string {
+	return fmt.Sprintf("remote session %s expired", e.sessionID)
+}
+
+// post sends one message with the Streamable HTTP transport. The response is
+// either a JSON body or an SSE stream that ends with the matching response.
+// Requests get a runtime-unique id upstream and the caller's id is restored on
+// the response, as with stdio. When the server forgot the session and
+// reinitialize is set, the handshake is replayed on a new session and the
+// message is sent once more.
+func (r *RemoteRuntime) post(ctx context.Context, input []byte, reinitialize bool) ([]byte, error) {
+	var message map[string]json.RawMessage
+	if err := json.Unmarshal(input, &message); err != nil {
+		return nil, fmt.Errorf("invalid JSON-RPC message: %w", err)
+	}
+
+	originalID, hasID := message["id"]
+	_, hasMethod := message["method"]
+	isRequest := hasID && string(originalID) != "null" && hasMethod
+
+	var upstreamID int64
+	if isRequest {
+		upstreamID = r.nextID.Add(1)
+		message["id"] = json.RawMessage(fmt.Sprintf("%d", upstreamID))
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	output, err := r.send(ctx, payload, isRequest, message["id"])
+	var expired *sessionExpiredError
+	if errors.As(err, &expired) && reinitialize {
+		// A new initialize starts the new session by itself
+		if string(message["method"]) != `"initialize"` {
+			if err := r.reinitialize(ctx); err != nil {
+				return nil, fmt.Errorf("%w: %w", expired, err)
+			}
+		}
+		output, err = r.send(ctx, payload, isRequest, message["id"])
+	}
+	if err != nil {
+		if isRequest && ctx.Err() != nil {
+			// Dropping the connection is not a cancellation, so say it explicitly
+			go r.post(context.Background(), cancelledNotification(upstreamID, ctx.Err()), false)
+		}
+		return nil, err
+	}
+	if output == nil {
+		return nil, nil
+	}
+	if string(message["method"]) == `"initialize"` {
+		r.recordProtocolVersion(output)
+	}
+	return restoreID(output, originalID)
+}
+
+// reinitialize replays the handshake to start a new session, unless another
+// caller already did since the session expired
+func (r *RemoteRuntime) reinitialize(ctx context.Context) error {
+	r.reconnectMu.Lock()
+	defer r.reconnectMu.Unlock()
+
+	r.mu.Lock()
+	sessionID, handshake := r.sessionID, r.handshake
+	r.mu.Unlock()
+	if sessionID != "" {
+		return nil
+	}
+
+	for _, message := range handshake {
+		if _, err := r.post(ctx, message, false); err != nil {
+			return fmt.Errorf("failed to initialize a new session: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *RemoteRuntime) recordProtocolVersion(response []byte) {
+	var initialized struct {
+		Result struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		} `json:"result"`
+	}
+	if json.Unmarshal(response, &initialized) == nil && initialized.Result.ProtocolVersion != "" {
+		r.protocolVersion.Store(initialized.Result.ProtocolVersion)
+	}
+}
+
+// send POSTs the payload and returns the response to id, if it is a request
+func (r *RemoteRuntime) send(ctx context.Context, payload []byte, isRequest bool, id json.RawMessage) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+
+	r.mu.Lock()
+	sessionID := r.sessionID
+	r.mu.Unlock()
+	r.setHeaders(req, sessionID)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %w", r.url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && sessionID != "" {
+		// The server forgot our session; a late answer must not forget the
+		// session that replaced it
+		r.mu.Lock()
+		if r.sessionID == sessionID {
+			r.sessionID = ""
+		}
+		r.mu.Unlock()
+		return nil, &sessionExpiredError{sessionID: sessionID}
+	}
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("remote server returned %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	if newSessionID := resp.Header.Get(sessionIDHeader); newSessionID != "" && newSessionID != sessionID {
+		r.mu.Lock()
+		r.sessionID = newSessionID
+		r.listenLocked(newSessionID)
+		r.mu.Unlock()
+	}
+
+	if resp.StatusCode == http.StatusAccepted || !isRequest {
+		return nil, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/event-stream" {
+		return io.ReadAll(resp.Body)
+	}
+	// Requests the server sends on this stream belong to this request
+	deliver := r.deliver
+	if handler, ok := pkg.MessageHandlerFromContext(ctx); ok {
+		deliver = handler
+	}
+	return readSSEResponse(resp.Body, id, deliver)
+}
+
+// listenLocked opens the standalone GET stream for a new session so that
+// server-initiated messages arrive even when no request is in flight.
+// Callers must hold r.mu.
+func (r *RemoteRuntime) listenLocked(sessionID string) {
+	if r.cancelListen != nil {
+		r.cancelListen()
+		r.cancelListen = nil
+	}
+	if r.handler == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancelListen = cancel
+	go r.listen(ctx, sessionID)
+}
+
+// listen keeps the standalone stream open, reconnecting after drops, until
+// the session changes or the server says it does not offer one
+func (r *RemoteRuntime) listen(ctx context.Context, sessionID string) {
+	for r.openStream(ctx, sessionID) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// openStream reads the standalone stream until it ends and reports whether
+// reconnecting is worthwhile
+func (r *RemoteRuntime) openStream(ctx context.Context, sessionID string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	r.setHeaders(req, sessionID)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil
+	}
+	defer resp.Body.Close()
+
+	// 405 means no standalone stream, 404 means the session is gone
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	reader := sse.NewReader(resp.Body)
+	for {
+		event, err := reader.Next()
+		if err != nil {
+			return ctx.Err() == nil
+		}
+		if event.Event == "" || event.Event == "message" {
+			r.deliver([]byte(event.Data))
+		}
+	}
+}
+
+// readSSEResponse reads events until the response for id arrives, handing
+// the messages the server sends meanwhile to deliver
+func readSSEResponse(body io.Reader, id json.RawMessage, deliver pkg.MessageHandler) ([]byte, error) {
+	reader := sse.NewReader(body)
+	for {
+		event, err := reader.Next()
+		if err != nil {
+			return nil, fmt.Errorf("stream ended before the response: %w", err)
+		}
+
+		var message struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		if err := json.Unmarshal([]byte(event.Data), &message); err != nil {
+			continue
+		}
+		if message.Method != "" {
+			deliver([]byte(event.Data))
+			continue
+		}
+		if bytes.Equal(message.ID, id) {
+			return []byte(event.Data), nil
+		}
+	}
+}
+
+// connectSSE opens the legacy SSE stream and waits for the endpoint event
+// that tells where to POST messages. Responses arrive on the stream, so the
+// stdio session machinery is reused for id correlation.
+func (r *RemoteRuntime) connectSSE(ctx context.Context, handler pkg.MessageHandler) (*stdioSession, error) {
+	streamCtx, cancel := context.WithCancel(context.Background())
+
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, r.url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	r.setHeaders(req, "")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("remote server returned %d", resp.StatusCode)
+	}
+
+	reader := sse.NewReader(resp.Body)
+	endpoint, err := waitForEndpoint(ctx, reader, resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		cancel()
+		return nil, err
+	}
+
+	postURL, err := url.Parse(r.url)
+	if err != nil {
+		resp.Body.Close()
+		cancel()
+		return nil, err
+	}
+	baseURL := postURL
+	postURL, err = postURL.Parse(endpoint)
+	if err != nil {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+	// The configured headers carry credentials, so messages only go where the
+	// stream came from
+	if postURL.Scheme != baseURL.Scheme || postURL.Host != baseURL.Host {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("endpoint %q is not on %s://%s", endpoint, baseURL.Scheme, baseURL.Host)
+	}
+
+	messages, messagesWriter := io.Pipe()
+	go func() {
+		defer resp.Body.Close()
+		for {
+			event, err := reader.Next()
+			if err != nil {
+				messagesWriter.CloseWithError(err)
+				return
+			}
+			if event.Event != "" && event.Event != "message" {
+				continue
+			}
+			messagesWriter.Write(append([]byte(event.Data), '\n'))
+		}
+	}()
+
+	poster := &ssePoster{runtime: r, endpoint: postURL.String(), cancel: cancel}
+	return newStdioSession(poster, messages, handler), nil
+}
+
+func waitForEndpoint(ctx context.Context, reader *sse.Reader, body io.Closer) (string, error) {
+	type result struct {
+		endpoint string
+		err      error
+	}
+	resultCh := make(chan result, 1)
+
+	go func() {
+		for {
+			event, err := reader.Next()
+			if err != nil {
+				resultCh <- result{err: fmt.Errorf("stream ended before the endpoint event: %w", err)}
+				return
+			}
+			if event.Event == "endpoint" {
+				resultCh <- result{endpoint: event.Data}
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		body.Close()
+		return "", ctx.Err()
+	case res := <-resultCh:
+		return res.endpoint, res.err
+	}
+}
+
+// ssePoster delivers each line written by the stdio session as a POST to the
+// endpoint announced by the legacy SSE transport
+type ssePoster struct {
+	runtime  *RemoteRuntime
+	endpoint string
+	cancel   context.CancelFunc
+}
+
+func (p *ssePoster) Write(line []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, p.endpoint, bytes.NewReader(bytes.TrimSpace(line)))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	p.runtime.setHeaders(req, "")
+
+	resp, err := p.runtime.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("remote server returned %d", resp.StatusCode)
+	}
+	return len(line), nil
+}
+
+func (p *ssePoster) Close() error {
+	p.cancel()
+	return nil
+}
+
+// unavailableRuntime stands in for local servers when no local runtime is configured
+type unavailableRuntime struct{}
+
+func (u *unavailableRuntime) Start(ctx context.Context) error {
+	return fmt.Errorf("no local runtime configured (runtime.kubernetes, runtime.docker or runtime.process)")
+}
+
+func (u *unavailableRuntime) Exec(ctx context.Context, input []byte) ([]byte, error) {
+	return nil, fmt.Errorf("no local runtime configured")
+}
+
+func (u *unavailableRuntime) Stop(ctx context.Context) error {
+	return nil
+}
+
+func (u *unavailableRuntime) IsReady() bool {
+	return false
+}
+
+func (u *unavailableRuntime) SetMessageHandler(handler pkg.MessageHandler) {}
@@ -0,0 +1,495 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+// fakeRemoteServer serves both MCP HTTP transports: Streamable HTTP on /mcp
+// and the legacy HTTP+SSE transport on /sse and /messages
+type fakeRemoteServer struct {
+	t          *testing.T
+	mu         sync.Mutex
+	terminated bool
+	sseOut     chan []byte
+	// getOut feeds the standalone GET stream of the Streamable HTTP transport
+	getOut chan []byte
+	// received records the messages POSTed on the session, when there is room
+	received chan map[string]interface{}
+	// protocolVersion is the version header of the last request
+	protocolVersion string
+	// session is the Streamable HTTP session the server knows, if any
+	session  string
+	sessions int
+	// endpoint is announced on the legacy SSE stream
+	endpoint string
+	// dropSSE ends the legacy SSE stream
+	dropSSE chan struct{}
+	// initializes counts the initialize requests on either transport
+	initializes int
+}
+
+func startFakeRemoteServer(t *testing.T) (*httptest.Server, *fakeRemoteServer) {
+	t.Helper()
+
+	remote := &fakeRemoteServer{
+		t:        t,
+		sseOut:   make(chan []byte, 16),
+		getOut:   make(chan []byte, 16),
+		received: make(chan map[string]interface{}, 16),
+		endpoint: "/messages?sessionId=abc",
+		dropSSE:  make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mcp", remote.streamable)
+	mux.HandleFunc("GET /sse", remote.sseStream)
+	mux.HandleFunc("POST /messages", remote.sseMessages)
+
+	server := httptest.NewServer(remote.requireAPIKey(mux))
+	t.Cleanup(server.Close)
+	return server, remote
+}
+
+func (f *fakeRemoteServer) requireAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "expanded-secret" {
+			http.Error(w, "missing api key", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// expireSession makes the server forget the Streamable HTTP session
+func (f *fakeRemoteServer) expireSession() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.session = ""
+}
+
+func (f *fakeRemoteServer) knows(r *http.Request) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.session != "" && r.Header.Get(sessionIDHeader) == f.session
+}
+
+func echoResult(request map[string]interface{}) []byte {
+	response, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      request["id"],
+		"result":  map[string]interface{}{"method": request["method"]},
+	})
+	return response
+}
+
+func (f *fakeRemoteServer) streamable(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		f.mu.Lock()
+		f.terminated = r.Header.Get(sessionIDHeader) == f.session
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method == http.MethodGet {
+		if !f.knows(r) {
+			http.Error(w, "unknown session", http.StatusNotFound)
+			return
+		}
+		f.serveEvents(w, r, f.getOut, nil)
+		return
+	}
+
+	var request map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&request)
+
+	f.mu.Lock()
+	f.protocolVersion = r.Header.Get(protocolVersionHeader)
+	f.mu.Unlock()
+
+	if request["method"] == "initialize" {
+		f.mu.Lock()
+		f.initializes++
+		f.sessions++
+		f.session = fmt.Sprintf("session-%d", f.sessions)
+		session := f.session
+		f.mu.Unlock()
+
+		response, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      request["id"],
+			"result":  map[string]interface{}{"method": "initialize", "protocolVersion": "2025-06-18"},
+		})
+		w.Header().Set(sessionIDHeader, session)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+		return
+	}
+
+	if !f.knows(r) {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+	select {
+	case f.received <- request:
+	default:
+	}
+	if request["method"] == "slow" {
+		<-r.Context().Done()
+		return
+	}
+	if request["id"] == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if request["method"] != "tools/call" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(echoResult(request))
+		return
+	}
+
+	// Long calls stream: a notification first, then the response
+	w.Header().Set("Content-Type", "text/event-stream")
+	fmt.Fprintf(w, "event: message\ndata: %s\n\n", `{"jsonrpc":"2.0","method":"notifications/progress","params":{"progress":1}}`)
+	w.(http.Flusher).Flush()
+	fmt.Fprintf(w, "event: message\ndata: %s\n\n", echoResult(request))
+}
+
+func (f *fakeRemoteServer) sseStream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	fmt.Fprintf(w, "event: endpoint\ndata: %s\n\n", f.endpoint)
+	f.serveEvents(w, r, f.sseOut, f.dropSSE)
+}
+
+func (f *fakeRemoteServer) serveEvents(w http.ResponseWriter, r *http.Request, messages chan []byte, drop chan struct{}) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.(http.Flusher).Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-drop:
+			return
+		case message := <-messages:
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", message)
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+func (f *fakeRemoteServer) sseMessages(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("sessionId") != "abc" {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+
+	var request map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&request)
+	if request["method"] == "initialize" {
+		f.mu.Lock()
+		f.initializes++
+		f.mu.Unlock()
+	}
+	if request["id"] != nil {
+		f.sseOut <- echoResult(request)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func execMethod(t *testing.T, runtime pkg.Runtime, id int, method string) string {
+	t.Helper()
+
+	request := fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":%q}`, id, method)
+	output, err := runtime.Exec(context.Background(), []byte(request))
+	if err != nil {
+		t.Fatalf("%s failed: %v", method, err)
+	}
+
+	var response struct {
+		ID     int `json:"id"`
+		Result struct {
+			Method string `json:"method"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(output, &response); err != nil {
+		t.Fatalf("%s: invalid response %s: %v", method, output, err)
+	}
+	if response.ID != id {
+		t.Fatalf("%s: expected id %d, got %d", method, id, response.ID)
+	}
+	return response.Result.Method
+}
+
+func TestRemoteRuntimeStreamableHTTP(t *testing.T) {
+	t.Setenv("TEST_REMOTE_KEY", "expanded-secret")
+	server, remote := startFakeRemoteServer(t)
+
+	factory := NewRemoteRuntimeFactory(nil)
+	runtime := factory.CreateRemoteRuntime(server.URL+"/mcp", pkg.TransportStreamableHTTP, map[string]string{"X-Api-Key": "${TEST_REMOTE_KEY}"})
+
+	if err := runtime.Start(context.Background()); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if !runtime.IsReady() {
+		t.Fatal("runtime should be ready after start")
+	}
+
+	execMethod(t, runtime, 1, "initialize")
+
+	// The session id handed out on initialize must be sent from now on
+	if _, err := runtime.Exec(context.Background(), []byte(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)); err != nil {
+		t.Fatalf("notification failed: %v", err)
+	}
+	if method := execMethod(t, runtime, 2, "tools/list"); method != "tools/list" {
+		t.Fatalf("unexpected JSON response for %s", method)
+	}
+	remote.mu.Lock()
+	if remote.protocolVersion != "2025-06-18" {
+		t.Fatalf("expected the negotiated protocol version header, got %q", remote.protocolVersion)
+	}
+	remote.mu.Unlock()
+	if method := execMethod(t, runtime, 3, "tools/call"); method != "tools/call" {
+		t.Fatalf("unexpected SSE response for %s", method)
+	}
+
+	if err := runtime.Stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop: %v", err)
+	}
+
+	remote.mu.Lock()
+	defer remote.mu.Unlock()
+	if !remote.terminated {
+		t.Fatal("expected the session to be terminated with DELETE on stop")
+	}
+}
+
+func TestRemoteRuntimeDeliversServerMessages(t *testing.T) {
+	t.Setenv("TEST_REMOTE_KEY", "expanded-secret")
+	server, remote := startFakeRemoteServer(t)
+
+	factory := NewRemoteRuntimeFactory(nil)
+	runtime := factory.CreateRemoteRuntime(server.URL+"/mcp", pkg.TransportStreamableHTTP, map[string]string{"X-Api-Key": "${TEST_REMOTE_KEY}"})
+
+	messages := make(chan string, 16)
+	runtime.SetMessageHandler(func(message []byte) {
+		var envelope struct {
+			Method string `json:"method"`
+		}
+		json.Unmarshal(message, &envelope)
+		messages <- envelope.Method
+	})
+	if err := runtime.Start(context.Background()); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer runtime.Stop(context.Background())
+
+	expect := func(method string) {
+		t.Helper()
+		select {
+		case got := <-messages:
+			if got != method {
+				t.Fatalf("expected %s, got %s", method, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", method)
+		}
+	}
+
+	execMethod(t, runtime, 1, "initialize")
+
+	// Messages interleaved with a streamed response
+	execMethod(t, runtime, 2, "tools/call")
+	expect("notifications/progress")
+
+	// or to the request's own handler, which ties them to the request
+	own := make(chan []byte, 16)
+	ctx := pkg.WithMessageHandler(context.Background(), func(message []byte) { own <- message })
+	if _, err := runtime.Exec(ctx, []byte(`{"jsonrpc":"2.0","id":3,"method":"tools/call"}`)); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if len(own) != 1 || len(messages) != 0 {
+		t.Fatalf("expected the progress on the request's handler, got %d there and %d on the runtime's", len(own), len(messages))
+	}
+
+	// Messages on the standalone stream, with no request in flight
+	remote.getOut <- []byte(`{"jsonrpc":"2.0","method":"notifications/resources/updated","params":{"uri":"file:///a"}}`)
+	expect("notifications/resources/updated")
+}
+
+func TestRemoteRuntimeCancelsAbandonedRequests(t *testing.T) {
+	t.Setenv("TEST_REMOTE_KEY", "expanded-secret")
+	server, remote := startFakeRemoteServer(t)
+
+	factory := NewRemoteRuntimeFactory(nil)
+	runtime := factory.CreateRemoteRuntime(server.URL+"/mcp", pkg.TransportStreamableHTTP, map[string]string{"X-Api-Key": "${TEST_REMOTE_KEY}"})
+	if err := runtime.Start(context.Background()); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer runtime.Stop(context.Background())
+	execMethod(t, runtime, 1, "initialize")
+
+	next := func() map[string]interface{} {
+		t.Helper()
+		select {
+		case message := <-remote.received:
+			return message
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a message")
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := runtime.Exec(ctx, []byte(`{"jsonrpc":"2.0","id":1,"method":"slow"}`))
+		errs <- err
+	}()
+
+	slow := next()
+	cancel()
+	if err := <-errs; err == nil {
+		t.Fatal("expected the abandoned request to fail")
+	}
+
+	cancelled := next()
+	if cancelled["method"] != "notifications/cancelled" {
+		t.Fatalf("expected notifications/cancelled, got %v", cancelled)
+	}
+	params := cancelled["params"].(map[string]interface{})
+	if params["requestId"] != slow["id"] {
+		t.Fatalf("expected the upstream id %v, got %v", slow["id"], params["requestId"])
+	}
+}
+
+func TestRemoteRuntimeLegacySSE(t *testing.T) {
+	t.Setenv("TEST_REMOTE_KEY", "expanded-secret")
+	server, _ := startFakeRemoteServer(t)
+
+	factory := NewRemoteRuntimeFactory(nil)
+	runtime := factory.CreateRemoteRuntime(server.URL+"/sse", pkg.TransportSSE, map[string]string{"X-Api-Key": "${TEST_REMOTE_KEY}"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := runtime.Start(ctx); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer runtime.Stop(context.Background())
+
+	if !runtime.IsReady() {
+		t.Fatal("runtime should be ready after start")
+	}
+	if method := execMethod(t, runtime, 1, "initialize"); method != "initialize" {
+		t.Fatalf("unexpected response for %s", method)
+	}
+	if method := execMethod(t, runtime, 1, "tools/list"); method != "tools/list" {
+		t.Fatalf("unexpected response for %s", method)
+	}
+}
+
+func TestRemoteRuntimeFactoryWithoutLocalRuntime(t *testing.T) {
+	factory := NewRemoteRuntimeFactory(nil)
+	runtime := factory.CreateRuntime("everything", "node:18-alpine", "npx", nil, nil)
+	if err := runtime.Start(context.Background()); err == nil {
+		t.Fatal("expected local servers to fail without a local runtime")
+	}
+}
+
+func TestRemoteRuntimeReinitializesExpiredSession(t *testing.T) {
+	t.Setenv("TEST_REMOTE_KEY", "expanded-secret")
+	server, remote := startFakeRemoteServer(t)
+
+	factory := NewRemoteRuntimeFactory(nil)
+	runtime := factory.CreateRemoteRuntime(server.URL+"/mcp", pkg.TransportStreamableHTTP, map[string]string{"X-Api-Key": "${TEST_REMOTE_KEY}"})
+	if err := runtime.Start(context.Background()); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer runtime.Stop(context.Background())
+
+	execMethod(t, runtime, 1, "initialize")
+	if _, err := runtime.Exec(context.Background(), []byte(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)); err != nil {
+		t.Fatalf("notification failed: %v", err)
+	}
+	<-remote.received
+
+	remote.expireSession()
+	if method := execMethod(t, runtime, 2, "tools/list"); method != "tools/list" {
+		t.Fatalf("unexpected response for %s", method)
+	}
+
+	// The new session saw the whole handshake before the retried request
+	for _, want := range []string{"notifications/initialized", "tools/list"} {
+		select {
+		case message := <-remote.received:
+			if message["method"] != want {
+				t.Fatalf("expected %s on the new session, got %v", want, message["method"])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+	remote.mu.Lock()
+	defer remote.mu.Unlock()
+	if remote.initializes != 2 {
+		t.Fatalf("expected the handshake to be replayed once, got %d initializes", remote.initializes)
+	}
+}
+
+func TestRemoteRuntimeLegacySSEReconnects(t *testing.T) {
+	t.Setenv("TEST_REMOTE_KEY", "expanded-secret")
+	server, remote := startFakeRemoteServer(t)
+
+	factory := NewRemoteRuntimeFactory(nil)
+	runtime := factory.CreateRemoteRuntime(server.URL+"/sse", pkg.TransportSSE, map[string]string{"X-Api-Key": "${TEST_REMOTE_KEY}"})
+	if err := runtime.Start(context.Background()); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer runtime.Stop(context.Background())
+
+	execMethod(t, runtime, 1, "initialize")
+	remote.dropSSE <- struct{}{}
+
+	// The stream comes back on its own, with the session initialized again
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		remote.mu.Lock()
+		initializes := remote.initializes
+		remote.mu.Unlock()
+		if initializes == 2 && runtime.IsReady() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("runtime did not reconnect, %d initializes", initializes)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if method := execMethod(t, runtime, 2, "tools/list"); method != "tools/list" {
+		t.Fatalf("unexpected response for %s", method)
+	}
+}
+
+func TestRemoteRuntimeLegacySSERejectsForeignEndpoint(t *testing.T) {
+	t.Setenv("TEST_REMOTE_KEY", "expanded-secret")
+	server, remote := startFakeRemoteServer(t)
+	remote.endpoint = "https://attacker.example/messages"
+
+	factory := NewRemoteRuntimeFactory(nil)
+	runtime := factory.CreateRemoteRuntime(server.URL+"/sse", pkg.TransportSSE, map[string]string{"X-Api-Key": "${TEST_REMOTE_KEY}"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := runtime.Start(ctx); err == nil {
+		runtime.Stop(context.Background())
+		t.Fatal("expected an endpoint on another host to be refused")
+	}
+}
@@ -0,0 +1,268 @@
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+var errSessionClosed = errors.New("stdio session closed")
+
+// maxStderrLineSize bounds one logged line of a server's stderr
+const maxStderrLineSize = 1024 * 1024
+
+// logStderr logs what a server writes to stderr, line by line. Whatever
+// cannot be logged is still read, since a server blocked on a full stderr
+// pipe stops answering on stdout too.
+func logStderr(logger *log.Logger, stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 64*1024), maxStderrLineSize)
+	for scanner.Scan() {
+		logger.Info(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Warn("Stopped logging server stderr", "error", err)
+	}
+	io.Copy(io.Discard, stderr)
+}
+
+// stdioSession multiplexes JSON-RPC messages over a single long-lived
+// stdin/stdout pair. Requests get a session-unique id on the way in and the
+// caller's id is restored on the matching response, so concurrent callers can
+// reuse the same ids without stepping on each other. Messages the server
+// sends on its own initiative go to the handler, which may be nil.
+type stdioSession struct {
+	stdin   io.WriteCloser
+	writeMu sync.Mutex
+	handler pkg.MessageHandler
+
+	mu      sync.Mutex
+	pending map[int64]chan []byte
+	nextID  int64
+	err     error
+	done    chan struct{}
+}
+
+func newStdioSession(stdin io.WriteCloser, stdout io.Reader, handler pkg.MessageHandler) *stdioSession {
+	s := &stdioSession{
+		stdin:   stdin,
+		handler: handler,
+		pending: make(map[int64]chan []byte),
+		done:    make(chan struct{}),
+	}
+	go s.readLoop(stdout)
+	return s
+}
+
+// Exec writes a JSON-RPC message to the server. Requests block until the
+// response with the same id arrives; notifications and responses return
+// immediately with a nil payload.
+//
+// The input is never interpreted by a shell: it is decoded and re-encoded as
+// compact JSON, which guarantees it occupies exactly one line on the wire no
+// matter what the tool arguments contain.
+func (s *stdioSession) Exec(ctx context.Context, input []byte) ([]byte, error) {
+	var message map[string]json.RawMessage
+	if err := json.Unmarshal(input, &message); err != nil {
+		return nil, fmt.Errorf("invalid JSON-RPC message: %w", err)
+	}
+
+	originalID, hasID := message["id"]
+	_, hasMethod := message["method"]
+	if !hasID || string(originalID) == "null" || !hasMethod {
+		payload, err := json.Marshal(message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal message: %w", err)
+		}
+		return nil, s.write(payload)
+	}
+
+	upstreamID, responseCh, err := s.register()
+	if err != nil {
+		return nil, err
+	}
+	defer s.unregister(upstreamID)
+
+	message["id"] = json.RawMessage(fmt.Sprintf("%d", upstreamID))
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	if err := s.write(payload); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		// Tell the server to stop working on a request nobody waits for
+		s.write(cancelledNotification(upstreamID, ctx.Err()))
+		return nil, ctx.Err()
+	case <-s.done:
+		return nil, s.closeErr()
+	case response := <-responseCh:
+		return restoreID(response, originalID)
+	}
+}
+
+// Done is closed once the server's stdout is gone.
+func (s *stdioSession) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *stdioSession) Close() error {
+	return s.stdin.Close()
+}
+
+func (s *stdioSession) register() (int64, chan []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return 0, nil, s.err
+	}
+
+	s.nextID++
+	responseCh := make(chan []byte, 1)
+	s.pending[s.nextID] = responseCh
+	return s.nextID, responseCh, nil
+}
+
+func (s *stdioSession) unregister(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, id)
+}
+
+func (s *stdioSession) closeErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *stdioSession) write(payload []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	line := make([]byte, 0, len(payload)+1)
+	line = append(append(line, payload...), '\n')
+	if _, err := s.stdin.Write(line); err != nil {
+		return fmt.Errorf("failed to write to server stdin: %w", err)
+	}
+	return nil
+}
+
+func (s *stdioSession) readLoop(stdout io.Reader) {
+	reader := bufio.NewReader(stdout)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			s.dispatch(line)
+		}
+		if err != nil {
+			s.shutdown(err)
+			return
+		}
+	}
+}
+
+func (s *stdioSession) dispatch(line []byte) {
+	var envelope struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	if err := json.Unmarshal(line, &envelope); err != nil {
+		// Servers occasionally print banners to stdout; they are not ours to parse
+		return
+	}
+	if envelope.Method != "" {
+		if s.handler != nil {
+			s.handler(line)
+		}
+		return
+	}
+	if len(envelope.ID) == 0 {
+		return
+	}
+
+	var id int64
+	if err := json.Unmarshal(envelope.ID, &id); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	responseCh, ok := s.pending[id]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	select {
+	case responseCh <- line:
+	default:
+	}
+}
+
+func (s *stdioSession) shutdown(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == io.EOF {
+		err = errSessionClosed
+	}
+	s.err = fmt.Errorf("server stdout closed: %w", err)
+	close(s.done)
+}
+
+// appendHandshake returns the handshake updated with a message that was sent
+// to the server: initialize starts it over and the initialized notification
+// completes it. Other messages leave it as it is.
+func appendHandshake(handshake [][]byte, input []byte) [][]byte {
+	var message struct {
+		Method string `json:"method"`
+	}
+	if json.Unmarshal(input, &message) != nil {
+		return handshake
+	}
+
+	switch message.Method {
+	case "initialize":
+		return [][]byte{bytes.Clone(input)}
+	case "notifications/initialized":
+		if len(handshake) == 1 {
+			return [][]byte{handshake[0], bytes.Clone(input)}
+		}
+	}
+	return handshake
+}
+
+// cancelledNotification tells a server that the request with the upstream
+// id was abandoned
+func cancelledNotification(upstreamID int64, reason error) []byte {
+	notification, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "notifications/cancelled",
+		"params": map[string]interface{}{
+			"requestId": upstreamID,
+			"reason":    reason.Error(),
+		},
+	})
+	return notification
+}
+
+func restoreID(response []byte, id json.RawMessage) ([]byte, error) {
+	var message map[string]json.RawMessage
+	if err := json.Unmarshal(response, &message); err != nil {
+		return nil, fmt.Errorf("invalid JSON-RPC response: %w", err)
+	}
+	message["id"] = id
+	return json.Marshal(message)
+}
@@ -0,0 +1,329 @@
+package runtime
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoServer is a fake MCP server speaking newline-delimited JSON-RPC: every
+// request is answered with its own method and params, notifications are
+// recorded and left unanswered.
+type echoServer struct {
+	stdin         *io.PipeReader
+	stdout        *io.PipeWriter
+	notifications chan map[string]interface{}
+}
+
+func startEchoServer(t *testing.T) *stdioSession {
+	t.Helper()
+
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	server := &echoServer{
+		stdin:         stdinReader,
+		stdout:        stdoutWriter,
+		notifications: make(chan map[string]interface{}, 16),
+	}
+	go server.serve()
+
+	session := newStdioSession(stdinWriter, stdoutReader, nil)
+	t.Cleanup(func() {
+		session.Close()
+		stdoutWriter.Close()
+	})
+	return session
+}
+
+func (e *echoServer) serve() {
+	reader := bufio.NewReader(e.stdin)
+	var writeMu sync.Mutex
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			e.stdout.CloseWithError(err)
+			return
+		}
+
+		var request map[string]interface{}
+		if err := json.Unmarshal(line, &request); err != nil {
+			continue
+		}
+		if _, ok := request["id"]; !ok {
+			e.notifications <- request
+			continue
+		}
+
+		// Answer out of order to exercise id correlation
+		go func(request map[string]interface{}) {
+			time.Sleep(time.Duration(len(line)%7) * time.Millisecond)
+			response, _ := json.Marshal(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      request["id"],
+				"result": map[string]interface{}{
+					"method": request["method"],
+					"params": request["params"],
+				},
+			})
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			e.stdout.Write(append(response, '\n'))
+		}(request)
+	}
+}
+
+func TestStdioSessionCorrelatesConcurrentRequests(t *testing.T) {
+	session := startEchoServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// Every caller uses the same id; the session must keep them apart
+			request := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"n":%d}}`, i)
+			output, err := session.Exec(ctx, []byte(request))
+			if err != nil {
+				t.Errorf("request %d failed: %v", i, err)
+				return
+			}
+
+			var response struct {
+				ID     int `json:"id"`
+				Result struct {
+					Params struct {
+						N int `json:"n"`
+					} `json:"params"`
+				} `json:"result"`
+			}
+			if err := json.Unmarshal(output, &response); err != nil {
+				t.Errorf("request %d: invalid response %s: %v", i, output, err)
+				return
+			}
+			if response.ID != 1 {
+				t.Errorf("request %d: expected caller id 1, got %d", i, response.ID)
+			}
+			if response.Result.Params.N != i {
+				t.Errorf("request %d: got response for request %d", i, response.Result.Params.N)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestStdioSessionNotificationsDoNotWait(t *testing.T) {
+	session := startEchoServer(t)
+
+	output, err := session.Exec(context.Background(), []byte(`{"jsonrpc":"2.0","method":"notifications/initialized"}`))
+	if err != nil {
+		t.Fatalf("notification failed: %v", err)
+	}
+	if output != nil {
+		t.Fatalf("expected no output for a notification, got %s", output)
+	}
+}
+
+func TestStdioSessionHandsServerMessagesToHandler(t *testing.T) {
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	go io.Copy(io.Discard, stdinReader)
+
+	messages := make(chan string, 1)
+	session := newStdioSession(stdinWriter, stdoutReader, func(message []byte) {
+		messages <- string(message)
+	})
+	defer session.Close()
+
+	notification := `{"jsonrpc":"2.0","method":"notifications/tools/list_changed"}`
+	go stdoutWriter.Write([]byte(notification + "\n"))
+
+	select {
+	case message := <-messages:
+		if message != notification {
+			t.Fatalf("unexpected message %s", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the notification")
+	}
+}
+
+func TestStdioSessionCancelsAbandonedRequests(t *testing.T) {
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	defer stdoutWriter.Close()
+
+	session := newStdioSession(stdinWriter, stdoutReader, nil)
+	defer session.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := session.Exec(ctx, []byte(`{"jsonrpc":"2.0","id":"client-7","method":"tools/call"}`))
+		errs <- err
+	}()
+
+	reader := bufio.NewReader(stdinReader)
+	var request, cancelled struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+		Params struct {
+			RequestID json.RawMessage `json:"requestId"`
+		} `json:"params"`
+	}
+	line, _ := reader.ReadBytes('\n')
+	json.Unmarshal(line, &request)
+
+	cancel()
+	line, _ = reader.ReadBytes('\n')
+	json.Unmarshal(line, &cancelled)
+
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if cancelled.Method != "notifications/cancelled" {
+		t.Fatalf("expected notifications/cancelled, got %s", line)
+	}
+	if string(cancelled.Params.RequestID) != string(request.ID) {
+		t.Fatalf("expected the upstream id %s, got %s", request.ID, cancelled.Params.RequestID)
+	}
+}
+
+func TestStdioSessionFailsPendingRequestsWhenServerExits(t *testing.T) {
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	go io.Copy(io.Discard, stdinReader)
+
+	session := newStdioSession(stdinWriter, stdoutReader, nil)
+	defer session.Close()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		stdoutWriter.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := session.Exec(ctx, []byte(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+	if err == nil {
+		t.Fatal("expected an error once the server stdout is closed")
+	}
+	if ctx.Err() != nil {
+		t.Fatal("request should fail as soon as the server exits, not on timeout")
+	}
+}
+
+func TestStdioSessionDeliversHostilePayloadsVerbatim(t *testing.T) {
+	session := startEchoServer(t)
+
+	payloads := map[string]string{
+		"single quote":         `it's`,
+		"quote breakout":       `'; rm -rf / #`,
+		"command substitution": `$(id) and ` + "`id`",
+		"variable expansion":   `${HOME} $PATH`,
+		"pipe and redirect":    `a | cat /etc/passwd > /tmp/x`,
+		"newline":              "first line\nsecond line",
+		"carriage return":      "first line\r\nsecond line",
+		"NUL":                  "before\x00after",
+		"control characters":   "\x01\x07\x1b[31m",
+		"unicode separators":   "line\u2028para\u2029end",
+		"json in a string":     `{"jsonrpc":"2.0","id":99,"result":{}}` + "\n",
+		"backslashes":          `C:\path\to\"file"\\`,
+		"multi megabyte":       strings.Repeat("$(x)'\n", 1<<20),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for name, payload := range payloads {
+		t.Run(name, func(t *testing.T) {
+			request, err := json.Marshal(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      "req-1",
+				"method":  "tools/call",
+				"params": map[string]interface{}{
+					"name":      "echo",
+					"arguments": map[string]interface{}{"text": payload},
+				},
+			})
+			if err != nil {
+				t.Fatalf("failed to marshal request: %v", err)
+			}
+
+			output, err := session.Exec(ctx, request)
+			if err != nil {
+				t.Fatalf("exec failed: %v", err)
+			}
+
+			var response struct {
+				ID     string `json:"id"`
+				Result struct {
+					Params struct {
+						Arguments struct {
+							Text string `json:"text"`
+						} `json:"arguments"`
+					} `json:"params"`
+				} `json:"result"`
+			}
+			if err := json.Unmarshal(output, &response); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if response.ID != "req-1" {
+				t.Fatalf("expected caller id req-1, got %q", response.ID)
+			}
+			if response.Result.Params.Arguments.Text != payload {
+				t.Fatalf("payload was altered in transit")
+			}
+		})
+	}
+}
+
+func TestStdioSessionReframesMultilineInput(t *testing.T) {
+	session := startEchoServer(t)
+
+	// Pretty-printed input must still reach the server as a single message
+	request := []byte("{\n  \"jsonrpc\": \"2.0\",\n  \"id\": 7,\n  \"method\": \"ping\"\n}")
+	output, err := session.Exec(context.Background(), request)
+	if err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+
+	var response struct {
+		ID     int `json:"id"`
+		Result struct {
+			Method string `json:"method"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(output, &response); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if response.ID != 7 || response.Result.Method != "ping" {
+		t.Fatalf("unexpected response: %s", output)
+	}
+}
+
+func TestStdioSessionRejectsNonObjectInput(t *testing.T) {
+	session := startEchoServer(t)
+
+	inputs := []string{
+		`not json`,
+		`'; rm -rf / #`,
+		`[{"jsonrpc":"2.0","id":1,"method":"ping"}]`,
+		"{\"jsonrpc\":\"2.0\",\"id\":1,\"method\":\"ping\"}\n{\"jsonrpc\":\"2.0\",\"id\":2,\"method\":\"ping\"}",
+	}
+	for _, input := range inputs {
+		if _, err := session.Exec(context.Background(), []byte(input)); err == nil {
+			t.Errorf("expected %q to be rejected", input)
+		}
+	}
+}
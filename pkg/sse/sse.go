@@ -0,0 +1,91 @@
+package sse
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Event is a single server-sent event
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// Reader parses a text/event-stream body into events
+type Reader struct {
+	reader *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{reader: bufio.NewReader(r)}
+}
+
+// Next returns the next event with data, skipping comments and empty events
+func (r *Reader) Next() (*Event, error) {
+	event := &Event{}
+	var data []string
+
+	for {
+		line, err := r.reader.ReadString('\n')
+		if err != nil && line == "" {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			if len(data) == 0 {
+				event = &Event{}
+				continue
+			}
+			event.Data = strings.Join(data, "\n")
+			return event, nil
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "":
+			// Comment line, used as keep-alive
+		case "id":
+			event.ID = value
+		case "event":
+			event.Event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+}
+
+// Writer encodes events onto a text/event-stream body
+type Writer struct {
+	writer io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{writer: w}
+}
+
+func (w *Writer) WriteEvent(event *Event) error {
+	var b strings.Builder
+	if event.ID != "" {
+		b.WriteString("id: " + event.ID + "\n")
+	}
+	if event.Event != "" {
+		b.WriteString("event: " + event.Event + "\n")
+	}
+	for _, line := range strings.Split(event.Data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(w.writer, b.String())
+	return err
+}
+
+// WriteComment writes a comment line, which clients ignore; used as keep-alive
+func (w *Writer) WriteComment(comment string) error {
+	_, err := io.WriteString(w.writer, ": "+comment+"\n\n")
+	return err
+}
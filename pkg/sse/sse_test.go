@@ -0,0 +1,62 @@
+package sse
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReaderParsesEvents(t *testing.T) {
+	stream := ": keep-alive\n\n" +
+		"event: endpoint\ndata: /messages?session=1\n\n" +
+		"id: 42\r\ndata: {\"a\":\r\ndata: 1}\r\n\r\n" +
+		"event: ignored\n\n" +
+		"data:no-space\n\n"
+
+	reader := NewReader(strings.NewReader(stream))
+	expected := []Event{
+		{Event: "endpoint", Data: "/messages?session=1"},
+		{ID: "42", Data: "{\"a\":\n1}"},
+		{Data: "no-space"},
+	}
+	for _, want := range expected {
+		got, err := reader.Next()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if *got != want {
+			t.Fatalf("expected %+v, got %+v", want, *got)
+		}
+	}
+
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	var buffer strings.Builder
+	writer := NewWriter(&buffer)
+
+	events := []Event{
+		{Event: "message", Data: `{"jsonrpc":"2.0","id":1,"result":{}}`},
+		{ID: "7", Data: "multi\nline"},
+	}
+	writer.WriteComment("keep-alive")
+	for i := range events {
+		if err := writer.WriteEvent(&events[i]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	reader := NewReader(strings.NewReader(buffer.String()))
+	for _, want := range events {
+		got, err := reader.Next()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if *got != want {
+			t.Fatalf("expected %+v, got %+v", want, *got)
+		}
+	}
+}
@@ -9,16 +9,47 @@ type Runtime interface {
 	Exec(ctx context.Context, input []byte) ([]byte, error)
 	Stop(ctx context.Context) error
 	IsReady() bool
+	// SetMessageHandler must be called before Start
+	SetMessageHandler(handler MessageHandler)
+}
+
+// MessageHandler receives the messages a server sends on its own initiative,
+// such as notifications and server-to-client requests. It is called from the
+// runtime's read loop and must not block.
+type MessageHandler func(message []byte)
+
+type messageHandlerKey struct{}
+
+// WithMessageHandler attaches to ctx the handler for the messages a server
+// sends while working on the request ctx is for. Runtimes whose transport
+// ties such messages to the request, like Streamable HTTP, deliver them there
+// rather than to the runtime's handler.
+func WithMessageHandler(ctx context.Context, handler MessageHandler) context.Context {
+	return context.WithValue(ctx, messageHandlerKey{}, handler)
+}
+
+// MessageHandlerFromContext returns the handler attached by WithMessageHandler
+func MessageHandlerFromContext(ctx context.Context) (MessageHandler, bool) {
+	handler, ok := ctx.Value(messageHandlerKey{}).(MessageHandler)
+	return handler, ok
 }
 
 type RuntimeFactory interface {
-	CreateRuntime(image, command string, args []string, env map[string]string) Runtime
+	// CreateRuntime creates the runtime of the server called name, which is
+	// unique among the configured servers
+	CreateRuntime(name, image, command string, args []string, env map[string]string) Runtime
+}
+
+// RemoteRuntimeFactory creates runtimes for MCP servers that are already
+// hosted elsewhere and reached over HTTP
+type RemoteRuntimeFactory interface {
+	CreateRemoteRuntime(url, transport string, headers map[string]string) Runtime
 }
 
 // MCPRequest represents an MCP protocol request
 type MCPRequest struct {
 	JSONRPC string      `json:"jsonrpc"`
-	ID      interface{} `json:"id"`
+	ID      interface{} `json:"id,omitempty"`
 	Method  string      `json:"method"`
 	Params  interface{} `json:"params,omitempty"`
 }
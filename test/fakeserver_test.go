@@ -0,0 +1,103 @@
+package test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/charmbracelet/log"
+	"github.com/nsxbet/mcpshield/pkg"
+)
+
+// fakeServerEnv makes the test binary act as a stdio MCP server instead of
+// running the tests, so the process runtime can be exercised without npx
+const fakeServerEnv = "MCPSHIELD_FAKE_SERVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeServerEnv) != "" {
+		serveFakeMCP(os.Stdin, os.Stdout)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeServerConfig returns a server entry that spawns the fake MCP server
+func fakeServerConfig(name string) pkg.MCPServerConfig {
+	return pkg.MCPServerConfig{
+		Name:    name,
+		Command: os.Args[0],
+		Env:     map[string]string{fakeServerEnv: name},
+	}
+}
+
+func testLogger() *log.Logger {
+	return log.NewWithOptions(io.Discard, log.Options{})
+}
+
+func serveFakeMCP(in io.Reader, out io.Writer) {
+	fmt.Fprintln(os.Stderr, "fake MCP server running on stdio")
+
+	initialized := false
+	reader := bufio.NewReader(in)
+	encoder := json.NewEncoder(out)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+
+		var request struct {
+			ID     interface{}            `json:"id"`
+			Method string                 `json:"method"`
+			Params map[string]interface{} `json:"params"`
+		}
+		if err := json.Unmarshal(line, &request); err != nil {
+			continue
+		}
+
+		if request.ID == nil {
+			if request.Method == "notifications/initialized" {
+				initialized = true
+			}
+			continue
+		}
+
+		response := map[string]interface{}{"jsonrpc": "2.0", "id": request.ID}
+		switch {
+		case request.Method == "initialize":
+			response["result"] = map[string]interface{}{
+				"protocolVersion": "2025-03-26",
+				"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+				"serverInfo":      map[string]interface{}{"name": os.Getenv(fakeServerEnv), "version": "0.0.1"},
+			}
+		case !initialized:
+			response["error"] = map[string]interface{}{"code": -32002, "message": "server not initialized"}
+		case request.Method == "tools/list":
+			response["result"] = map[string]interface{}{
+				"tools": []interface{}{
+					map[string]interface{}{
+						"name":        "echo",
+						"description": "Echoes the text argument",
+						"inputSchema": map[string]interface{}{
+							"type":       "object",
+							"properties": map[string]interface{}{"text": map[string]interface{}{"type": "string"}},
+						},
+					},
+				},
+			}
+		case request.Method == "tools/call":
+			arguments, _ := request.Params["arguments"].(map[string]interface{})
+			response["result"] = map[string]interface{}{
+				"content": []interface{}{
+					map[string]interface{}{"type": "text", "text": arguments["text"]},
+				},
+			}
+		default:
+			response["error"] = map[string]interface{}{"code": -32601, "message": "method not found"}
+		}
+		encoder.Encode(response)
+	}
+}
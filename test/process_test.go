@@ -0,0 +1,65 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nsxbet/mcpshield/pkg"
+	"github.com/nsxbet/mcpshield/pkg/mcpserver"
+	"github.com/nsxbet/mcpshield/pkg/runtime"
+)
+
+func TestProxyWithProcessRuntime(t *testing.T) {
+	processConfig := &pkg.Config{
+		MCPServers: []pkg.MCPServerConfig{fakeServerConfig("fake")},
+	}
+	factory := runtime.NewProcessRuntimeFactory("", testLogger())
+	proxy, err := mcpserver.NewProxy(processConfig, factory)
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := proxy.Start(ctx); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer proxy.Stop(context.Background())
+
+	listResponse, err := proxy.ProcessList(&pkg.MCPRequest{JSONRPC: "2.0", ID: 1, Method: "tools/list"})
+	if err != nil {
+		t.Fatalf("ProcessList failed: %v", err)
+	}
+	tools := listResponse.Result.(map[string]interface{})["tools"].([]interface{})
+	if len(tools) != 1 {
+		t.Fatalf("Expected one tool, got %d", len(tools))
+	}
+	if name := tools[0].(map[string]interface{})["name"]; name != "ms_fake_echo" {
+		t.Fatalf("Expected ms_fake_echo, got %v", name)
+	}
+
+	// Two calls in a row hit the same process, which only answers once initialized
+	for _, text := range []string{"first", "it's $(still) safe"} {
+		callResponse, err := proxy.ProcessCall(context.Background(), &pkg.MCPRequest{
+			JSONRPC: "2.0",
+			ID:      2,
+			Method:  "tools/call",
+			Params: map[string]interface{}{
+				"name":      "ms_fake_echo",
+				"arguments": map[string]interface{}{"text": text},
+			},
+		})
+		if err != nil {
+			t.Fatalf("ProcessCall failed: %v", err)
+		}
+		if callResponse.Error != nil {
+			t.Fatalf("Unexpected error response: %v", callResponse.Error)
+		}
+		content := callResponse.Result.(map[string]interface{})["content"].([]interface{})
+		if got := content[0].(map[string]interface{})["text"]; got != text {
+			t.Fatalf("Expected %q, got %v", text, got)
+		}
+	}
+}
@@ -29,7 +29,10 @@ func TestProcessList(t *testing.T) {
 		t.Skipf("Skipping test: failed to create Kubernetes client: %v", err)
 	}
 
-	proxy := mcpserver.NewProxy(config, factory)
+	proxy, err := mcpserver.NewProxy(config, factory)
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
 	
 	ctx := context.Background()
 	err = proxy.Start(ctx)
@@ -92,7 +95,10 @@ func TestProcessCall(t *testing.T) {
 		t.Skipf("Skipping test: failed to create Kubernetes client: %v", err)
 	}
 
-	proxy := mcpserver.NewProxy(config, factory)
+	proxy, err := mcpserver.NewProxy(config, factory)
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
 	
 	ctx := context.Background()
 	err = proxy.Start(ctx)
@@ -115,7 +121,7 @@ func TestProcessCall(t *testing.T) {
 		},
 	}
 
-	response, err := proxy.ProcessCall(request)
+	response, err := proxy.ProcessCall(ctx, request)
 	if err != nil {
 		t.Logf("⚠️  ProcessCall error (expected for auth issues): %v", err)
 		return